@@ -0,0 +1,108 @@
+// backfillledger posts an opening balance from the system account for every user
+// that has no ledger postings yet, so balances from before the ledger existed can
+// be reconciled. Run it once before the ledger goes live, users that already have
+// postings are left alone.
+//
+//	go run ./cmd/backfillledger -dry-run
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"time"
+
+	"github.com/joho/godotenv"
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/ChronoPlay/chronoplay-backend-service/database"
+	model "github.com/ChronoPlay/chronoplay-backend-service/model"
+)
+
+func main() {
+	dryRun := flag.Bool("dry-run", false, "report the opening balances without writing them")
+	flag.Parse()
+
+	err := godotenv.Load()
+	if err != nil {
+		log.Println("Warning: No .env file found or error loading it")
+	}
+	database.ConnectMongo()
+	dbName := os.Getenv("MONGO_DB_NAME")
+	if dbName == "" {
+		log.Fatal("MONGO_DB_NAME environment variable not set")
+	}
+	db := database.MongoClient.Database(dbName)
+	ledgerRepo := model.NewLedgerRepository(db.Collection("ledger_entries"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	cursor, err := db.Collection("users").Find(ctx, bson.M{})
+	if err != nil {
+		log.Fatalf("Failed to fetch users: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	backfilled := 0
+	for cursor.Next(ctx) {
+		var user model.User
+		if err := cursor.Decode(&user); err != nil {
+			log.Fatalf("Failed to decode user: %v", err)
+		}
+		hasPostings, cerr := ledgerRepo.HasPostings(ctx, user.UserId)
+		if cerr != nil {
+			log.Fatalf("Failed to check postings of user %d: %v", user.UserId, cerr)
+		}
+		if hasPostings {
+			continue
+		}
+		postings := openingPostings(user)
+		if len(postings) == 0 {
+			continue
+		}
+		log.Printf("User %d: opening balance of %s cash and %d card types", user.UserId, user.Cash, len(user.Cards))
+		backfilled++
+		if *dryRun {
+			continue
+		}
+		if cerr := ledgerRepo.AddPostings(ctx, postings); cerr != nil {
+			log.Fatalf("Failed to post opening balance of user %d: %v", user.UserId, cerr)
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		log.Fatalf("Failed to iterate users: %v", err)
+	}
+	if *dryRun {
+		log.Printf("Dry run finished, nothing was written. %d users would get an opening balance", backfilled)
+		return
+	}
+	log.Printf("Backfill finished. %d users got an opening balance", backfilled)
+}
+
+// locked collateral and held assets are still owned by the user, so they are part of
+// the opening balance
+func openingPostings(user model.User) []model.LedgerPosting {
+	movements := []model.LedgerMovement{{
+		Asset:  model.LEDGER_ASSET_CASH,
+		Amount: int64(user.Cash) + int64(user.HeldCash),
+	}}
+	for _, card := range user.Cards {
+		movements = append(movements, model.LedgerMovement{
+			Asset:  card.CardNumber,
+			Amount: int64(card.Occupied) + int64(card.Locked) + int64(card.Held),
+		})
+	}
+	postings := []model.LedgerPosting{}
+	for _, movement := range movements {
+		if movement.Amount <= 0 {
+			continue
+		}
+		movement.Kind = model.LEDGER_KIND_OPENING_BALANCE
+		movement.From = model.LEDGER_ACCOUNT_SYSTEM
+		movement.To = user.UserId
+		postings = append(postings, movement.Postings()...)
+	}
+	return postings
+}
@@ -0,0 +1,121 @@
+// backfillowners rebuilds Card.Owners from the cards users hold. Owners are kept in
+// sync by every balance change, so this is only needed once for cards that were
+// handed out before that, or after fixing balances by hand.
+//
+//	go run ./cmd/backfillowners -dry-run
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/joho/godotenv"
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/ChronoPlay/chronoplay-backend-service/database"
+	model "github.com/ChronoPlay/chronoplay-backend-service/model"
+)
+
+func main() {
+	dryRun := flag.Bool("dry-run", false, "report the cards with wrong owners without writing them")
+	flag.Parse()
+
+	err := godotenv.Load()
+	if err != nil {
+		log.Println("Warning: No .env file found or error loading it")
+	}
+	database.ConnectMongo()
+	dbName := os.Getenv("MONGO_DB_NAME")
+	if dbName == "" {
+		log.Fatal("MONGO_DB_NAME environment variable not set")
+	}
+	db := database.MongoClient.Database(dbName)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	// locked collateral and held assets are still owned by the user
+	owners := make(map[string][]uint32)
+	userCursor, err := db.Collection("users").Find(ctx, bson.M{"cards.0": bson.M{"$exists": true}})
+	if err != nil {
+		log.Fatalf("Failed to fetch users: %v", err)
+	}
+	defer userCursor.Close(ctx)
+	for userCursor.Next(ctx) {
+		var user model.User
+		if err := userCursor.Decode(&user); err != nil {
+			log.Fatalf("Failed to decode user: %v", err)
+		}
+		for _, card := range user.Cards {
+			if card.Occupied > 0 || card.Locked > 0 || card.Held > 0 {
+				owners[card.CardNumber] = append(owners[card.CardNumber], user.UserId)
+			}
+		}
+	}
+	if err := userCursor.Err(); err != nil {
+		log.Fatalf("Failed to iterate users: %v", err)
+	}
+
+	cards := db.Collection("cards")
+	cardCursor, err := cards.Find(ctx, bson.M{})
+	if err != nil {
+		log.Fatalf("Failed to fetch cards: %v", err)
+	}
+	defer cardCursor.Close(ctx)
+	fixed := 0
+	for cardCursor.Next(ctx) {
+		var card model.Card
+		if err := cardCursor.Decode(&card); err != nil {
+			log.Fatalf("Failed to decode card: %v", err)
+		}
+		expected := owners[card.Number]
+		if expected == nil {
+			expected = []uint32{}
+		}
+		sort.Slice(expected, func(i, j int) bool { return expected[i] < expected[j] })
+		if card.Owners != nil && sameOwners(card.Owners, expected) {
+			continue
+		}
+		log.Printf("Card %s: %d owners stored, %d hold it", card.Number, len(card.Owners), len(expected))
+		fixed++
+		if *dryRun {
+			continue
+		}
+		_, err := cards.UpdateOne(ctx, bson.M{"_id": card.ID}, bson.M{
+			"$set": bson.M{"owners": expected},
+			"$inc": bson.M{"version": 1},
+		})
+		if err != nil {
+			log.Fatalf("Failed to update owners of card %s: %v", card.Number, err)
+		}
+	}
+	if err := cardCursor.Err(); err != nil {
+		log.Fatalf("Failed to iterate cards: %v", err)
+	}
+	if *dryRun {
+		log.Printf("Dry run finished, nothing was written. %d cards would get their owners rebuilt", fixed)
+		return
+	}
+	log.Printf("Backfill finished. %d cards got their owners rebuilt", fixed)
+}
+
+// order doesn't matter, the stored owners are in the order users got the card
+func sameOwners(stored []uint32, expected []uint32) bool {
+	if len(stored) != len(expected) {
+		return false
+	}
+	seen := make(map[uint32]bool)
+	for _, owner := range stored {
+		seen[owner] = true
+	}
+	for _, owner := range expected {
+		if !seen[owner] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,122 @@
+// migratemoney converts cash fields stored as floating point numbers into integer
+// minor units (model.Money). Every value that could not be represented exactly in
+// cents is reported together with the rounding that was applied.
+//
+//	go run ./cmd/migratemoney -dry-run
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/ChronoPlay/chronoplay-backend-service/database"
+	model "github.com/ChronoPlay/chronoplay-backend-service/model"
+)
+
+type moneyField struct {
+	collection string
+	field      string
+}
+
+var moneyFields = []moneyField{
+	{collection: "users", field: "cash"},
+	{collection: "cash_transactions", field: "amount"},
+	{collection: "loans", field: "amount"},
+	{collection: "loans", field: "interest_accumulated"},
+	{collection: "loans", field: "paid_amount"},
+	{collection: "loans", field: "recovery.cash"},
+}
+
+type migrationReport struct {
+	converted int
+	rounded   int
+}
+
+func main() {
+	dryRun := flag.Bool("dry-run", false, "report the conversions without writing them")
+	flag.Parse()
+
+	err := godotenv.Load()
+	if err != nil {
+		log.Println("Warning: No .env file found or error loading it")
+	}
+	database.ConnectMongo()
+	dbName := os.Getenv("MONGO_DB_NAME")
+	if dbName == "" {
+		log.Fatal("MONGO_DB_NAME environment variable not set")
+	}
+	db := database.MongoClient.Database(dbName)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	total := migrationReport{}
+	for _, field := range moneyFields {
+		report, err := migrateField(ctx, db.Collection(field.collection), field.field, *dryRun)
+		if err != nil {
+			log.Fatalf("Failed to migrate %s.%s: %v", field.collection, field.field, err)
+		}
+		log.Printf("%s.%s: converted %d documents, %d needed rounding", field.collection, field.field, report.converted, report.rounded)
+		total.converted += report.converted
+		total.rounded += report.rounded
+	}
+	if *dryRun {
+		log.Printf("Dry run finished, nothing was written. %d values would be converted, %d rounded", total.converted, total.rounded)
+		return
+	}
+	log.Printf("Migration finished. %d values converted, %d rounded", total.converted, total.rounded)
+}
+
+func migrateField(ctx context.Context, col *mongo.Collection, field string, dryRun bool) (migrationReport, error) {
+	report := migrationReport{}
+	// anything not already stored as an integer still has to be converted
+	filter := bson.M{field: bson.M{"$type": bson.A{"double", "decimal"}}}
+	cursor, err := col.Find(ctx, filter)
+	if err != nil {
+		return report, err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		id := cursor.Current.Lookup("_id")
+		value, lerr := cursor.Current.LookupErr(strings.Split(field, ".")...)
+		if lerr != nil {
+			continue
+		}
+		var original float64
+		switch value.Type {
+		case bson.TypeDouble:
+			original = value.Double()
+		case bson.TypeDecimal128:
+			original, err = strconv.ParseFloat(value.Decimal128().String(), 64)
+			if err != nil {
+				return report, err
+			}
+		}
+		converted := model.NewMoneyFromFloat(original)
+		// values were written as float32, so compare at that precision to tell real rounding from noise
+		if float32(converted.Float64()) != float32(original) {
+			report.rounded++
+			log.Printf("%s %v: %s %v rounded to %s (difference %.6f)", col.Name(), id, field, original, converted, math.Abs(converted.Float64()-original))
+		}
+		report.converted++
+		if dryRun {
+			continue
+		}
+		_, err = col.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{field: converted}})
+		if err != nil {
+			return report, err
+		}
+	}
+	return report, cursor.Err()
+}
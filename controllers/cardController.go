@@ -16,6 +16,12 @@ type cardController struct {
 type CardController interface {
 	AddCard(c *gin.Context)
 	GetCard(c *gin.Context)
+	GetCatalog(c *gin.Context)
+	GetRarities(c *gin.Context)
+	UpdateCard(c *gin.Context)
+	RetireCard(c *gin.Context)
+	GetCardAudits(c *gin.Context)
+	GetTopHolders(c *gin.Context)
 }
 
 func NewCardController(cardService service.CardService) CardController {
@@ -74,3 +80,120 @@ func (cardCtrl *cardController) GetCard(c *gin.Context) {
 		Message: "Card Name Found successfully",
 	})
 }
+
+func (cardCtrl *cardController) GetCatalog(c *gin.Context) {
+	req, err := mapper.DecodeGetCatalogRequest(c)
+	if err != nil {
+		c.JSON(int(err.Code), constants.JsonResp{
+			Message: err.Message,
+		})
+		return
+	}
+	ctx := c.Request.Context()
+	res, err := cardCtrl.cardService.GetCatalog(ctx, req)
+	if err != nil {
+		c.JSON(int(err.Code), constants.JsonResp{
+			Message: err.Message,
+		})
+		return
+	}
+	c.JSON(200, constants.JsonResp{
+		Data:    res,
+		Message: "Cards fetched successfully",
+	})
+}
+
+func (cardCtrl *cardController) GetRarities(c *gin.Context) {
+	c.JSON(200, constants.JsonResp{
+		Data:    mapper.EncodeCardRarities(),
+		Message: "Card rarities fetched successfully",
+	})
+}
+
+func (cardCtrl *cardController) UpdateCard(c *gin.Context) {
+	req, err := mapper.DecodeUpdateCardRequest(c)
+	if err != nil {
+		c.JSON(int(err.Code), constants.JsonResp{
+			Message: err.Message,
+		})
+		return
+	}
+	ctx := c.Request.Context()
+	res, err := cardCtrl.cardService.UpdateCard(ctx, req)
+	if err != nil {
+		c.JSON(int(err.Code), constants.JsonResp{
+			Message: err.Message,
+		})
+		return
+	}
+	c.JSON(200, constants.JsonResp{
+		Data:    res,
+		Message: "Card updated successfully",
+	})
+}
+
+func (cardCtrl *cardController) RetireCard(c *gin.Context) {
+	req, err := mapper.DecodeRetireCardRequest(c)
+	if err != nil {
+		c.JSON(int(err.Code), constants.JsonResp{
+			Message: err.Message,
+		})
+		return
+	}
+	ctx := c.Request.Context()
+	err = cardCtrl.cardService.RetireCard(ctx, req)
+	if err != nil {
+		c.JSON(int(err.Code), constants.JsonResp{
+			Message: err.Message,
+		})
+		return
+	}
+	c.JSON(200, constants.JsonResp{
+		Data:    "",
+		Message: "Card retired successfully",
+	})
+}
+
+func (cardCtrl *cardController) GetCardAudits(c *gin.Context) {
+	req, err := mapper.DecodeGetCardAuditsRequest(c)
+	if err != nil {
+		c.JSON(int(err.Code), constants.JsonResp{
+			Message: err.Message,
+		})
+		return
+	}
+	ctx := c.Request.Context()
+	audits, err := cardCtrl.cardService.GetCardAudits(ctx, req)
+	if err != nil {
+		c.JSON(int(err.Code), constants.JsonResp{
+			Message: err.Message,
+		})
+		return
+	}
+	c.JSON(200, constants.JsonResp{
+		Data:    audits,
+		Message: "Card audits fetched successfully",
+	})
+}
+
+func (cardCtrl *cardController) GetTopHolders(c *gin.Context) {
+	req, err := mapper.DecodeGetTopHoldersRequest(c)
+	if err != nil {
+		c.JSON(int(err.Code), constants.JsonResp{
+			Message: err.Message,
+		})
+		return
+	}
+	ctx := c.Request.Context()
+	res, err := cardCtrl.cardService.GetTopHolders(ctx, req)
+	if err != nil {
+		c.JSON(int(err.Code), constants.JsonResp{
+			Message: err.Message,
+		})
+		return
+	}
+	c.JSON(200, constants.JsonResp{
+		Data:    res,
+		Message: "Top holders fetched successfully",
+	})
+}
@@ -0,0 +1,50 @@
+package controller
+
+import (
+	"github.com/ChronoPlay/chronoplay-backend-service/constants"
+	"github.com/ChronoPlay/chronoplay-backend-service/mapper"
+	service "github.com/ChronoPlay/chronoplay-backend-service/services"
+	"github.com/gin-gonic/gin"
+)
+
+type ledgerController struct {
+	ledgerService service.LedgerService
+}
+
+type LedgerController interface {
+	ReconcileBalance(*gin.Context)
+}
+
+func NewLedgerController(ledgerService service.LedgerService) LedgerController {
+	return &ledgerController{
+		ledgerService: ledgerService,
+	}
+}
+
+func (ctl *ledgerController) ReconcileBalance(c *gin.Context) {
+	req, err := mapper.DecodeReconcileBalanceRequest(c)
+	if err != nil {
+		c.JSON(int(err.Code), constants.JsonResp{
+			Message: err.Message,
+		})
+		return
+	}
+	ctx := c.Request.Context()
+	resp, err := ctl.ledgerService.ReconcileUserBalance(ctx, req)
+	if err != nil {
+		c.JSON(int(err.Code), constants.JsonResp{
+			Message: err.Message,
+		})
+		return
+	}
+	message := "Balance matches the ledger"
+	if resp.Applied {
+		message = "Balance rebuilt from the ledger"
+	} else if len(resp.Discrepancies) > 0 {
+		message = "Balance does not match the ledger"
+	}
+	c.JSON(200, constants.JsonResp{
+		Data:    resp,
+		Message: message,
+	})
+}
@@ -1,12 +1,21 @@
 package controller
 
-import service "github.com/ChronoPlay/chronoplay-backend-service/services"
+import (
+	"github.com/ChronoPlay/chronoplay-backend-service/constants"
+	"github.com/ChronoPlay/chronoplay-backend-service/mapper"
+	service "github.com/ChronoPlay/chronoplay-backend-service/services"
+	"github.com/gin-gonic/gin"
+)
 
 type loanController struct {
 	loanService service.LoanService
 }
 
 type LoanController interface {
+	RequestLoan(*gin.Context)
+	RespondLoan(*gin.Context)
+	GetLoans(*gin.Context)
+	RepayLoan(*gin.Context)
 }
 
 func NewLoanController(loanService service.LoanService) LoanController {
@@ -14,3 +23,95 @@ func NewLoanController(loanService service.LoanService) LoanController {
 		loanService: loanService,
 	}
 }
+
+func (ctl *loanController) RequestLoan(c *gin.Context) {
+	req, err := mapper.DecodeRequestLoanRequest(c)
+	if err != nil {
+		c.JSON(int(err.Code), constants.JsonResp{
+			Message: err.Message,
+		})
+		return
+	}
+	ctx := c.Request.Context()
+	resp, err := ctl.loanService.RequestLoan(ctx, req)
+	if err != nil {
+		c.JSON(int(err.Code), constants.JsonResp{
+			Message: err.Message,
+		})
+		return
+	}
+	c.JSON(200, constants.JsonResp{
+		Data:    resp,
+		Message: "Loan requested successfully. Please wait for the lender to respond.",
+	})
+}
+
+func (ctl *loanController) RespondLoan(c *gin.Context) {
+	req, err := mapper.DecodeRespondLoanRequest(c)
+	if err != nil {
+		c.JSON(int(err.Code), constants.JsonResp{
+			Message: err.Message,
+		})
+		return
+	}
+	ctx := c.Request.Context()
+	err = ctl.loanService.RespondLoan(ctx, req)
+	if err != nil {
+		c.JSON(int(err.Code), constants.JsonResp{
+			Message: err.Message,
+		})
+		return
+	}
+	message := "Loan declined successfully"
+	if req.IsAccepted {
+		message = "Loan accepted successfully"
+	}
+	c.JSON(200, constants.JsonResp{
+		Data:    "",
+		Message: message,
+	})
+}
+
+func (ctl *loanController) GetLoans(c *gin.Context) {
+	req, err := mapper.DecodeGetLoansRequest(c)
+	if err != nil {
+		c.JSON(int(err.Code), constants.JsonResp{
+			Message: err.Message,
+		})
+		return
+	}
+	ctx := c.Request.Context()
+	resp, err := ctl.loanService.GetLoans(ctx, req)
+	if err != nil {
+		c.JSON(int(err.Code), constants.JsonResp{
+			Message: err.Message,
+		})
+		return
+	}
+	c.JSON(200, constants.JsonResp{
+		Data:    resp.Loans,
+		Message: "Loans fetched successfully",
+	})
+}
+
+func (ctl *loanController) RepayLoan(c *gin.Context) {
+	req, err := mapper.DecodeRepayLoanRequest(c)
+	if err != nil {
+		c.JSON(int(err.Code), constants.JsonResp{
+			Message: err.Message,
+		})
+		return
+	}
+	ctx := c.Request.Context()
+	err = ctl.loanService.RepayLoan(ctx, req)
+	if err != nil {
+		c.JSON(int(err.Code), constants.JsonResp{
+			Message: err.Message,
+		})
+		return
+	}
+	c.JSON(200, constants.JsonResp{
+		Data:    "",
+		Message: "Loan repaid successfully",
+	})
+}
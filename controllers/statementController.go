@@ -0,0 +1,67 @@
+package controller
+
+import (
+	"github.com/ChronoPlay/chronoplay-backend-service/constants"
+	"github.com/ChronoPlay/chronoplay-backend-service/mapper"
+	service "github.com/ChronoPlay/chronoplay-backend-service/services"
+	"github.com/gin-gonic/gin"
+)
+
+type statementController struct {
+	statementService service.StatementService
+}
+
+type StatementController interface {
+	GetStatement(*gin.Context)
+	EmailStatement(*gin.Context)
+}
+
+func NewStatementController(statementService service.StatementService) StatementController {
+	return &statementController{
+		statementService: statementService,
+	}
+}
+
+func (ctl *statementController) GetStatement(c *gin.Context) {
+	req, err := mapper.DecodeGetStatementRequest(c)
+	if err != nil {
+		c.JSON(int(err.Code), constants.JsonResp{
+			Message: err.Message,
+		})
+		return
+	}
+	ctx := c.Request.Context()
+	resp, err := ctl.statementService.GetStatement(ctx, req)
+	if err != nil {
+		c.JSON(int(err.Code), constants.JsonResp{
+			Message: err.Message,
+		})
+		return
+	}
+	c.JSON(200, constants.JsonResp{
+		Data:    resp,
+		Message: "Statement fetched successfully",
+	})
+}
+
+func (ctl *statementController) EmailStatement(c *gin.Context) {
+	req, err := mapper.DecodeEmailStatementRequest(c)
+	if err != nil {
+		c.JSON(int(err.Code), constants.JsonResp{
+			Message: err.Message,
+		})
+		return
+	}
+	ctx := c.Request.Context()
+	resp, err := ctl.statementService.EmailStatement(ctx, req)
+	if err != nil {
+		c.JSON(int(err.Code), constants.JsonResp{
+			Message: err.Message,
+		})
+		return
+	}
+	c.JSON(200, constants.JsonResp{
+		Data:    resp,
+		Message: "Statement emailed successfully",
+	})
+}
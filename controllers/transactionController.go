@@ -1,9 +1,13 @@
 package controller
 
 import (
+	"fmt"
+	"log"
+
 	"github.com/ChronoPlay/chronoplay-backend-service/constants"
 	"github.com/ChronoPlay/chronoplay-backend-service/mapper"
 	service "github.com/ChronoPlay/chronoplay-backend-service/services"
+	"github.com/ChronoPlay/chronoplay-backend-service/utils"
 	"github.com/gin-gonic/gin"
 )
 
@@ -14,10 +18,17 @@ type transactionController struct {
 type TransactionController interface {
 	Transfercash(*gin.Context)
 	Transfercards(*gin.Context)
+	GiveCards(*gin.Context)
 	Exchange(*gin.Context)
 	GetTransactions(*gin.Context)
+	ExportTransactions(*gin.Context)
 	GetPossibleExchange(*gin.Context)
 	ExecuteExchange(*gin.Context)
+	RespondTransfer(*gin.Context)
+	CancelExchange(*gin.Context)
+	CounterExchange(*gin.Context)
+	Trade(*gin.Context)
+	RespondTrade(*gin.Context)
 }
 
 func NewTransactionController(transactionService service.TransactionService) TransactionController {
@@ -158,3 +169,167 @@ func (ctl *transactionController) ExecuteExchange(c *gin.Context) {
 		Message: "Exchange confirmed successfully",
 	})
 }
+
+func (ctl *transactionController) RespondTransfer(c *gin.Context) {
+	req, err := mapper.DecodeRespondTransferRequest(c)
+	if err != nil {
+		c.JSON(int(err.Code), constants.JsonResp{
+			Message: err.Message,
+		})
+		return
+	}
+	ctx := c.Request.Context()
+	err = ctl.transactionService.RespondTransfer(ctx, req)
+	if err != nil {
+		c.JSON(int(err.Code), constants.JsonResp{
+			Message: err.Message,
+		})
+		return
+	}
+	message := "Transfer rejected successfully"
+	if req.IsAccepted {
+		message = "Transfer accepted successfully"
+	}
+	c.JSON(200, constants.JsonResp{
+		Data:    "",
+		Message: message,
+	})
+}
+
+func (ctl *transactionController) CancelExchange(c *gin.Context) {
+	req, err := mapper.DecodeCancelExchangeRequest(c)
+	if err != nil {
+		c.JSON(int(err.Code), constants.JsonResp{
+			Message: err.Message,
+		})
+		return
+	}
+	ctx := c.Request.Context()
+	err = ctl.transactionService.CancelExchange(ctx, req)
+	if err != nil {
+		c.JSON(int(err.Code), constants.JsonResp{
+			Message: err.Message,
+		})
+		return
+	}
+	c.JSON(200, constants.JsonResp{
+		Data:    "",
+		Message: "Exchange cancelled successfully",
+	})
+}
+
+func (ctl *transactionController) CounterExchange(c *gin.Context) {
+	req, err := mapper.DecodeCounterExchangeRequest(c)
+	if err != nil {
+		c.JSON(int(err.Code), constants.JsonResp{
+			Message: err.Message,
+		})
+		return
+	}
+	ctx := c.Request.Context()
+	resp, err := ctl.transactionService.CounterExchange(ctx, req)
+	if err != nil {
+		c.JSON(int(err.Code), constants.JsonResp{
+			Message: err.Message,
+		})
+		return
+	}
+	c.JSON(200, constants.JsonResp{
+		Data:    resp,
+		Message: "Counter-offer created successfully. Please wait for the other user to accept the request.",
+	})
+}
+
+func (ctl *transactionController) Trade(c *gin.Context) {
+	req, err := mapper.DecodeTradeRequest(c)
+	if err != nil {
+		c.JSON(int(err.Code), constants.JsonResp{
+			Message: err.Message,
+		})
+		return
+	}
+	ctx := c.Request.Context()
+	resp, err := ctl.transactionService.Trade(ctx, req)
+	if err != nil {
+		c.JSON(int(err.Code), constants.JsonResp{
+			Message: err.Message,
+		})
+		return
+	}
+	c.JSON(200, constants.JsonResp{
+		Data:    resp,
+		Message: "Trade created successfully. It settles once every participant has approved it.",
+	})
+}
+
+func (ctl *transactionController) RespondTrade(c *gin.Context) {
+	req, err := mapper.DecodeRespondTradeRequest(c)
+	if err != nil {
+		c.JSON(int(err.Code), constants.JsonResp{
+			Message: err.Message,
+		})
+		return
+	}
+	ctx := c.Request.Context()
+	err = ctl.transactionService.RespondTrade(ctx, req)
+	if err != nil {
+		c.JSON(int(err.Code), constants.JsonResp{
+			Message: err.Message,
+		})
+		return
+	}
+	message := "Trade rejected successfully"
+	if req.IsAccepted {
+		message = "Trade approved successfully"
+	}
+	c.JSON(200, constants.JsonResp{
+		Data:    "",
+		Message: message,
+	})
+}
+
+func (ctl *transactionController) GiveCards(c *gin.Context) {
+	req, err := mapper.DecodeGiveCardsRequest(c)
+	if err != nil {
+		c.JSON(int(err.Code), constants.JsonResp{
+			Message: err.Message,
+		})
+		return
+	}
+	ctx := c.Request.Context()
+	resp, err := ctl.transactionService.GiveCards(ctx, req)
+	if err != nil {
+		c.JSON(int(err.Code), constants.JsonResp{
+			Message: err.Message,
+		})
+		return
+	}
+	c.JSON(200, constants.JsonResp{
+		Data:    resp,
+		Message: "Cards given successfully",
+	})
+}
+
+func (ctl *transactionController) ExportTransactions(c *gin.Context) {
+	req, err := mapper.DecodeExportTransactionsRequest(c)
+	if err != nil {
+		c.JSON(int(err.Code), constants.JsonResp{
+			Message: err.Message,
+		})
+		return
+	}
+	ctx := c.Request.Context()
+	writer := utils.NewTransactionExportWriter(c.Writer, req.Format, fmt.Sprintf("transactions-%d", req.TargetUserId))
+	err = ctl.transactionService.ExportTransactions(ctx, req, writer)
+	if err != nil {
+		if !c.Writer.Written() {
+			c.JSON(int(err.Code), constants.JsonResp{
+				Message: err.Message,
+			})
+			return
+		}
+		// the export is already streaming, all that is left is to cut it short
+		log.Printf("Transaction export of user %d stopped: %s", req.TargetUserId, err.Message)
+		c.Abort()
+	}
+}
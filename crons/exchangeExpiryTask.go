@@ -0,0 +1,23 @@
+package crons
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+func (ctl *cronController) ExchangeExpiryTask() {
+	if !ctl.cronEnabled {
+		log.Println("Cron jobs are disabled. Skipping Exchange Expiry Task.")
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	log.Println("Starting Exchange Expiry Task...")
+	err := ctl.transactionService.ExpireExchanges(ctx, time.Now())
+	if err != nil {
+		log.Printf("Error expiring exchanges: %v", err)
+		return
+	}
+	log.Println("Exchange Expiry Task completed.")
+}
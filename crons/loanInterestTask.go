@@ -0,0 +1,23 @@
+package crons
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+func (ctl *cronController) LoanInterestTask() {
+	if !ctl.cronEnabled {
+		log.Println("Cron jobs are disabled. Skipping Loan Interest Task.")
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	log.Println("Starting Loan Interest Task...")
+	err := ctl.loanService.AccrueDailyInterest(ctx, time.Now())
+	if err != nil {
+		log.Printf("Error accruing loan interest: %v", err)
+		return
+	}
+	log.Println("Loan Interest Task completed.")
+}
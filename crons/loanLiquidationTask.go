@@ -0,0 +1,23 @@
+package crons
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+func (ctl *cronController) LoanLiquidationTask() {
+	if !ctl.cronEnabled {
+		log.Println("Cron jobs are disabled. Skipping Loan Liquidation Task.")
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	log.Println("Starting Loan Liquidation Task...")
+	err := ctl.loanService.LiquidateOverdueLoans(ctx, time.Now())
+	if err != nil {
+		log.Printf("Error liquidating overdue loans: %v", err)
+		return
+	}
+	log.Println("Loan Liquidation Task completed.")
+}
@@ -10,6 +10,10 @@ import (
 type cronController struct {
 	userService         service.UserService
 	notificationService service.NotificationService
+	loanService         service.LoanService
+	transactionService  service.TransactionService
+	ledgerService       service.LedgerService
+	unitOfWork          service.UnitOfWork
 	cronEnabled         bool
 }
 
@@ -17,10 +21,14 @@ type CronController interface {
 	RunAllCrons()
 }
 
-func NewCronController(userService service.UserService, notificationService service.NotificationService, cronEnabled bool) CronController {
+func NewCronController(userService service.UserService, notificationService service.NotificationService, loanService service.LoanService, transactionService service.TransactionService, ledgerService service.LedgerService, unitOfWork service.UnitOfWork, cronEnabled bool) CronController {
 	return &cronController{
 		userService:         userService,
 		notificationService: notificationService,
+		loanService:         loanService,
+		transactionService:  transactionService,
+		ledgerService:       ledgerService,
+		unitOfWork:          unitOfWork,
 		cronEnabled:         cronEnabled,
 	}
 }
@@ -35,6 +43,21 @@ func (ctl *cronController) RunAllCrons() {
 	if err != nil {
 		log.Printf("Error registering survival tax cron: %v", err)
 	}
+	log.Printf("Registering loan interest cron to run every day at 00:30")
+	_, err = c.AddFunc("30 0 * * *", ctl.LoanInterestTask)
+	if err != nil {
+		log.Printf("Error registering loan interest cron: %v", err)
+	}
+	log.Printf("Registering loan liquidation cron to run every hour")
+	_, err = c.AddFunc("0 * * * *", ctl.LoanLiquidationTask)
+	if err != nil {
+		log.Printf("Error registering loan liquidation cron: %v", err)
+	}
+	log.Printf("Registering exchange expiry cron to run every 15 minutes")
+	_, err = c.AddFunc("*/15 * * * *", ctl.ExchangeExpiryTask)
+	if err != nil {
+		log.Printf("Error registering exchange expiry cron: %v", err)
+	}
 	c.Start()
 	log.Println("Cron scheduler started")
 }
@@ -4,10 +4,13 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"net/http"
 	"time"
 
 	"github.com/ChronoPlay/chronoplay-backend-service/dto"
+	"github.com/ChronoPlay/chronoplay-backend-service/helpers"
 	"github.com/ChronoPlay/chronoplay-backend-service/model"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 func (ctl *cronController) SurvivalTaxTask() {
@@ -23,38 +26,34 @@ func (ctl *cronController) SurvivalTaxTask() {
 		log.Printf("Error fetching users: %v", err)
 		return
 	}
-	amountForSurvivalTax := float32(50.0)
+	amountForSurvivalTax := model.NewMoney(50)
 
 	deactivatedEmails := []string{}
 	for _, user := range users {
-		if user.Cash < amountForSurvivalTax {
+		deactivated, err := ctl.chargeSurvivalTax(ctx, user, amountForSurvivalTax)
+		if err != nil {
+			log.Printf("Error charging survival tax to user %d: %v", user.UserId, err)
+			continue
+		}
+		if deactivated {
 			user.Cash = 0
-			user.Cards = []model.CardOccupied{}
 			user.Deactivated = true
-			if err != nil {
-				log.Printf("Error sending deactivation email to user %d: %v", user.UserId, err)
-			}
 		} else {
 			user.Cash -= amountForSurvivalTax
 		}
-		err := ctl.userService.UpdateUser(ctx, user)
-		if err != nil {
-			log.Printf("Error updating user %d: %v", user.UserId, err)
-			continue
-		}
 		if user.Deactivated {
 			deactivatedEmails = append(deactivatedEmails, user.Email)
 		} else {
 			err := ctl.notificationService.SendNotification(ctx, dto.SendNotificationRequest{
 				UserIds: []uint32{user.UserId},
 				Title:   "Survival Tax Deducted",
-				Message: fmt.Sprintf("A survival tax of %.2f has been deducted from your account. Remaining balance: %.2f", amountForSurvivalTax, user.Cash),
+				Message: fmt.Sprintf("A survival tax of %s has been deducted from your account. Remaining balance: %s", amountForSurvivalTax, user.Cash),
 			})
 			if err != nil {
 				log.Printf("Error sending survival tax notification to user %d: %v", user.UserId, err)
 			}
 		}
-		log.Printf("User %d updated successfully. New Cash: %.2f, Deactivated: %v", user.UserId, user.Cash, user.Deactivated)
+		log.Printf("User %d updated successfully. New Cash: %s, Deactivated: %v", user.UserId, user.Cash, user.Deactivated)
 	}
 	if len(deactivatedEmails) > 0 {
 		err := ctl.notificationService.SendDeactivationEmail(ctx, dto.SendDeactivationEmailRequest{
@@ -68,3 +67,52 @@ func (ctl *cronController) SurvivalTaxTask() {
 	}
 	log.Println("Survival Tax Task completed.")
 }
+
+// takes the tax from the user, or when they can't pay it anymore hands what is left to
+// their lenders and wipes them. Reports whether the user was deactivated. The tax and
+// its ledger movement are one transaction, and so are the defaults, the wipe and the
+// ledger close, so lenders are never paid out of a user that stays active.
+func (ctl *cronController) chargeSurvivalTax(ctx context.Context, user model.User, tax model.Money) (bool, *helpers.CustomError) {
+	if user.Cash >= tax {
+		paid := false
+		err := ctl.unitOfWork.Run(ctx, func(sessCtx mongo.SessionContext) *helpers.CustomError {
+			// the work is retried on write conflicts
+			paid = false
+			// the deduction is guarded, so a transfer that raced us still makes the user unable to pay
+			err := ctl.userService.DeductCash(sessCtx, user.UserId, tax)
+			if err != nil {
+				if err.Code == http.StatusBadRequest {
+					return nil
+				}
+				return err
+			}
+			paid = true
+			return ctl.ledgerService.RecordMovements(sessCtx, []model.LedgerMovement{{
+				Kind:   model.LEDGER_KIND_SURVIVAL_TAX,
+				From:   user.UserId,
+				To:     model.LEDGER_ACCOUNT_SYSTEM,
+				Asset:  model.LEDGER_ASSET_CASH,
+				Amount: int64(tax),
+			}})
+		})
+		if err != nil || paid {
+			return false, err
+		}
+	}
+	err := ctl.unitOfWork.Run(ctx, func(sessCtx mongo.SessionContext) *helpers.CustomError {
+		// lenders get their share of whatever is left before the wipe
+		err := ctl.loanService.CloseLoansOfUser(sessCtx, user)
+		if err != nil {
+			return err
+		}
+		err = ctl.userService.WipeUser(sessCtx, user.UserId)
+		if err != nil {
+			return err
+		}
+		return ctl.ledgerService.CloseAccount(sessCtx, user.UserId, model.LEDGER_KIND_DEACTIVATION)
+	})
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -7,6 +7,7 @@ type AddCardRequest struct {
 	CardName        string                `json:"card_name" form:"card_name"`
 	CardDescription string                `json:"card_description" form:"card_description"`
 	TotalCards      uint32                `json:"total_cards" form:"total_cards"`
+	Rarity          string                `json:"rarity" form:"rarity"` // common when empty
 	UserId          uint32                `json:"user_id" form:"user_id"`
 	UserType        string                `json:"user_type" form:"user_type"`
 	Image           *multipart.FileHeader `json:"-" form:"image"` // Optional field for image upload
@@ -25,4 +26,67 @@ type GetCardResponse struct {
 	Available   uint32 `json:"available"`
 	Creator     uint32 `json:"creator"`
 	ImageUrl    string `json:"image_url"`
+	Rarity      string `json:"rarity"`
+	Retired     bool   `json:"retired,omitempty"`
+}
+
+type GetCatalogRequest struct {
+	Search       string `json:"q"`
+	Rarity       string `json:"rarity"`
+	Creator      uint32 `json:"creator"`
+	MinAvailable uint32 `json:"min_available"`
+	WithRetired  bool   `json:"include_retired"`
+	Sort         string `json:"sort"`
+	Page         int64  `json:"page"` // starts at 1
+	Limit        int64  `json:"limit"`
+}
+
+type GetCatalogResponse struct {
+	Cards []GetCardResponse `json:"cards"`
+	Page  int64             `json:"page"`
+	Limit int64             `json:"limit"`
+	Total int64             `json:"total"` // cards matching the filters over all pages
+}
+
+type CardRarity struct {
+	Rarity    string `json:"rarity"`
+	MaxSupply uint32 `json:"max_supply"`
+}
+
+// only the fields that are set are changed
+type UpdateCardRequest struct {
+	CardNumber      string                `json:"card_number" form:"card_number"`
+	CardName        *string               `json:"card_name" form:"card_name"`
+	CardDescription *string               `json:"card_description" form:"card_description"`
+	TotalCards      *uint32               `json:"total_cards" form:"total_cards"`
+	Image           *multipart.FileHeader `json:"-" form:"image"`
+	UserId          uint32                `json:"user_id" form:"user_id"`
+}
+
+type RetireCardRequest struct {
+	CardNumber string `json:"card_number"`
+	UserId     uint32 `json:"user_id"`
+}
+
+type GetTopHoldersRequest struct {
+	CardNumber string `json:"card_number"`
+	Limit      int64  `json:"limit"`
+}
+
+type GetTopHoldersResponse struct {
+	CardNumber string       `json:"card_number"`
+	OwnerCount int          `json:"owner_count"` // every user holding the card, not just this page
+	Holders    []CardHolder `json:"holders"`
+}
+
+// Quantity includes copies locked as collateral or held in pending exchanges
+type CardHolder struct {
+	UserId   uint32 `json:"user_id"`
+	UserName string `json:"user_name"`
+	Quantity uint32 `json:"quantity"`
+}
+
+type GetCardAuditsRequest struct {
+	CardNumber string `json:"card_number"`
+	UserId     uint32 `json:"user_id"`
 }
@@ -0,0 +1,25 @@
+package dto
+
+import "github.com/ChronoPlay/chronoplay-backend-service/model"
+
+type ReconcileBalanceRequest struct {
+	TargetUserId uint32 `json:"target_user_id"`
+	Apply        bool   `json:"apply"`
+	UserId       uint32 `json:"user_id"`
+}
+
+// Stored and Ledger are minor units for cash and quantities for cards
+type BalanceDiscrepancy struct {
+	Asset      string `json:"asset"`
+	Stored     int64  `json:"stored"`
+	Ledger     int64  `json:"ledger"`
+	Difference int64  `json:"difference"`
+}
+
+type ReconcileBalanceResponse struct {
+	UserId        uint32               `json:"user_id"`
+	Cash          model.Money          `json:"cash"`
+	Cards         []Card               `json:"cards"`
+	Discrepancies []BalanceDiscrepancy `json:"discrepancies"`
+	Applied       bool                 `json:"applied"`
+}
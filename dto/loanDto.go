@@ -0,0 +1,57 @@
+package dto
+
+import (
+	"time"
+
+	"github.com/ChronoPlay/chronoplay-backend-service/model"
+)
+
+type RequestLoanRequest struct {
+	LenderId     uint32         `json:"lender_id"`
+	Amount       model.Money    `json:"amount"`
+	Rate         float32        `json:"rate"`
+	DurationDays uint32         `json:"duration_days"`
+	Collateral   []TransferCard `json:"collateral"`
+	UserId       uint32         `json:"user_id"`
+}
+
+type RequestLoanResponse struct {
+	LoanId uint32 `json:"loan_id"`
+}
+
+type RespondLoanRequest struct {
+	LoanId     uint32 `json:"loan_id"`
+	IsAccepted bool   `json:"is_accepted"`
+	UserId     uint32 `json:"user_id"`
+}
+
+type RepayLoanRequest struct {
+	LoanId uint32      `json:"loan_id"`
+	Amount model.Money `json:"amount"`
+	UserId uint32      `json:"user_id"`
+}
+
+type GetLoansRequest struct {
+	UserId uint32 `json:"user_id"`
+	Role   string `json:"role"`
+}
+
+type GetLoansResponse struct {
+	Loans []LoanResponse `json:"loans"`
+}
+
+type LoanResponse struct {
+	LoanId              uint32      `json:"loan_id"`
+	Amount              model.Money `json:"amount"`
+	LoanedBy            uint32      `json:"loaned_by"`
+	LoanedTo            uint32      `json:"loaned_to"`
+	Rate                float32     `json:"rate"`
+	InterestAccumulated model.Money `json:"interest_accumulated"`
+	PaidAmount          model.Money `json:"paid_amount"`
+	Outstanding         model.Money `json:"outstanding"`
+	Status              string      `json:"status"`
+	IsPaid              bool        `json:"is_paid"`
+	CreatedAt           time.Time   `json:"created_at"`
+	DueDate             time.Time   `json:"due_date,omitempty"`
+	Collateral          []Card      `json:"collateral,omitempty"`
+}
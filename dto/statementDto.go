@@ -0,0 +1,52 @@
+package dto
+
+import (
+	"time"
+
+	"github.com/ChronoPlay/chronoplay-backend-service/model"
+)
+
+type StatementRequest struct {
+	UserId       uint32 `json:"user_id"`
+	TargetUserId uint32 `json:"target_user_id"` // only admins may ask for someone else's statement
+	Month        string `json:"month"`          // YYYY-MM, UTC
+}
+
+// Cash includes what is held for pending exchanges, cards include locked and held
+// copies, since the user still owns them
+type Statement struct {
+	UserId        uint32                  `json:"user_id"`
+	UserName      string                  `json:"user_name"`
+	Month         string                  `json:"month"`
+	From          time.Time               `json:"from"`
+	To            time.Time               `json:"to"` // exclusive, now for the running month
+	OpeningCash   model.Money             `json:"opening_cash"`
+	ClosingCash   model.Money             `json:"closing_cash"`
+	CashIn        model.Money             `json:"cash_in"`
+	CashOut       model.Money             `json:"cash_out"`
+	SurvivalTax   model.Money             `json:"survival_tax"` // part of CashOut
+	OpeningCards  []Card                  `json:"opening_cards"`
+	ClosingCards  []Card                  `json:"closing_cards"`
+	CashMovements []StatementCashMovement `json:"cash_movements"`
+	CardMovements []StatementCardMovement `json:"card_movements"`
+}
+
+type StatementCashMovement struct {
+	Time            time.Time   `json:"time"`
+	TransactionGuid uint32      `json:"transaction_guid,omitempty"`
+	Kind            string      `json:"kind"`
+	Type            string      `json:"type,omitempty"`
+	Counterparty    uint32      `json:"counterparty"`
+	Amount          model.Money `json:"amount"`  // negative when cash left the account
+	Balance         model.Money `json:"balance"` // after this movement
+}
+
+type StatementCardMovement struct {
+	Time            time.Time `json:"time"`
+	TransactionGuid uint32    `json:"transaction_guid,omitempty"`
+	Kind            string    `json:"kind"`
+	Type            string    `json:"type,omitempty"`
+	Counterparty    uint32    `json:"counterparty"`
+	CardNumber      string    `json:"card_number"`
+	Quantity        int64     `json:"quantity"` // negative when cards left the account
+}
@@ -7,14 +7,21 @@ import (
 )
 
 type Transaction struct {
-	TransactionGuid uint32    `json:"transaction_guid"`
-	CardsRecieved   []Card    `json:"cards_recieved"`
-	CardsSent       []Card    `json:"cards_sent"`
-	CashSent        float32   `json:"cash_sent"`
-	CashRecieved    float32   `json:"cash_recieved"`
-	TransactionWith uint32    `json:"transaction_with"`
-	Time            time.Time `json:"time"`
-	Status          string    `json:"status"`
+	TransactionGuid uint32      `json:"transaction_guid"`
+	CardsRecieved   []Card      `json:"cards_recieved"`
+	CardsSent       []Card      `json:"cards_sent"`
+	CashSent        model.Money `json:"cash_sent"`
+	CashRecieved    model.Money `json:"cash_recieved"`
+	TransactionWith uint32      `json:"transaction_with"`
+	Time            time.Time   `json:"time"`
+	Status          string      `json:"status"`
+	LoanId          uint32      `json:"loan_id,omitempty"`
+	ExpiresAt       time.Time   `json:"expires_at,omitempty"`
+	ParentGuid      uint32      `json:"parent_guid,omitempty"` // proposal this counter-offer answered
+	ChainGuid       uint32      `json:"chain_guid,omitempty"`  // first proposal of the negotiation
+	Type            string      `json:"type,omitempty"`
+	Participants    []uint32    `json:"participants,omitempty"` // every user of a trade, including legs between others
+	ApprovedBy      []uint32    `json:"approved_by,omitempty"`
 }
 
 type Card struct {
@@ -23,21 +30,25 @@ type Card struct {
 }
 
 type TransferCashRequest struct {
-	Amount   float32 `json:"amount"`
-	GivenBy  uint32  `json:"given_by"`
-	GivenTo  uint32  `json:"given_to"`
-	Status   string  `json:"status"`
-	UserId   uint32  `json:"user_id"`
-	UserType string  `json:"user_type"`
+	Amount         model.Money `json:"amount"`
+	GivenBy        uint32      `json:"given_by"`
+	GivenTo        uint32      `json:"given_to"`
+	Status         string      `json:"status"`
+	UserId         uint32      `json:"user_id"`
+	UserType       string      `json:"user_type"`
+	LoanId         uint32      `json:"-"` // set internally when cash moves because of a loan
+	IdempotencyKey string      `json:"-"` // set by the idempotency middleware
 }
 
 type TransferCardRequest struct {
-	Cards    []TransferCard `json:"cards"`
-	GivenBy  uint32         `json:"given_by"`
-	GivenTo  uint32         `json:"given_to"`
-	Status   string         `json:"status"`
-	UserId   uint32         `json:"user_id"`
-	UserType string         `json:"user_type"`
+	Cards          []TransferCard `json:"cards"`
+	GivenBy        uint32         `json:"given_by"`
+	GivenTo        uint32         `json:"given_to"`
+	Status         string         `json:"status"`
+	UserId         uint32         `json:"user_id"`
+	UserType       string         `json:"user_type"`
+	LoanId         uint32         `json:"-"` // set internally when cards move because of a loan
+	IdempotencyKey string         `json:"-"` // set by the idempotency middleware
 }
 
 type TransferCard struct {
@@ -46,29 +57,40 @@ type TransferCard struct {
 }
 
 type ExchangeRequest struct {
-	GivenBy       uint32  `json:"given_by"`
-	GivenTo       uint32  `json:"given_to"`
-	CashSent      float32 `json:"cash_sent"`
-	CashRecieved  float32 `json:"cash_recieved"`
-	CardsSent     []Card  `json:"cards_sent"`
-	CardsRecieved []Card  `json:"cards_recieved"`
-	UserId        uint32  `json:"user_id"`
-	UserType      string  `json:"user_type"`
+	GivenBy        uint32      `json:"given_by"`
+	GivenTo        uint32      `json:"given_to"`
+	CashSent       model.Money `json:"cash_sent"`
+	CashRecieved   model.Money `json:"cash_recieved"`
+	CardsSent      []Card      `json:"cards_sent"`
+	CardsRecieved  []Card      `json:"cards_recieved"`
+	UserId         uint32      `json:"user_id"`
+	UserType       string      `json:"user_type"`
+	IdempotencyKey string      `json:"-"` // set by the idempotency middleware
 }
 
 type GetTransactionsRequest struct {
-	UserId           uint32   `json:"user_id"`
-	TransactionGuids []uint32 `json:"transaction_guids"`
+	UserId           uint32    `json:"user_id"`
+	TransactionGuids []uint32  `json:"transaction_guids"`
+	ChainGuid        uint32    `json:"chain_guid"`
+	Cursor           uint32    `json:"cursor"` // next_cursor of the previous page
+	Limit            int       `json:"limit"`
+	Status           string    `json:"status"`
+	Counterparty     uint32    `json:"counterparty"`
+	CardNumber       string    `json:"card_number"`
+	Direction        string    `json:"direction"`
+	From             time.Time `json:"from"`
+	To               time.Time `json:"to"`
 }
 
 type GetTransactionsResponse struct {
 	Transactions []Transaction `json:"transactions"`
+	NextCursor   uint32        `json:"next_cursor,omitempty"` // not set on the last page
 }
 
 type IsCashTransactionPossibleRequest struct {
 	GivenBy uint32
 	User    model.User
-	Amount  float32
+	Amount  model.Money
 }
 
 type IsCardTransactionPossibleRequest struct {
@@ -82,8 +104,8 @@ type IsCardTransactionPossibleRequest struct {
 type IsExhangePossibleRequest struct {
 	GivenByUser   model.User
 	GivenToUser   model.User
-	CashSent      float32
-	CashRecieved  float32
+	CashSent      model.Money
+	CashRecieved  model.Money
 	CardsSent     []Card
 	CardsRecieved []Card
 }
@@ -94,9 +116,9 @@ type GetPossibleExchangeRequest struct {
 }
 
 type GetPossibleExchangeResponse struct {
-	YourCash    float32        `json:"yourCash"`
+	YourCash    model.Money    `json:"yourCash"`
 	YourCards   []CardResponse `json:"yourCards"`
-	TraderCash  float32        `json:"traderCash"`
+	TraderCash  model.Money    `json:"traderCash"`
 	TraderCards []CardResponse `json:"traderCards"`
 }
 
@@ -115,5 +137,83 @@ type IsvalidTransactionConfirmerRequest struct {
 
 type DeduceSurvivalTaxAmountRequest struct {
 	UserId uint32
-	Amount float32
+	Amount model.Money
+}
+
+type RespondTransferRequest struct {
+	TransactionGuid uint32 `json:"transaction_guid"`
+	UserId          uint32 `json:"user_id"`
+	IsAccepted      bool   `json:"is_accepted"`
+}
+
+type CancelExchangeRequest struct {
+	TransactionGuid uint32 `json:"transaction_guid"`
+	UserId          uint32 `json:"user_id"`
+}
+
+type CounterExchangeRequest struct {
+	TransactionGuid uint32      `json:"transaction_guid"`
+	CashSent        model.Money `json:"cash_sent"`
+	CashRecieved    model.Money `json:"cash_recieved"`
+	CardsSent       []Card      `json:"cards_sent"`
+	CardsRecieved   []Card      `json:"cards_recieved"`
+	UserId          uint32      `json:"user_id"`
+}
+
+type CounterExchangeResponse struct {
+	TransactionGuid uint32 `json:"transaction_guid"`
+	ChainGuid       uint32 `json:"chain_guid"`
+}
+
+type TradeRequest struct {
+	Legs           []TradeLeg `json:"legs"`
+	UserId         uint32     `json:"user_id"`
+	IdempotencyKey string     `json:"-"` // set by the idempotency middleware
+}
+
+// one participant giving cash and/or cards to another within a trade
+type TradeLeg struct {
+	GivenBy uint32      `json:"given_by"`
+	GivenTo uint32      `json:"given_to"`
+	Cash    model.Money `json:"cash"`
+	Cards   []Card      `json:"cards"`
+}
+
+type TradeResponse struct {
+	TransactionGuid uint32 `json:"transaction_guid"`
+}
+
+type RespondTradeRequest struct {
+	TransactionGuid uint32 `json:"transaction_guid"`
+	UserId          uint32 `json:"user_id"`
+	IsAccepted      bool   `json:"is_accepted"`
+}
+
+type GiveCardsRequest struct {
+	Grants         []CardGrant `json:"grants"`
+	UserId         uint32      `json:"user_id"`
+	IdempotencyKey string      `json:"-"` // set by the idempotency middleware
+}
+
+// cards minted to one user
+type CardGrant struct {
+	GivenTo uint32         `json:"given_to"`
+	Cards   []TransferCard `json:"cards"`
+}
+
+type GiveCardsResponse struct {
+	TransactionGuids []uint32 `json:"transaction_guids"` // one per grant, in request order
+}
+
+type ExportTransactionsRequest struct {
+	UserId       uint32                 `json:"user_id"`
+	TargetUserId uint32                 `json:"target_user_id"` // whose history is exported, only admins may pick someone else
+	Format       string                 `json:"format"`
+	Filter       GetTransactionsRequest `json:"filter"` // cursor and limit are driven by the export
+}
+
+// one record of a history export
+type TransactionExportRow struct {
+	Transaction
+	CounterpartyUsername string `json:"counterparty_username"`
 }
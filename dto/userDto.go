@@ -1,5 +1,7 @@
 package dto
 
+import "github.com/ChronoPlay/chronoplay-backend-service/model"
+
 type EmailVerificationRequest struct {
 	Email    string
 	UserName string
@@ -29,7 +31,7 @@ type GetUserResponse struct {
 	Name        string         `json:"name"`
 	Email       string         `json:"email"`
 	UserName    string         `json:"user_name"`
-	Cash        float32        `json:"cash"`
+	Cash        model.Money    `json:"cash"`
 	FriendIds   []uint32       `json:"friend_ids"`
 	PhoneNumber string         `bson:"phone_number" json:"phone_number"`
 	Cards       []CardResponse `bson:"cards" json:"cards"`
@@ -43,13 +45,14 @@ type CardResponse struct {
 	Description string ` json:"description"`
 	Rarity      string ` json:"rarity"`
 	Name        string ` json:"name"`
+	Retired     bool   ` json:"retired,omitempty"`
 }
 
 type GetUserByIdResponse struct {
 	Name     string         `json:"name"`
 	Email    string         `json:"email"`
 	UserName string         `json:"user_name"`
-	Cash     float32        `json:"cash"`
+	Cash     model.Money    `json:"cash"`
 	UserType string         `json:"user_type"`
 	Cards    []CardResponse `json:"cards"`
 }
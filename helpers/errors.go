@@ -3,6 +3,7 @@ package helpers
 type CustomError struct {
 	Message string
 	Code    int
+	cause   error
 }
 
 func (e *CustomError) Error() string {
@@ -13,10 +14,27 @@ func (e *CustomError) Error() string {
 	}
 }
 
+// Unwrap exposes the driver error behind a system error, mongo needs its labels to
+// decide whether a transaction can be retried
+func (e *CustomError) Unwrap() error {
+	if e == nil {
+		return nil
+	}
+	return e.cause
+}
+
 func System(msg string) *CustomError {
 	return &CustomError{Message: msg, Code: 500}
 }
 
+// SystemError keeps err as the cause, the message is "msg: err"
+func SystemError(msg string, err error) *CustomError {
+	if msg == "" {
+		return &CustomError{Message: err.Error(), Code: 500, cause: err}
+	}
+	return &CustomError{Message: msg + ": " + err.Error(), Code: 500, cause: err}
+}
+
 func NotFound(msg string) *CustomError {
 	return &CustomError{Message: msg, Code: 404}
 }
@@ -29,6 +47,10 @@ func Unauthorized(msg string) *CustomError {
 	return &CustomError{Message: msg, Code: 401}
 }
 
+func Conflict(msg string) *CustomError {
+	return &CustomError{Message: msg, Code: 409}
+}
+
 func NoType(err error) *CustomError {
 	if err == nil {
 		return nil
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
 	"time"
@@ -12,9 +13,11 @@ import (
 	controllers "github.com/ChronoPlay/chronoplay-backend-service/controllers"
 	"github.com/ChronoPlay/chronoplay-backend-service/crons"
 	"github.com/ChronoPlay/chronoplay-backend-service/database"
+	middleware "github.com/ChronoPlay/chronoplay-backend-service/middlewares"
 	models "github.com/ChronoPlay/chronoplay-backend-service/model"
 	"github.com/ChronoPlay/chronoplay-backend-service/routes"
 	services "github.com/ChronoPlay/chronoplay-backend-service/services"
+	"github.com/ChronoPlay/chronoplay-backend-service/utils"
 )
 
 func main() {
@@ -34,29 +37,86 @@ func main() {
 	}
 	usersDb := database.MongoClient.Database(dbName).Collection("users")
 	cardDb := database.MongoClient.Database(dbName).Collection("cards")
+	cardAuditDb := database.MongoClient.Database(dbName).Collection("card_audits")
 	loanDb := database.MongoClient.Database(dbName).Collection("loans")
 	cardTransactionDb := database.MongoClient.Database(dbName).Collection("card_transactions")
 	cashTransactionDb := database.MongoClient.Database(dbName).Collection("cash_transactions")
 	notificationDb := database.MongoClient.Database(dbName).Collection("notifications")
+	ledgerDb := database.MongoClient.Database(dbName).Collection("ledger_entries")
+	idempotencyDb := database.MongoClient.Database(dbName).Collection("idempotency_keys")
 
 	cardRepo := models.NewCardRepository(cardDb)
+	cardAuditRepo := models.NewCardAuditRepository(cardAuditDb)
 	userRepo := models.NewUserRepository(usersDb)
 	loanRepo := models.NewLoanRepository(loanDb)
 	cardTransactionRepo := models.NewCardTransactionRepository(cardTransactionDb)
 	cashTransactionRepo := models.NewCashTransactionRepository(cashTransactionDb)
 	notificationRepo := models.NewNotificationRepository(notificationDb)
+	ledgerRepo := models.NewLedgerRepository(ledgerDb)
+	idempotencyRepo := models.NewIdempotencyRepository(idempotencyDb)
+	if err := idempotencyRepo.EnsureIndexes(context.Background()); err != nil {
+		log.Printf("Warning: could not create idempotency indexes: %v", err)
+	}
+	if err := userRepo.EnsureIndexes(context.Background()); err != nil {
+		log.Printf("Warning: could not create user indexes: %v", err)
+	}
+	if err := cardRepo.EnsureIndexes(context.Background()); err != nil {
+		log.Printf("Warning: could not create card indexes: %v", err)
+	}
+	if err := cardTransactionRepo.EnsureIndexes(context.Background()); err != nil {
+		log.Printf("Warning: could not create card transaction indexes: %v", err)
+	}
+	if err := cashTransactionRepo.EnsureIndexes(context.Background()); err != nil {
+		log.Printf("Warning: could not create cash transaction indexes: %v", err)
+	}
+	if err := ledgerRepo.EnsureIndexes(context.Background()); err != nil {
+		log.Printf("Warning: could not create ledger indexes: %v", err)
+	}
 
+	unitOfWork := services.NewUnitOfWork(database.MongoClient)
 	notificationService := services.NewNotificationService(notificationRepo)
-	userService := services.NewUserService(userRepo, cardRepo)
-	cardService := services.NewCardService(cardRepo, userRepo)
-	loanService := services.NewLoanService(loanRepo)
-	transactionService := services.NewTransactionService(cardTransactionRepo, cashTransactionRepo, userRepo, cardRepo, notificationService)
+	userService := services.NewUserService(userRepo, cardRepo, unitOfWork)
+	imageStorage := utils.NewCloudinaryImageStorage()
+	imageDir := ""
+	switch value := os.Getenv("IMAGE_STORAGE"); value {
+	case "", models.IMAGE_STORAGE_CLOUDINARY:
+	case models.IMAGE_STORAGE_LOCAL:
+		imageDir = os.Getenv("IMAGE_STORAGE_DIR")
+		if imageDir == "" {
+			imageDir = "uploads"
+		}
+		imageStorage = utils.NewLocalImageStorage(imageDir, models.LOCAL_IMAGE_ROUTE, os.Getenv("IMAGE_BASE_URL"))
+	default:
+		log.Fatalf("Invalid IMAGE_STORAGE %q, use cloudinary or local", value)
+	}
+	cardService := services.NewCardService(cardRepo, userRepo, cardAuditRepo, imageStorage, unitOfWork)
+	ledgerService := services.NewLedgerService(ledgerRepo, userRepo, unitOfWork)
+	exchangeTTL := 72 * time.Hour
+	if value := os.Getenv("EXCHANGE_PROPOSAL_TTL"); value != "" {
+		exchangeTTL, err = time.ParseDuration(value)
+		if err != nil {
+			log.Fatalf("Invalid EXCHANGE_PROPOSAL_TTL %q: %v", value, err)
+		}
+	}
+	idempotencyTTL := 24 * time.Hour
+	if value := os.Getenv("IDEMPOTENCY_KEY_TTL"); value != "" {
+		idempotencyTTL, err = time.ParseDuration(value)
+		if err != nil {
+			log.Fatalf("Invalid IDEMPOTENCY_KEY_TTL %q: %v", value, err)
+		}
+	}
+	idempotencyService := services.NewIdempotencyService(idempotencyRepo, idempotencyTTL)
+	transactionService := services.NewTransactionService(cardTransactionRepo, cashTransactionRepo, userRepo, cardRepo, notificationService, ledgerService, idempotencyService, unitOfWork, exchangeTTL)
+	statementService := services.NewStatementService(ledgerRepo, userRepo, cardTransactionRepo, cashTransactionRepo)
+	loanService := services.NewLoanService(loanRepo, userRepo, transactionService, notificationService, unitOfWork)
 
 	notificationController := controllers.NewNotificationController(notificationService)
 	userController := controllers.NewUserController(userService)
 	cardController := controllers.NewCardController(cardService)
 	loanController := controllers.NewLoanController(loanService)
 	transactionController := controllers.NewTransactionController(transactionService)
+	ledgerController := controllers.NewLedgerController(ledgerService)
+	statementController := controllers.NewStatementController(statementService)
 
 	// Setup Gin and routes
 	router := gin.Default()
@@ -69,20 +129,25 @@ func main() {
 			"https://chronoplay.onrender.com",
 		},
 		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization"},
-		ExposeHeaders:    []string{"Content-Length", "Content-Type"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization", "Idempotency-Key"},
+		ExposeHeaders:    []string{"Content-Length", "Content-Type", "Idempotent-Replayed"},
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
 	}
 
 	router.Use(cors.New(config))
 
+	// images of the local storage are public like the cloudinary ones
+	if imageDir != "" {
+		router.Static(models.LOCAL_IMAGE_ROUTE, imageDir)
+	}
+
 	// Handle routes
-	routes.SetupRoutes(router, userController, cardController, loanController, transactionController, notificationController)
+	routes.SetupRoutes(router, userController, cardController, loanController, transactionController, notificationController, ledgerController, statementController, middleware.Idempotency(idempotencyService))
 
 	// start all cron jobs
 	cronsEnabled := os.Getenv("CRON_ENABLED") == "true"
-	cronController := crons.NewCronController(userService, notificationService, cronsEnabled)
+	cronController := crons.NewCronController(userService, notificationService, loanService, transactionService, ledgerService, unitOfWork, cronsEnabled)
 	cronController.RunAllCrons()
 
 	// Start server
@@ -2,6 +2,8 @@ package mapper
 
 import (
 	"log"
+	"strconv"
+	"strings"
 
 	"github.com/ChronoPlay/chronoplay-backend-service/dto"
 	"github.com/ChronoPlay/chronoplay-backend-service/helpers"
@@ -15,6 +17,7 @@ func DecodeAddCardRequest(c *gin.Context) (dto.AddCardRequest, *helpers.CustomEr
 		return dto.AddCardRequest{}, helpers.BadRequest("Invalid request body" + err.Error())
 	}
 	log.Println("Decoded AddCardRequest:", req)
+	req.Rarity = strings.ToLower(strings.TrimSpace(req.Rarity))
 	userId, _ := c.Get("UserID")
 	req.UserId = userId.(uint32)
 	file, err := c.FormFile("image")
@@ -40,5 +43,124 @@ func EncodeGetCardResponse(req *model.Card) (res dto.GetCardResponse) {
 	res.Total = req.Total
 	res.Occupied = req.Occupied
 	res.ImageUrl = req.ImageUrl
+	res.Creator = req.Creator
+	res.Rarity = req.Rarity
+	res.Retired = req.Retired
+	if req.Total > req.Occupied {
+		res.Available = req.Total - req.Occupied
+	}
+	return res
+}
+
+func DecodeGetCatalogRequest(c *gin.Context) (dto.GetCatalogRequest, *helpers.CustomError) {
+	var req dto.GetCatalogRequest
+	req.Search = strings.TrimSpace(c.Query("q"))
+	req.Rarity = strings.ToLower(strings.TrimSpace(c.Query("rarity")))
+	req.Sort = c.Query("sort")
+	req.WithRetired = c.Query("include_retired") == "true"
+	for name, target := range map[string]*uint32{"creator": &req.Creator, "min_available": &req.MinAvailable} {
+		value, exists := c.GetQuery(name)
+		if !exists {
+			continue
+		}
+		valueUint, perr := strconv.ParseUint(value, 10, 32)
+		if perr != nil {
+			return req, helpers.BadRequest("Invalid " + name + ": " + perr.Error())
+		}
+		*target = uint32(valueUint)
+	}
+	for name, target := range map[string]*int64{"page": &req.Page, "limit": &req.Limit} {
+		value, exists := c.GetQuery(name)
+		if !exists {
+			continue
+		}
+		valueInt, perr := strconv.ParseInt(value, 10, 64)
+		if perr != nil {
+			return req, helpers.BadRequest("Invalid " + name + ": " + perr.Error())
+		}
+		*target = valueInt
+	}
+	return req, nil
+}
+
+func EncodeGetCatalogResponse(cards []model.Card, req dto.GetCatalogRequest, total int64) (res dto.GetCatalogResponse) {
+	res.Cards = make([]dto.GetCardResponse, len(cards))
+	for i := range cards {
+		res.Cards[i] = EncodeGetCardResponse(&cards[i])
+	}
+	res.Page = req.Page
+	res.Limit = req.Limit
+	res.Total = total
+	return res
+}
+
+func EncodeCardRarities() []dto.CardRarity {
+	rarities := make([]dto.CardRarity, len(model.CardRarities))
+	for i, rarity := range model.CardRarities {
+		rarities[i] = dto.CardRarity{
+			Rarity:    rarity,
+			MaxSupply: model.CardRarityMaxSupply[rarity],
+		}
+	}
+	return rarities
+}
+
+func DecodeUpdateCardRequest(c *gin.Context) (dto.UpdateCardRequest, *helpers.CustomError) {
+	var req dto.UpdateCardRequest
+	if err := c.ShouldBind(&req); err != nil {
+		return dto.UpdateCardRequest{}, helpers.BadRequest("Invalid request body" + err.Error())
+	}
+	userId, _ := c.Get("UserID")
+	req.UserId = userId.(uint32)
+	// the image is optional when editing
+	file, err := c.FormFile("image")
+	if err == nil {
+		req.Image = file
+	}
+	return req, nil
+}
+
+func DecodeRetireCardRequest(c *gin.Context) (dto.RetireCardRequest, *helpers.CustomError) {
+	var req dto.RetireCardRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		return dto.RetireCardRequest{}, helpers.BadRequest("Invalid request body" + err.Error())
+	}
+	userId, _ := c.Get("UserID")
+	req.UserId = userId.(uint32)
+	return req, nil
+}
+
+func DecodeGetCardAuditsRequest(c *gin.Context) (dto.GetCardAuditsRequest, *helpers.CustomError) {
+	var req dto.GetCardAuditsRequest
+	userId, _ := c.Get("UserID")
+	req.UserId = userId.(uint32)
+	req.CardNumber = c.Query("card_number")
+	return req, nil
+}
+
+func DecodeGetTopHoldersRequest(c *gin.Context) (dto.GetTopHoldersRequest, *helpers.CustomError) {
+	var req dto.GetTopHoldersRequest
+	req.CardNumber = c.Query("card_number")
+	if value, exists := c.GetQuery("limit"); exists {
+		limit, perr := strconv.ParseInt(value, 10, 64)
+		if perr != nil {
+			return req, helpers.BadRequest("Invalid limit: " + perr.Error())
+		}
+		req.Limit = limit
+	}
+	return req, nil
+}
+
+func EncodeGetTopHoldersResponse(card *model.Card, holders []model.CardHolder) (res dto.GetTopHoldersResponse) {
+	res.CardNumber = card.Number
+	res.OwnerCount = len(card.Owners)
+	res.Holders = make([]dto.CardHolder, len(holders))
+	for i, holder := range holders {
+		res.Holders[i] = dto.CardHolder{
+			UserId:   holder.UserId,
+			UserName: holder.UserName,
+			Quantity: holder.Quantity,
+		}
+	}
 	return res
 }
@@ -0,0 +1,17 @@
+package mapper
+
+import (
+	"github.com/ChronoPlay/chronoplay-backend-service/dto"
+	"github.com/ChronoPlay/chronoplay-backend-service/helpers"
+	"github.com/gin-gonic/gin"
+)
+
+func DecodeReconcileBalanceRequest(c *gin.Context) (dto.ReconcileBalanceRequest, *helpers.CustomError) {
+	var req dto.ReconcileBalanceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		return req, helpers.BadRequest("Invalid request body" + err.Error())
+	}
+	userId, _ := c.Get("UserID")
+	req.UserId = userId.(uint32)
+	return req, nil
+}
@@ -0,0 +1,74 @@
+package mapper
+
+import (
+	"github.com/ChronoPlay/chronoplay-backend-service/dto"
+	"github.com/ChronoPlay/chronoplay-backend-service/helpers"
+	"github.com/ChronoPlay/chronoplay-backend-service/model"
+	"github.com/gin-gonic/gin"
+)
+
+func DecodeRequestLoanRequest(c *gin.Context) (dto.RequestLoanRequest, *helpers.CustomError) {
+	var req dto.RequestLoanRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		return req, helpers.BadRequest("Invalid request body" + err.Error())
+	}
+	userId, _ := c.Get("UserID")
+	req.UserId = userId.(uint32)
+	return req, nil
+}
+
+func DecodeRespondLoanRequest(c *gin.Context) (dto.RespondLoanRequest, *helpers.CustomError) {
+	var req dto.RespondLoanRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		return req, helpers.BadRequest("Invalid request body" + err.Error())
+	}
+	userId, _ := c.Get("UserID")
+	req.UserId = userId.(uint32)
+	return req, nil
+}
+
+func DecodeRepayLoanRequest(c *gin.Context) (dto.RepayLoanRequest, *helpers.CustomError) {
+	var req dto.RepayLoanRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		return req, helpers.BadRequest("Invalid request body" + err.Error())
+	}
+	userId, _ := c.Get("UserID")
+	req.UserId = userId.(uint32)
+	return req, nil
+}
+
+func DecodeGetLoansRequest(c *gin.Context) (req dto.GetLoansRequest, err *helpers.CustomError) {
+	userId, _ := c.Get("UserID")
+	req.UserId = userId.(uint32)
+	req.Role = c.Query("role")
+	return req, nil
+}
+
+func MapLoansToResponse(loans []model.Loan) []dto.LoanResponse {
+	loanResponses := []dto.LoanResponse{}
+	for _, loan := range loans {
+		collateral := []dto.Card{}
+		for _, card := range loan.Collateral {
+			collateral = append(collateral, dto.Card{
+				CardNumber: card.CardNumber,
+				Amount:     card.Occupied,
+			})
+		}
+		loanResponses = append(loanResponses, dto.LoanResponse{
+			LoanId:              loan.LoanId,
+			Amount:              loan.Amount,
+			LoanedBy:            loan.LoanedBy,
+			LoanedTo:            loan.LoanedTo,
+			Rate:                loan.Rate,
+			InterestAccumulated: loan.InterestAccumulated,
+			PaidAmount:          loan.PaidAmount,
+			Outstanding:         loan.Outstanding(),
+			Status:              loan.Status,
+			IsPaid:              loan.IsPaid,
+			CreatedAt:           loan.CreatedAt.Time(),
+			DueDate:             loan.DueDate.Time(),
+			Collateral:          collateral,
+		})
+	}
+	return loanResponses
+}
@@ -0,0 +1,39 @@
+package mapper
+
+import (
+	"strconv"
+
+	"github.com/ChronoPlay/chronoplay-backend-service/dto"
+	"github.com/ChronoPlay/chronoplay-backend-service/helpers"
+	"github.com/gin-gonic/gin"
+)
+
+func DecodeGetStatementRequest(c *gin.Context) (dto.StatementRequest, *helpers.CustomError) {
+	var req dto.StatementRequest
+	userId, _ := c.Get("UserID")
+	req.UserId = userId.(uint32)
+	req.TargetUserId = req.UserId
+	req.Month = c.Query("month")
+	targetUserId, exists := c.GetQuery("user_id")
+	if exists {
+		targetUserIdUint, perr := strconv.ParseUint(targetUserId, 10, 32)
+		if perr != nil {
+			return req, helpers.BadRequest("Invalid user_id: " + perr.Error())
+		}
+		req.TargetUserId = uint32(targetUserIdUint)
+	}
+	return req, nil
+}
+
+func DecodeEmailStatementRequest(c *gin.Context) (dto.StatementRequest, *helpers.CustomError) {
+	var req dto.StatementRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		return req, helpers.BadRequest("Invalid request body" + err.Error())
+	}
+	userId, _ := c.Get("UserID")
+	req.UserId = userId.(uint32)
+	if req.TargetUserId == 0 {
+		req.TargetUserId = req.UserId
+	}
+	return req, nil
+}
@@ -2,9 +2,11 @@ package mapper
 
 import (
 	"strconv"
+	"time"
 
 	"github.com/ChronoPlay/chronoplay-backend-service/dto"
 	"github.com/ChronoPlay/chronoplay-backend-service/helpers"
+	"github.com/ChronoPlay/chronoplay-backend-service/model"
 	"github.com/gin-gonic/gin"
 )
 
@@ -15,6 +17,7 @@ func DecodeTransferCashRequest(c *gin.Context) (dto.TransferCashRequest, *helper
 	}
 	userId, _ := c.Get("UserID")
 	req.UserId = userId.(uint32)
+	req.IdempotencyKey = c.GetString("IdempotencyKey")
 	return req, nil
 }
 
@@ -25,6 +28,7 @@ func DecodeTransferCardsRequest(c *gin.Context) (dto.TransferCardRequest, *helpe
 	}
 	userId, _ := c.Get("UserID")
 	req.UserId = userId.(uint32)
+	req.IdempotencyKey = c.GetString("IdempotencyKey")
 	return req, nil
 }
 
@@ -40,6 +44,47 @@ func DecodeGetTransactionsRequest(c *gin.Context) (dto.GetTransactionsRequest, *
 		}
 		req.TransactionGuids = []uint32{uint32(transactionGuidUint)}
 	}
+	chainGuid, exists := c.GetQuery("chain_guid")
+	if exists {
+		chainGuidUint, perr := strconv.ParseUint(chainGuid, 10, 32)
+		if perr != nil {
+			return req, helpers.BadRequest("Invalid chain_guid: " + perr.Error())
+		}
+		req.ChainGuid = uint32(chainGuidUint)
+	}
+	for name, target := range map[string]*uint32{"cursor": &req.Cursor, "counterparty": &req.Counterparty} {
+		value, exists := c.GetQuery(name)
+		if !exists {
+			continue
+		}
+		valueUint, perr := strconv.ParseUint(value, 10, 32)
+		if perr != nil {
+			return req, helpers.BadRequest("Invalid " + name + ": " + perr.Error())
+		}
+		*target = uint32(valueUint)
+	}
+	limit, exists := c.GetQuery("limit")
+	if exists {
+		limitInt, perr := strconv.Atoi(limit)
+		if perr != nil {
+			return req, helpers.BadRequest("Invalid limit: " + perr.Error())
+		}
+		req.Limit = limitInt
+	}
+	for name, target := range map[string]*time.Time{"from": &req.From, "to": &req.To} {
+		value, exists := c.GetQuery(name)
+		if !exists {
+			continue
+		}
+		parsed, perr := time.Parse(time.RFC3339, value)
+		if perr != nil {
+			return req, helpers.BadRequest("Invalid " + name + ", expected RFC3339: " + perr.Error())
+		}
+		*target = parsed
+	}
+	req.Status = c.Query("status")
+	req.CardNumber = c.Query("card_number")
+	req.Direction = c.Query("direction")
 	return req, nil
 }
 
@@ -51,6 +96,7 @@ func DecodeExchangeRequest(c *gin.Context) (dto.ExchangeRequest, *helpers.Custom
 	userId, _ := c.Get("UserID")
 	req.UserId = userId.(uint32)
 	req.GivenBy = userId.(uint32)
+	req.IdempotencyKey = c.GetString("IdempotencyKey")
 	return req, nil
 }
 
@@ -78,3 +124,86 @@ func DecodeExecuteExchangeRequest(c *gin.Context) (dto.ExecuteExchangeRequest, *
 	req.UserId = userId.(uint32)
 	return req, nil
 }
+
+func DecodeRespondTransferRequest(c *gin.Context) (dto.RespondTransferRequest, *helpers.CustomError) {
+	var req dto.RespondTransferRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		return req, helpers.BadRequest("Invalid request body" + err.Error())
+	}
+	userId, _ := c.Get("UserID")
+	req.UserId = userId.(uint32)
+	return req, nil
+}
+
+func DecodeCancelExchangeRequest(c *gin.Context) (dto.CancelExchangeRequest, *helpers.CustomError) {
+	var req dto.CancelExchangeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		return req, helpers.BadRequest("Invalid request body" + err.Error())
+	}
+	userId, _ := c.Get("UserID")
+	req.UserId = userId.(uint32)
+	return req, nil
+}
+
+func DecodeCounterExchangeRequest(c *gin.Context) (dto.CounterExchangeRequest, *helpers.CustomError) {
+	var req dto.CounterExchangeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		return req, helpers.BadRequest("Invalid request body" + err.Error())
+	}
+	userId, _ := c.Get("UserID")
+	req.UserId = userId.(uint32)
+	return req, nil
+}
+
+func DecodeTradeRequest(c *gin.Context) (dto.TradeRequest, *helpers.CustomError) {
+	var req dto.TradeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		return req, helpers.BadRequest("Invalid request body" + err.Error())
+	}
+	userId, _ := c.Get("UserID")
+	req.UserId = userId.(uint32)
+	req.IdempotencyKey = c.GetString("IdempotencyKey")
+	return req, nil
+}
+
+func DecodeRespondTradeRequest(c *gin.Context) (dto.RespondTradeRequest, *helpers.CustomError) {
+	var req dto.RespondTradeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		return req, helpers.BadRequest("Invalid request body" + err.Error())
+	}
+	userId, _ := c.Get("UserID")
+	req.UserId = userId.(uint32)
+	return req, nil
+}
+
+func DecodeGiveCardsRequest(c *gin.Context) (dto.GiveCardsRequest, *helpers.CustomError) {
+	var req dto.GiveCardsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		return req, helpers.BadRequest("Invalid request body" + err.Error())
+	}
+	userId, _ := c.Get("UserID")
+	req.UserId = userId.(uint32)
+	req.IdempotencyKey = c.GetString("IdempotencyKey")
+	return req, nil
+}
+
+func DecodeExportTransactionsRequest(c *gin.Context) (dto.ExportTransactionsRequest, *helpers.CustomError) {
+	var req dto.ExportTransactionsRequest
+	filter, err := DecodeGetTransactionsRequest(c)
+	if err != nil {
+		return req, err
+	}
+	req.Filter = filter
+	req.UserId = filter.UserId
+	req.TargetUserId = filter.UserId
+	targetUserId, exists := c.GetQuery("user_id")
+	if exists {
+		targetUserIdUint, perr := strconv.ParseUint(targetUserId, 10, 32)
+		if perr != nil {
+			return req, helpers.BadRequest("Invalid user_id: " + perr.Error())
+		}
+		req.TargetUserId = uint32(targetUserIdUint)
+	}
+	req.Format = c.DefaultQuery("format", model.TRANSACTION_EXPORT_FORMAT_CSV)
+	return req, nil
+}
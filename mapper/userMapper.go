@@ -77,6 +77,7 @@ func MapCardsToResponse(cards []model.Card, occupiedMap map[string]uint32) []dto
 			Description: card.Description,
 			Rarity:      card.Rarity,
 			Name:        card.Name,
+			Retired:     card.Retired,
 		})
 	}
 	return cardResponses
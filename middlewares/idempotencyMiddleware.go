@@ -0,0 +1,97 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ChronoPlay/chronoplay-backend-service/constants"
+	service "github.com/ChronoPlay/chronoplay-backend-service/services"
+)
+
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+type responseRecorder struct {
+	gin.ResponseWriter
+	body *bytes.Buffer
+}
+
+func (w *responseRecorder) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *responseRecorder) WriteString(data string) (int, error) {
+	w.body.WriteString(data)
+	return w.ResponseWriter.WriteString(data)
+}
+
+// Idempotency answers a retried request carrying the same Idempotency-Key with the
+// response of the first one instead of running it again. Requests without the header
+// pass through untouched. Must run after AuthorizeUser, keys are scoped per user.
+func Idempotency(idempotencyService service.IdempotencyService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyKeyHeader)
+		if key == "" {
+			c.Next()
+			return
+		}
+		userIdValue, exists := c.Get("UserID")
+		if !exists {
+			c.Next()
+			return
+		}
+		userId := userIdValue.(uint32)
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, constants.JsonResp{Message: "Failed to read request body"})
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		hash := sha256.New()
+		hash.Write([]byte(c.Request.Method + " " + c.FullPath() + "\n"))
+		hash.Write(body)
+		requestHash := hex.EncodeToString(hash.Sum(nil))
+
+		ctx := c.Request.Context()
+		record, cerr := idempotencyService.Begin(ctx, userId, key, requestHash)
+		if cerr != nil {
+			c.AbortWithStatusJSON(cerr.Code, constants.JsonResp{Message: cerr.Message})
+			return
+		}
+		if record != nil {
+			log.Printf("Replaying response for Idempotency-Key %s of user %d", key, userId)
+			c.Header("Idempotent-Replayed", "true")
+			c.Data(record.ResponseCode, "application/json; charset=utf-8", record.ResponseBody)
+			c.Abort()
+			return
+		}
+
+		// the service commits the key together with the request's writes
+		c.Set("IdempotencyKey", key)
+		recorder := &responseRecorder{ResponseWriter: c.Writer, body: &bytes.Buffer{}}
+		c.Writer = recorder
+		c.Next()
+
+		// the client may have hung up already, the outcome still has to be stored
+		ctx = context.WithoutCancel(ctx)
+
+		// server errors are not stored so the client can retry them with the same key
+		if c.Writer.Status() >= http.StatusInternalServerError {
+			if cerr := idempotencyService.Abandon(ctx, userId, key); cerr != nil {
+				log.Printf("Error releasing Idempotency-Key %s of user %d: %v", key, userId, cerr)
+			}
+			return
+		}
+		if cerr := idempotencyService.Complete(ctx, userId, key, c.Writer.Status(), recorder.body.Bytes()); cerr != nil {
+			log.Printf("Error storing response for Idempotency-Key %s of user %d: %v", key, userId, cerr)
+		}
+	}
+}
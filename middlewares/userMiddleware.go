@@ -99,6 +99,22 @@ func (mw userMiddleware) UpdateUser(ctx context.Context, user model.User) (err *
 	return mw.next.UpdateUser(ctx, user)
 }
 
+func (mw userMiddleware) DeductCash(ctx context.Context, userId uint32, amount model.Money) (err *helpers.CustomError) {
+	defer func(begin time.Time) {
+		log.Printf("ctx:%v method:%v userID:%d amount:%s took:%v err:%v",
+			ctx, "DeductCash", userId, amount, time.Since(begin), err)
+	}(time.Now())
+	return mw.next.DeductCash(ctx, userId, amount)
+}
+
+func (mw userMiddleware) WipeUser(ctx context.Context, userId uint32) (err *helpers.CustomError) {
+	defer func(begin time.Time) {
+		log.Printf("ctx:%v method:%v userID:%d took:%v err:%v",
+			ctx, "WipeUser", userId, time.Since(begin), err)
+	}(time.Now())
+	return mw.next.WipeUser(ctx, userId)
+}
+
 func (mw userMiddleware) ActivateAllUsers(ctx context.Context) (err *helpers.CustomError) {
 	defer func(begin time.Time) {
 		log.Printf("ctx:%v method:%v took:%v err:%v",
@@ -0,0 +1,84 @@
+package model
+
+import (
+	"context"
+	"time"
+
+	"github.com/ChronoPlay/chronoplay-backend-service/helpers"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CardAudit records one admin change of a card and the value of every field it
+// touched before and after
+type CardAudit struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	AuditId    uint32             `bson:"audit_id" json:"audit_id"`
+	CardNumber string             `bson:"card_number" json:"card_number"`
+	Action     string             `bson:"action" json:"action"`
+	ChangedBy  uint32             `bson:"changed_by" json:"changed_by"`
+	Changes    []CardChange       `bson:"changes" json:"changes"`
+	CreatedAt  primitive.DateTime `bson:"created_at" json:"created_at"`
+}
+
+type CardChange struct {
+	Field string `bson:"field" json:"field"`
+	From  string `bson:"from" json:"from"`
+	To    string `bson:"to" json:"to"`
+}
+
+type CardAuditRepository interface {
+	GetCollection() *mongo.Collection
+	AddAudit(ctx context.Context, audit CardAudit) *helpers.CustomError
+	GetAuditsByCardNumber(ctx context.Context, cardNumber string) ([]CardAudit, *helpers.CustomError)
+}
+
+type mongoCardAuditRepo struct {
+	collection *mongo.Collection
+}
+
+func NewCardAuditRepository(col *mongo.Collection) CardAuditRepository {
+	return &mongoCardAuditRepo{collection: col}
+}
+
+func (repo *mongoCardAuditRepo) GetCollection() *mongo.Collection {
+	return repo.collection
+}
+
+func (repo *mongoCardAuditRepo) AddAudit(ctx context.Context, audit CardAudit) *helpers.CustomError {
+	nextId, err := GetNextSequence(ctx, repo.collection.Database(), "cardAudits")
+	if err != nil {
+		return helpers.SystemError("Failed to generate audit ID", err)
+	}
+	audit.AuditId = uint32(nextId)
+	audit.CreatedAt = primitive.NewDateTimeFromTime(time.Now())
+	_, err = repo.collection.InsertOne(ctx, audit)
+	if err != nil {
+		return helpers.SystemError("Failed to add card audit", err)
+	}
+	return nil
+}
+
+// newest change first
+func (repo *mongoCardAuditRepo) GetAuditsByCardNumber(ctx context.Context, cardNumber string) ([]CardAudit, *helpers.CustomError) {
+	audits := []CardAudit{}
+	cursor, err := repo.collection.Find(ctx, bson.M{"card_number": cardNumber},
+		options.Find().SetSort(bson.D{{Key: "audit_id", Value: -1}}))
+	if err != nil {
+		return nil, helpers.SystemError("Failed to get card audits", err)
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		var audit CardAudit
+		if err := cursor.Decode(&audit); err != nil {
+			return nil, helpers.SystemError("Failed to decode card audit", err)
+		}
+		audits = append(audits, audit)
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, helpers.SystemError("Failed to iterate cursor", err)
+	}
+	return audits, nil
+}
@@ -9,6 +9,7 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type Card struct {
@@ -22,6 +23,8 @@ type Card struct {
 	Creator     uint32             `bson:"creator" json:"creator"`
 	ImageUrl    string             `bson:"image_url" json:"image_url"`
 	Rarity      string             `bson:"rarity" json:"rarity"`
+	Retired     bool               `bson:"retired,omitempty" json:"retired,omitempty"` // can't be minted or traded anymore
+	Version     uint32             `bson:"version" json:"version"`
 }
 
 type GetCardsRequest struct {
@@ -29,6 +32,18 @@ type GetCardsRequest struct {
 	Numbers []string `json:"numbers"`
 }
 
+// CardCatalogFilter narrows and orders the card catalog, zero values don't filter
+type CardCatalogFilter struct {
+	Search       string // text search over name and description
+	Rarity       string
+	Creator      uint32
+	MinAvailable uint32 // total - occupied
+	WithRetired  bool
+	Sort         string // CARD_SORT_*, text relevance when empty and searching
+	Skip         int64
+	Limit        int64
+}
+
 type CardRepository interface {
 	GetCollection() *mongo.Collection
 	AddCard(ctx context.Context, card Card) *helpers.CustomError
@@ -38,6 +53,9 @@ type CardRepository interface {
 	GetOwnersByCardNumber(ctx context.Context, cardNumber string) ([]uint32, *helpers.CustomError)
 	GetCards(ctx context.Context, req GetCardsRequest) ([]Card, *helpers.CustomError)
 	UpdateCards(ctx context.Context, cards []Card) *helpers.CustomError
+	ReserveCards(ctx context.Context, cardNumber string, quantity uint32) *helpers.CustomError
+	EnsureIndexes(ctx context.Context) *helpers.CustomError
+	SearchCards(ctx context.Context, filter CardCatalogFilter) ([]Card, int64, *helpers.CustomError)
 }
 
 type mongoCardRepo struct {
@@ -98,24 +116,29 @@ func (repo *mongoCardRepo) GetCardByNumber(ctx context.Context, cardNumber strin
 func (repo *mongoCardRepo) UpdateCard(ctx context.Context, card Card) *helpers.CustomError {
 	updateData, err := bson.Marshal(card)
 	if err != nil {
-		return helpers.System("failed to marshal card: " + err.Error())
+		return helpers.SystemError("failed to marshal card", err)
 	}
 
 	var updateDoc bson.M
 	if err := bson.Unmarshal(updateData, &updateDoc); err != nil {
-		return helpers.System("failed to unmarshal card: " + err.Error())
+		return helpers.SystemError("failed to unmarshal card", err)
 	}
 
 	// You might want to remove `_id` from updateDoc so Mongo doesn't complain
 	delete(updateDoc, "_id")
+	delete(updateDoc, "version")
 
 	update := bson.M{
 		"$set": updateDoc,
+		"$inc": bson.M{"version": 1},
 	}
 
-	_, err = repo.collection.UpdateByID(ctx, card.ID, update)
+	result, err := repo.collection.UpdateOne(ctx, versionFilter(card.ID, card.Version), update)
 	if err != nil {
-		return helpers.System(err.Error())
+		return helpers.SystemError("", err)
+	}
+	if result.MatchedCount == 0 {
+		return versionMismatch(ctx, repo.collection, card.ID, "Card")
 	}
 
 	return nil
@@ -172,7 +195,7 @@ func (repo *mongoCardRepo) GetCards(ctx context.Context, req GetCardsRequest) ([
 		}
 		cursor, err := repo.collection.Find(ctx, filter)
 		if err != nil {
-			return cards, helpers.System(err.Error())
+			return cards, helpers.SystemError("", err)
 		}
 
 		defer cursor.Close(ctx)
@@ -187,8 +210,105 @@ func (repo *mongoCardRepo) GetCards(ctx context.Context, req GetCardsRequest) ([
 		}
 
 		if err := cursor.Err(); err != nil {
-			return cards, helpers.System(err.Error())
+			return cards, helpers.SystemError("", err)
 		}
 	}
 	return cards, nil
 }
+
+// marks quantity more copies of a card as handed out by the system, only while
+// occupied + quantity stays within the total
+func (repo *mongoCardRepo) ReserveCards(ctx context.Context, cardNumber string, quantity uint32) *helpers.CustomError {
+	filter := bson.M{
+		"number": cardNumber,
+		"$expr":  bson.M{"$lte": bson.A{bson.M{"$add": bson.A{"$occupied", quantity}}, "$total"}},
+	}
+	result, err := repo.collection.UpdateOne(ctx, filter, bson.M{"$inc": bson.M{"occupied": quantity, "version": 1}})
+	if err != nil {
+		return helpers.SystemError("failed to reserve cards", err)
+	}
+	if result.MatchedCount == 0 {
+		return helpers.BadRequest("Insufficient card balance for card: " + cardNumber)
+	}
+	return nil
+}
+
+// the text index backs catalog search, the compound ones the rarity and creator
+// filters in name order and the owners one removing a wiped user from every card
+func (repo *mongoCardRepo) EnsureIndexes(ctx context.Context) *helpers.CustomError {
+	_, err := repo.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "name", Value: "text"}, {Key: "description", Value: "text"}},
+			Options: options.Index().SetWeights(bson.D{{Key: "name", Value: 5}, {Key: "description", Value: 1}}),
+		},
+		{Keys: bson.D{{Key: "name", Value: 1}, {Key: "number", Value: 1}}},
+		{Keys: bson.D{{Key: "rarity", Value: 1}, {Key: "name", Value: 1}}},
+		{Keys: bson.D{{Key: "creator", Value: 1}, {Key: "name", Value: 1}}},
+		{Keys: bson.D{{Key: "owners", Value: 1}}},
+	})
+	if err != nil {
+		return helpers.SystemError("Failed to create card indexes", err)
+	}
+	return nil
+}
+
+// one page of the catalog and the number of cards matching the filter
+func (repo *mongoCardRepo) SearchCards(ctx context.Context, filter CardCatalogFilter) ([]Card, int64, *helpers.CustomError) {
+	match := bson.M{}
+	if filter.Search != "" {
+		match["$text"] = bson.M{"$search": filter.Search}
+	}
+	if filter.Rarity != "" {
+		match["rarity"] = filter.Rarity
+	}
+	if filter.Creator != 0 {
+		match["creator"] = filter.Creator
+	}
+	if !filter.WithRetired {
+		match["retired"] = bson.M{"$ne": true}
+	}
+	if filter.MinAvailable != 0 {
+		match["$expr"] = bson.M{"$gte": bson.A{bson.M{"$subtract": bson.A{"$total", "$occupied"}}, filter.MinAvailable}}
+	}
+	total, err := repo.collection.CountDocuments(ctx, match)
+	if err != nil {
+		return nil, 0, helpers.SystemError("Failed to count cards", err)
+	}
+
+	pipeline := mongo.Pipeline{{{Key: "$match", Value: match}}}
+	switch {
+	case filter.Sort == CARD_SORT_SCARCITY:
+		pipeline = append(pipeline,
+			bson.D{{Key: "$addFields", Value: bson.M{"available": bson.M{"$subtract": bson.A{"$total", "$occupied"}}}}},
+			bson.D{{Key: "$sort", Value: bson.D{{Key: "available", Value: 1}, {Key: "total", Value: 1}, {Key: "number", Value: 1}}}},
+		)
+	case filter.Sort == CARD_SORT_NEWEST:
+		// object ids start with their creation time
+		pipeline = append(pipeline, bson.D{{Key: "$sort", Value: bson.D{{Key: "_id", Value: -1}}}})
+	case filter.Sort == "" && filter.Search != "":
+		pipeline = append(pipeline, bson.D{{Key: "$sort", Value: bson.D{{Key: "score", Value: bson.M{"$meta": "textScore"}}, {Key: "number", Value: 1}}}})
+	default:
+		pipeline = append(pipeline, bson.D{{Key: "$sort", Value: bson.D{{Key: "name", Value: 1}, {Key: "number", Value: 1}}}})
+	}
+	pipeline = append(pipeline,
+		bson.D{{Key: "$skip", Value: filter.Skip}},
+		bson.D{{Key: "$limit", Value: filter.Limit}},
+	)
+	cursor, err := repo.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, 0, helpers.SystemError("Failed to search cards", err)
+	}
+	defer cursor.Close(ctx)
+	cards := []Card{}
+	for cursor.Next(ctx) {
+		var card Card
+		if err := cursor.Decode(&card); err != nil {
+			return nil, 0, helpers.SystemError("Failed to decode card", err)
+		}
+		cards = append(cards, card)
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, 0, helpers.SystemError("Failed to iterate cursor", err)
+	}
+	return cards, total, nil
+}
@@ -2,6 +2,7 @@ package model
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"time"
 
@@ -24,6 +25,13 @@ type CardTransaction struct {
 	CreatedBy       uint32             `bson:"created_by" json:"created_by"`
 	UpdatedAt       primitive.DateTime `bson:"updated_at,omitempty" json:"updated_at,omitempty"`
 	UpdatedBy       uint32             `bson:"updated_by,omitempty" json:"updated_by,omitempty"`
+	LoanId          uint32             `bson:"loan_id,omitempty" json:"loan_id,omitempty"`
+	Type            string             `bson:"type,omitempty" json:"type,omitempty"`
+	ExpiresAt       primitive.DateTime `bson:"expires_at,omitempty" json:"expires_at,omitempty"`   // set on exchange proposals
+	ParentGuid      uint32             `bson:"parent_guid,omitempty" json:"parent_guid,omitempty"` // proposal this counter-offer answers
+	ChainGuid       uint32             `bson:"chain_guid,omitempty" json:"chain_guid,omitempty"`   // first proposal of the negotiation, only set on counter-offers
+	Held            bool               `bson:"held,omitempty" json:"held,omitempty"`               // the giver's side was put on hold when the exchange was proposed
+	ApprovedBy      []uint32           `bson:"approved_by,omitempty" json:"approved_by,omitempty"` // participants of a trade who approved it
 }
 
 type CardTransactionRepository interface {
@@ -35,6 +43,11 @@ type CardTransactionRepository interface {
 	GetCardTransactionsToUserId(ctx context.Context, userId uint32) ([]CardTransaction, *helpers.CustomError)
 	GetCardTransactionsByTransactionGuid(ctx context.Context, transactionGuid uint32) ([]CardTransaction, *helpers.CustomError)
 	UpdateCardTransactions(ctx context.Context, transactions []CardTransaction) *helpers.CustomError
+	GetExpiredCardTransactions(ctx context.Context, now time.Time) ([]CardTransaction, *helpers.CustomError)
+	ApproveCardTransactions(ctx context.Context, transactionGuid uint32, userId uint32) *helpers.CustomError
+	EnsureIndexes(ctx context.Context) *helpers.CustomError
+	GetCardTransactionGuids(ctx context.Context, filter TransactionFilter) ([]uint32, *helpers.CustomError)
+	GetCardTransactionsOfUser(ctx context.Context, userId uint32, transactionGuids []uint32) ([]CardTransaction, *helpers.CustomError)
 }
 
 type mongoCardTransactionRepo struct {
@@ -53,26 +66,25 @@ func (repo *mongoCardTransactionRepo) AddCardTransaction(ctx context.Context, tr
 	if transaction.TransactionGuid == 0 {
 		nextGuid, err := GetNextSequence(ctx, repo.collection.Database(), "transactionGuids")
 		if err != nil {
-			return 0, helpers.System("Failed to generate transaction GUID: " + err.Error())
+			return 0, helpers.SystemError("Failed to generate transaction GUID", err)
 		}
 		transaction.TransactionGuid = uint32(nextGuid)
 	}
 	nextId, err := GetNextSequence(ctx, repo.collection.Database(), "cardTransactions")
 	if err != nil {
-		return 0, helpers.System("Failed to generate transaction ID: " + err.Error())
+		return 0, helpers.SystemError("Failed to generate transaction ID", err)
 	}
 	transaction.TransactionId = uint32(nextId)
 	transaction.CreatedAt = primitive.NewDateTimeFromTime(time.Now())
 	_, err = repo.collection.InsertOne(ctx, transaction)
 	if err != nil {
-		return 0, helpers.System("Failed to add card transaction: " + err.Error())
+		return 0, helpers.SystemError("Failed to add card transaction", err)
 	}
 	return transaction.TransactionGuid, nil
 }
 
 func (repo *mongoCardTransactionRepo) AddCardTransactions(ctx context.Context, transactions []CardTransaction) (guid uint32, herr *helpers.CustomError) {
 	log.Printf("Adding %d card transactions\n", len(transactions))
-	log.Printf("Card transactions details: %+v\n", transactions)
 	if len(transactions) == 0 {
 		return 0, helpers.BadRequest("No transactions to add")
 	}
@@ -80,7 +92,7 @@ func (repo *mongoCardTransactionRepo) AddCardTransactions(ctx context.Context, t
 	if transactionGuid == 0 {
 		nextGuid, err := GetNextSequence(ctx, repo.collection.Database(), "transactionGuids")
 		if err != nil {
-			return 0, helpers.System("Failed to generate transaction GUID: " + err.Error())
+			return 0, helpers.SystemError("Failed to generate transaction GUID", err)
 		}
 		transactionGuid = uint32(nextGuid)
 	}
@@ -90,7 +102,7 @@ func (repo *mongoCardTransactionRepo) AddCardTransactions(ctx context.Context, t
 		}
 		nextId, err := GetNextSequence(ctx, repo.collection.Database(), "cardTransactions")
 		if err != nil {
-			return 0, helpers.System("Failed to generate transaction ID: " + err.Error())
+			return 0, helpers.SystemError("Failed to generate transaction ID", err)
 		}
 		transactions[i].TransactionId = uint32(nextId)
 		transactions[i].CreatedAt = primitive.NewDateTimeFromTime(time.Now())
@@ -101,7 +113,7 @@ func (repo *mongoCardTransactionRepo) AddCardTransactions(ctx context.Context, t
 	}
 	_, err := repo.collection.InsertMany(ctx, docs)
 	if err != nil {
-		return 0, helpers.System("Failed to add card transactions: " + err.Error())
+		return 0, helpers.SystemError("Failed to add card transactions", err)
 	}
 	return transactionGuid, nil
 }
@@ -110,20 +122,20 @@ func (repo *mongoCardTransactionRepo) GetCardTransactionsByCardNumber(ctx contex
 	var transactions []CardTransaction
 	cursor, err := repo.collection.Find(ctx, bson.M{"card_number": cardNumber})
 	if err != nil {
-		return nil, helpers.System("Failed to get card transactions by card number: " + err.Error())
+		return nil, helpers.SystemError("Failed to get card transactions by card number", err)
 	}
 	defer cursor.Close(ctx)
 
 	for cursor.Next(ctx) {
 		var transaction CardTransaction
 		if err := cursor.Decode(&transaction); err != nil {
-			return nil, helpers.System("Failed to decode card transaction: " + err.Error())
+			return nil, helpers.SystemError("Failed to decode card transaction", err)
 		}
 		transactions = append(transactions, transaction)
 	}
 
 	if err := cursor.Err(); err != nil {
-		return nil, helpers.System("Cursor iteration error: " + err.Error())
+		return nil, helpers.SystemError("Cursor iteration error", err)
 	}
 
 	return transactions, nil
@@ -131,23 +143,20 @@ func (repo *mongoCardTransactionRepo) GetCardTransactionsByCardNumber(ctx contex
 
 func (repo *mongoCardTransactionRepo) GetCardTransactionsByUserId(ctx context.Context, userId uint32) ([]CardTransaction, *helpers.CustomError) {
 	var transactions []CardTransaction
-	cursor, err := repo.collection.Find(ctx, bson.M{"$or": []bson.M{
-		{"given_by": userId},
-		{"given_to": userId},
-	}})
+	cursor, err := repo.collection.Find(ctx, bson.M{"given_by": userId})
 	if err != nil {
-		return nil, helpers.System("Failed to get card transactions by user ID: " + err.Error())
+		return nil, helpers.SystemError("Failed to get card transactions by user ID", err)
 	}
 	defer cursor.Close(ctx)
 	for cursor.Next(ctx) {
 		var transaction CardTransaction
 		if err := cursor.Decode(&transaction); err != nil {
-			return nil, helpers.System("Failed to decode card transaction: " + err.Error())
+			return nil, helpers.SystemError("Failed to decode card transaction", err)
 		}
 		transactions = append(transactions, transaction)
 	}
 	if err := cursor.Err(); err != nil {
-		return nil, helpers.System("Cursor iteration error: " + err.Error())
+		return nil, helpers.SystemError("Cursor iteration error", err)
 	}
 	return transactions, nil
 }
@@ -156,20 +165,20 @@ func (repo *mongoCardTransactionRepo) GetCardTransactionsToUserId(ctx context.Co
 	var transactions []CardTransaction
 	cursor, err := repo.collection.Find(ctx, bson.M{"given_to": userId})
 	if err != nil {
-		return nil, helpers.System("Failed to get card transactions to user ID: " + err.Error())
+		return nil, helpers.SystemError("Failed to get card transactions to user ID", err)
 	}
 	defer cursor.Close(ctx)
 
 	for cursor.Next(ctx) {
 		var transaction CardTransaction
 		if err := cursor.Decode(&transaction); err != nil {
-			return nil, helpers.System("Failed to decode card transaction: " + err.Error())
+			return nil, helpers.SystemError("Failed to decode card transaction", err)
 		}
 		transactions = append(transactions, transaction)
 	}
 
 	if err := cursor.Err(); err != nil {
-		return nil, helpers.System("Cursor iteration error: " + err.Error())
+		return nil, helpers.SystemError("Cursor iteration error", err)
 	}
 	return transactions, nil
 }
@@ -178,20 +187,20 @@ func (repo *mongoCardTransactionRepo) GetCardTransactionsByTransactionGuid(ctx c
 	var transactions []CardTransaction
 	cursor, err := repo.collection.Find(ctx, bson.M{"transaction_guid": transactionGuid})
 	if err != nil {
-		return nil, helpers.System("Failed to get card transactions by transaction GUID: " + err.Error())
+		return nil, helpers.SystemError("Failed to get card transactions by transaction GUID", err)
 	}
 	defer cursor.Close(ctx)
 
 	for cursor.Next(ctx) {
 		var transaction CardTransaction
 		if err := cursor.Decode(&transaction); err != nil {
-			return nil, helpers.System("Failed to decode card transaction: " + err.Error())
+			return nil, helpers.SystemError("Failed to decode card transaction", err)
 		}
 		transactions = append(transactions, transaction)
 	}
 
 	if err := cursor.Err(); err != nil {
-		return nil, helpers.System("Cursor iteration error: " + err.Error())
+		return nil, helpers.SystemError("Cursor iteration error", err)
 	}
 
 	return transactions, nil
@@ -216,8 +225,103 @@ func (repo *mongoCardTransactionRepo) UpdateCardTransactions(ctx context.Context
 		}
 		_, err := repo.collection.UpdateOne(ctx, filter, update)
 		if err != nil {
-			return helpers.System("Failed to update card transaction ID " + string(transaction.TransactionId) + ": " + err.Error())
+			return helpers.System(fmt.Sprintf("Failed to update card transaction ID %d: %s", transaction.TransactionId, err.Error()))
 		}
 	}
 	return nil
 }
+
+// pending transactions whose expires_at has passed, transactions without expiry are never returned
+func (repo *mongoCardTransactionRepo) GetExpiredCardTransactions(ctx context.Context, now time.Time) ([]CardTransaction, *helpers.CustomError) {
+	var transactions []CardTransaction
+	cursor, err := repo.collection.Find(ctx, bson.M{
+		"status":     TRANSACTION_STATUS_PENDING,
+		"expires_at": bson.M{"$lt": primitive.NewDateTimeFromTime(now)},
+	})
+	if err != nil {
+		return nil, helpers.SystemError("Failed to get expired card transactions", err)
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		var transaction CardTransaction
+		if err := cursor.Decode(&transaction); err != nil {
+			return nil, helpers.SystemError("Failed to decode card transaction", err)
+		}
+		transactions = append(transactions, transaction)
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, helpers.SystemError("Failed to iterate cursor", err)
+	}
+	return transactions, nil
+}
+
+// records the approval of a trade participant on every leg of the trade and marks the
+// legs they give as held
+func (repo *mongoCardTransactionRepo) ApproveCardTransactions(ctx context.Context, transactionGuid uint32, userId uint32) *helpers.CustomError {
+	_, err := repo.collection.UpdateMany(ctx, bson.M{"transaction_guid": transactionGuid}, bson.M{
+		"$addToSet": bson.M{"approved_by": userId},
+		"$set":      bson.M{"updated_at": primitive.NewDateTimeFromTime(time.Now()), "updated_by": userId},
+	})
+	if err != nil {
+		return helpers.SystemError("Failed to approve card transactions", err)
+	}
+	_, err = repo.collection.UpdateMany(ctx, bson.M{"transaction_guid": transactionGuid, "given_by": userId}, bson.M{
+		"$set": bson.M{"held": true},
+	})
+	if err != nil {
+		return helpers.SystemError("Failed to hold card transactions", err)
+	}
+	return nil
+}
+
+func (repo *mongoCardTransactionRepo) EnsureIndexes(ctx context.Context) *helpers.CustomError {
+	indexes := append([]mongo.IndexModel{}, transactionIndexes...)
+	indexes = append(indexes, mongo.IndexModel{
+		Keys: bson.D{{Key: "card_number", Value: 1}, {Key: "transaction_guid", Value: -1}},
+	})
+	_, err := repo.collection.Indexes().CreateMany(ctx, indexes)
+	if err != nil {
+		return helpers.SystemError("Failed to create card transaction indexes", err)
+	}
+	return nil
+}
+
+// guids of the transactions matching the filter in page order, at most filter.Limit
+func (repo *mongoCardTransactionRepo) GetCardTransactionGuids(ctx context.Context, filter TransactionFilter) ([]uint32, *helpers.CustomError) {
+	cursor, err := repo.collection.Find(ctx, filter.query(), filter.findOptions())
+	if err != nil {
+		return nil, helpers.SystemError("Failed to get card transaction guids", err)
+	}
+	guids, err := collectTransactionGuids(ctx, cursor, filter.Limit)
+	if err != nil {
+		return nil, helpers.SystemError("Failed to iterate cursor", err)
+	}
+	return guids, nil
+}
+
+// the legs of the given transactions that the user gives or receives
+func (repo *mongoCardTransactionRepo) GetCardTransactionsOfUser(ctx context.Context, userId uint32, transactionGuids []uint32) ([]CardTransaction, *helpers.CustomError) {
+	var transactions []CardTransaction
+	if len(transactionGuids) == 0 {
+		return transactions, nil
+	}
+	cursor, err := repo.collection.Find(ctx, bson.M{
+		"transaction_guid": bson.M{"$in": transactionGuids},
+		"$or":              bson.A{bson.M{"given_by": userId}, bson.M{"given_to": userId}},
+	})
+	if err != nil {
+		return nil, helpers.SystemError("Failed to get card transactions of user", err)
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		var transaction CardTransaction
+		if err := cursor.Decode(&transaction); err != nil {
+			return nil, helpers.SystemError("Failed to decode card transaction", err)
+		}
+		transactions = append(transactions, transaction)
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, helpers.SystemError("Failed to iterate cursor", err)
+	}
+	return transactions, nil
+}
@@ -15,7 +15,7 @@ type CashTransaction struct {
 	ID              primitive.ObjectID `bson:"_id,omitempty" json:"id"`
 	TransactionId   uint32             `bson:"transaction_id" json:"transaction_id"`
 	TransactionGuid uint32             `bson:"transaction_guid" json:"transaction_guid"`
-	Amount          float32            `bson:"amount" json:"amount"`
+	Amount          Money              `bson:"amount" json:"amount"`
 	GivenBy         uint32             `bson:"given_by" json:"given_by"`
 	GivenTo         uint32             `bson:"given_to" json:"given_to"`
 	Status          string             `bson:"status" json:"status"`
@@ -23,6 +23,13 @@ type CashTransaction struct {
 	CreatedBy       uint32             `bson:"created_by" json:"created_by"`
 	UpdatedBy       uint32             `bson:"updated_by,omitempty" json:"updated_by,omitempty"`
 	UpdatedAt       primitive.DateTime `bson:"updated_at,omitempty" json:"updated_at,omitempty"`
+	LoanId          uint32             `bson:"loan_id,omitempty" json:"loan_id,omitempty"`
+	Type            string             `bson:"type,omitempty" json:"type,omitempty"`
+	ExpiresAt       primitive.DateTime `bson:"expires_at,omitempty" json:"expires_at,omitempty"`   // set on exchange proposals
+	ParentGuid      uint32             `bson:"parent_guid,omitempty" json:"parent_guid,omitempty"` // proposal this counter-offer answers
+	ChainGuid       uint32             `bson:"chain_guid,omitempty" json:"chain_guid,omitempty"`   // first proposal of the negotiation, only set on counter-offers
+	Held            bool               `bson:"held,omitempty" json:"held,omitempty"`               // the giver's side was put on hold when the exchange was proposed
+	ApprovedBy      []uint32           `bson:"approved_by,omitempty" json:"approved_by,omitempty"` // participants of a trade who approved it
 }
 
 type CashTransactionRepository interface {
@@ -33,6 +40,11 @@ type CashTransactionRepository interface {
 	GetCashTransactionsByTransactionId(ctx context.Context, transactionId uint32) ([]CashTransaction, *helpers.CustomError)
 	GetCashTransactionsByTransactionGuid(ctx context.Context, transactionGuid uint32) ([]CashTransaction, *helpers.CustomError)
 	UpdateCashTransactions(ctx context.Context, transactions []CashTransaction) *helpers.CustomError
+	GetExpiredCashTransactions(ctx context.Context, now time.Time) ([]CashTransaction, *helpers.CustomError)
+	ApproveCashTransactions(ctx context.Context, transactionGuid uint32, userId uint32) *helpers.CustomError
+	EnsureIndexes(ctx context.Context) *helpers.CustomError
+	GetCashTransactionGuids(ctx context.Context, filter TransactionFilter) ([]uint32, *helpers.CustomError)
+	GetCashTransactionsOfUser(ctx context.Context, userId uint32, transactionGuids []uint32) ([]CashTransaction, *helpers.CustomError)
 }
 
 type mongoCashTransactionRepo struct {
@@ -51,42 +63,42 @@ func (repo *mongoCashTransactionRepo) AddCashTransaction(ctx context.Context, tr
 	if transaction.TransactionGuid == 0 {
 		nextGuid, err := GetNextSequence(ctx, repo.collection.Database(), "transactionGuids")
 		if err != nil {
-			return 0, helpers.System("Failed to generate transaction GUID: " + err.Error())
+			return 0, helpers.SystemError("Failed to generate transaction GUID", err)
 		}
 		transaction.TransactionGuid = uint32(nextGuid)
 	}
 	log.Printf("Generated Transaction GUID: %d\n", transaction.TransactionGuid)
 	nextId, err := GetNextSequence(ctx, repo.collection.Database(), "cashTransactions")
 	if err != nil {
-		return 0, helpers.System("Failed to generate transaction ID: " + err.Error())
+		return 0, helpers.SystemError("Failed to generate transaction ID", err)
 	}
 	transaction.TransactionId = uint32(nextId)
 	transaction.CreatedAt = primitive.NewDateTimeFromTime(time.Now())
 	_, err = repo.collection.InsertOne(ctx, transaction)
 	if err != nil {
-		return 0, helpers.System("Failed to add cash transaction: " + err.Error())
+		return 0, helpers.SystemError("Failed to add cash transaction", err)
 	}
-	return transaction.TransactionId, nil
+	return transaction.TransactionGuid, nil
 }
 
 func (repo *mongoCashTransactionRepo) GetCashTransactionsByUserId(ctx context.Context, userId uint32) ([]CashTransaction, *helpers.CustomError) {
 	var transactions []CashTransaction
 	cursor, err := repo.collection.Find(ctx, bson.M{"given_by": userId})
 	if err != nil {
-		return nil, helpers.System("Failed to get cash transactions by user ID: " + err.Error())
+		return nil, helpers.SystemError("Failed to get cash transactions by user ID", err)
 	}
 	defer cursor.Close(ctx)
 
 	for cursor.Next(ctx) {
 		var transaction CashTransaction
 		if err := cursor.Decode(&transaction); err != nil {
-			return nil, helpers.System("Failed to decode cash transaction: " + err.Error())
+			return nil, helpers.SystemError("Failed to decode cash transaction", err)
 		}
 		transactions = append(transactions, transaction)
 	}
 
 	if err := cursor.Err(); err != nil {
-		return nil, helpers.System("Failed to iterate cursor: " + err.Error())
+		return nil, helpers.SystemError("Failed to iterate cursor", err)
 	}
 
 	return transactions, nil
@@ -96,20 +108,20 @@ func (repo *mongoCashTransactionRepo) GetCashTransactionsToUserId(ctx context.Co
 	var transactions []CashTransaction
 	cursor, err := repo.collection.Find(ctx, bson.M{"given_to": userId})
 	if err != nil {
-		return nil, helpers.System("Failed to get cash transactions to user ID: " + err.Error())
+		return nil, helpers.SystemError("Failed to get cash transactions to user ID", err)
 	}
 	defer cursor.Close(ctx)
 
 	for cursor.Next(ctx) {
 		var transaction CashTransaction
 		if err := cursor.Decode(&transaction); err != nil {
-			return nil, helpers.System("Failed to decode cash transaction: " + err.Error())
+			return nil, helpers.SystemError("Failed to decode cash transaction", err)
 		}
 		transactions = append(transactions, transaction)
 	}
 
 	if err := cursor.Err(); err != nil {
-		return nil, helpers.System("Failed to iterate cursor: " + err.Error())
+		return nil, helpers.SystemError("Failed to iterate cursor", err)
 	}
 
 	return transactions, nil
@@ -119,20 +131,20 @@ func (repo *mongoCashTransactionRepo) GetCashTransactionsByTransactionId(ctx con
 	var transactions []CashTransaction
 	cursor, err := repo.collection.Find(ctx, bson.M{"transaction_id": transactionId})
 	if err != nil {
-		return nil, helpers.System("Failed to get cash transactions by transaction ID: " + err.Error())
+		return nil, helpers.SystemError("Failed to get cash transactions by transaction ID", err)
 	}
 	defer cursor.Close(ctx)
 
 	for cursor.Next(ctx) {
 		var transaction CashTransaction
 		if err := cursor.Decode(&transaction); err != nil {
-			return nil, helpers.System("Failed to decode cash transaction: " + err.Error())
+			return nil, helpers.SystemError("Failed to decode cash transaction", err)
 		}
 		transactions = append(transactions, transaction)
 	}
 
 	if err := cursor.Err(); err != nil {
-		return nil, helpers.System("Failed to iterate cursor: " + err.Error())
+		return nil, helpers.SystemError("Failed to iterate cursor", err)
 	}
 
 	return transactions, nil
@@ -142,20 +154,20 @@ func (repo *mongoCashTransactionRepo) GetCashTransactionsByTransactionGuid(ctx c
 	var transactions []CashTransaction
 	cursor, err := repo.collection.Find(ctx, bson.M{"transaction_guid": transactionGuid})
 	if err != nil {
-		return nil, helpers.System("Failed to get cash transactions by transaction GUID: " + err.Error())
+		return nil, helpers.SystemError("Failed to get cash transactions by transaction GUID", err)
 	}
 	defer cursor.Close(ctx)
 
 	for cursor.Next(ctx) {
 		var transaction CashTransaction
 		if err := cursor.Decode(&transaction); err != nil {
-			return nil, helpers.System("Failed to decode cash transaction: " + err.Error())
+			return nil, helpers.SystemError("Failed to decode cash transaction", err)
 		}
 		transactions = append(transactions, transaction)
 	}
 
 	if err := cursor.Err(); err != nil {
-		return nil, helpers.System("Failed to iterate cursor: " + err.Error())
+		return nil, helpers.SystemError("Failed to iterate cursor", err)
 	}
 
 	return transactions, nil
@@ -177,8 +189,99 @@ func (repo *mongoCashTransactionRepo) UpdateCashTransactions(ctx context.Context
 		}
 		_, err := repo.collection.UpdateOne(ctx, filter, update)
 		if err != nil {
-			return helpers.System("Failed to update cash transaction: " + err.Error())
+			return helpers.SystemError("Failed to update cash transaction", err)
 		}
 	}
 	return nil
 }
+
+// pending transactions whose expires_at has passed, transactions without expiry are never returned
+func (repo *mongoCashTransactionRepo) GetExpiredCashTransactions(ctx context.Context, now time.Time) ([]CashTransaction, *helpers.CustomError) {
+	var transactions []CashTransaction
+	cursor, err := repo.collection.Find(ctx, bson.M{
+		"status":     TRANSACTION_STATUS_PENDING,
+		"expires_at": bson.M{"$lt": primitive.NewDateTimeFromTime(now)},
+	})
+	if err != nil {
+		return nil, helpers.SystemError("Failed to get expired cash transactions", err)
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		var transaction CashTransaction
+		if err := cursor.Decode(&transaction); err != nil {
+			return nil, helpers.SystemError("Failed to decode cash transaction", err)
+		}
+		transactions = append(transactions, transaction)
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, helpers.SystemError("Failed to iterate cursor", err)
+	}
+	return transactions, nil
+}
+
+// records the approval of a trade participant on every leg of the trade and marks the
+// legs they give as held
+func (repo *mongoCashTransactionRepo) ApproveCashTransactions(ctx context.Context, transactionGuid uint32, userId uint32) *helpers.CustomError {
+	_, err := repo.collection.UpdateMany(ctx, bson.M{"transaction_guid": transactionGuid}, bson.M{
+		"$addToSet": bson.M{"approved_by": userId},
+		"$set":      bson.M{"updated_at": primitive.NewDateTimeFromTime(time.Now()), "updated_by": userId},
+	})
+	if err != nil {
+		return helpers.SystemError("Failed to approve cash transactions", err)
+	}
+	_, err = repo.collection.UpdateMany(ctx, bson.M{"transaction_guid": transactionGuid, "given_by": userId}, bson.M{
+		"$set": bson.M{"held": true},
+	})
+	if err != nil {
+		return helpers.SystemError("Failed to hold cash transactions", err)
+	}
+	return nil
+}
+
+func (repo *mongoCashTransactionRepo) EnsureIndexes(ctx context.Context) *helpers.CustomError {
+	_, err := repo.collection.Indexes().CreateMany(ctx, transactionIndexes)
+	if err != nil {
+		return helpers.SystemError("Failed to create cash transaction indexes", err)
+	}
+	return nil
+}
+
+// guids of the transactions matching the filter in page order, at most filter.Limit
+func (repo *mongoCashTransactionRepo) GetCashTransactionGuids(ctx context.Context, filter TransactionFilter) ([]uint32, *helpers.CustomError) {
+	cursor, err := repo.collection.Find(ctx, filter.query(), filter.findOptions())
+	if err != nil {
+		return nil, helpers.SystemError("Failed to get cash transaction guids", err)
+	}
+	guids, err := collectTransactionGuids(ctx, cursor, filter.Limit)
+	if err != nil {
+		return nil, helpers.SystemError("Failed to iterate cursor", err)
+	}
+	return guids, nil
+}
+
+// the legs of the given transactions that the user gives or receives
+func (repo *mongoCashTransactionRepo) GetCashTransactionsOfUser(ctx context.Context, userId uint32, transactionGuids []uint32) ([]CashTransaction, *helpers.CustomError) {
+	var transactions []CashTransaction
+	if len(transactionGuids) == 0 {
+		return transactions, nil
+	}
+	cursor, err := repo.collection.Find(ctx, bson.M{
+		"transaction_guid": bson.M{"$in": transactionGuids},
+		"$or":              bson.A{bson.M{"given_by": userId}, bson.M{"given_to": userId}},
+	})
+	if err != nil {
+		return nil, helpers.SystemError("Failed to get cash transactions of user", err)
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		var transaction CashTransaction
+		if err := cursor.Decode(&transaction); err != nil {
+			return nil, helpers.SystemError("Failed to decode cash transaction", err)
+		}
+		transactions = append(transactions, transaction)
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, helpers.SystemError("Failed to iterate cursor", err)
+	}
+	return transactions, nil
+}
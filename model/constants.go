@@ -6,12 +6,150 @@ const (
 )
 
 const (
-	TRANSACTION_STATUS_PENDING = "pending"
-	TRANSACTION_STATUS_SUCCESS = "success"
-	TRANSACTION_STATUS_FAILED  = "failed"
+	TRANSACTION_STATUS_PENDING    = "pending"
+	TRANSACTION_STATUS_SUCCESS    = "success"
+	TRANSACTION_STATUS_FAILED     = "failed"
+	TRANSACTION_STATUS_CANCELLED  = "cancelled"  // withdrawn by its creator before the other side answered
+	TRANSACTION_STATUS_EXPIRED    = "expired"    // nobody answered before expires_at
+	TRANSACTION_STATUS_SUPERSEDED = "superseded" // answered with a counter-offer
+)
+
+// what created a cash or card transaction, transactions written before this field
+// existed have no type
+const (
+	TRANSACTION_TYPE_TRANSFER = "transfer"
+	TRANSACTION_TYPE_EXCHANGE = "exchange"
+	TRANSACTION_TYPE_TRADE    = "trade" // three or more participants, settles once all approved
 )
 
 var ValidTransactionStatuses = []string{
 	TRANSACTION_STATUS_PENDING,
 	TRANSACTION_STATUS_SUCCESS,
 }
+
+// every status a stored transaction can end up with, ValidTransactionStatuses are
+// the ones a transfer may be created with
+var TransactionStatuses = []string{
+	TRANSACTION_STATUS_PENDING,
+	TRANSACTION_STATUS_SUCCESS,
+	TRANSACTION_STATUS_FAILED,
+	TRANSACTION_STATUS_CANCELLED,
+	TRANSACTION_STATUS_EXPIRED,
+	TRANSACTION_STATUS_SUPERSEDED,
+}
+
+// side of a transaction leg as seen by the user whose history is read
+const (
+	TRANSACTION_DIRECTION_SENT     = "sent"
+	TRANSACTION_DIRECTION_RECEIVED = "received"
+)
+
+const (
+	TRANSACTION_HISTORY_DEFAULT_LIMIT = 20
+	TRANSACTION_HISTORY_MAX_LIMIT     = 100
+)
+
+const (
+	TRANSACTION_EXPORT_FORMAT_CSV   = "csv"
+	TRANSACTION_EXPORT_FORMAT_JSONL = "jsonl"
+)
+
+const (
+	LOAN_STATUS_REQUESTED  = "requested"
+	LOAN_STATUS_ACTIVE     = "active"
+	LOAN_STATUS_DECLINED   = "declined"
+	LOAN_STATUS_PAID       = "paid"
+	LOAN_STATUS_DEFAULTED  = "defaulted"
+	LOAN_STATUS_LIQUIDATED = "liquidated"
+	LOAN_STATUS_FORGIVEN   = "forgiven" // the lender was wiped before it was repaid
+)
+
+// layout used for Loan.LastAccruedOn
+const LOAN_ACCRUAL_DATE_FORMAT = "2006-01-02"
+
+const (
+	CARD_RARITY_COMMON    = "common"
+	CARD_RARITY_UNCOMMON  = "uncommon"
+	CARD_RARITY_RARE      = "rare"
+	CARD_RARITY_EPIC      = "epic"
+	CARD_RARITY_LEGENDARY = "legendary"
+)
+
+// rarity tiers from most to least common, cards created before tiers existed have no rarity
+var CardRarities = []string{
+	CARD_RARITY_COMMON,
+	CARD_RARITY_UNCOMMON,
+	CARD_RARITY_RARE,
+	CARD_RARITY_EPIC,
+	CARD_RARITY_LEGENDARY,
+}
+
+// the most copies a card of each tier can ever have (its total)
+var CardRarityMaxSupply = map[string]uint32{
+	CARD_RARITY_COMMON:    10000,
+	CARD_RARITY_UNCOMMON:  5000,
+	CARD_RARITY_RARE:      1000,
+	CARD_RARITY_EPIC:      250,
+	CARD_RARITY_LEGENDARY: 50,
+}
+
+const (
+	CARD_AUDIT_ACTION_UPDATE = "update"
+	CARD_AUDIT_ACTION_RETIRE = "retire"
+)
+
+// orders of the card catalog
+const (
+	CARD_SORT_NAME     = "name"
+	CARD_SORT_SCARCITY = "scarcity" // fewest copies left first
+	CARD_SORT_NEWEST   = "newest"
+)
+
+const (
+	CARD_CATALOG_DEFAULT_LIMIT = 20
+	CARD_CATALOG_MAX_LIMIT     = 100
+)
+
+const (
+	CARD_TOP_HOLDERS_DEFAULT_LIMIT = 10
+	CARD_TOP_HOLDERS_MAX_LIMIT     = 100
+)
+
+// where card images are stored, picked with IMAGE_STORAGE
+const (
+	IMAGE_STORAGE_CLOUDINARY = "cloudinary"
+	IMAGE_STORAGE_LOCAL      = "local"
+)
+
+// route the local image storage is served under
+const LOCAL_IMAGE_ROUTE = "/images"
+
+// layout of the month a statement covers
+const STATEMENT_MONTH_FORMAT = "2006-01"
+
+const (
+	LOAN_ROLE_LENDER   = "lender"
+	LOAN_ROLE_BORROWER = "borrower"
+)
+
+// ledger account of the game itself, cash and cards minted or taxed flow through it
+const LEDGER_ACCOUNT_SYSTEM = 0
+
+const LEDGER_ASSET_CASH = "cash"
+
+const (
+	LEDGER_KIND_TRANSFER        = "transfer"
+	LEDGER_KIND_LOAN            = "loan"
+	LEDGER_KIND_EXCHANGE        = "exchange"
+	LEDGER_KIND_TRADE           = "trade"
+	LEDGER_KIND_MINT            = "mint"
+	LEDGER_KIND_SURVIVAL_TAX    = "survival_tax"
+	LEDGER_KIND_DEACTIVATION    = "deactivation"
+	LEDGER_KIND_OPENING_BALANCE = "opening_balance"
+)
+
+const (
+	IDEMPOTENCY_STATUS_IN_PROGRESS = "in_progress"
+	IDEMPOTENCY_STATUS_COMMITTED   = "committed" // writes are committed, the response is not stored yet
+	IDEMPOTENCY_STATUS_COMPLETED   = "completed"
+)
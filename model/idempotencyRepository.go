@@ -0,0 +1,132 @@
+package model
+
+import (
+	"context"
+	"time"
+
+	"github.com/ChronoPlay/chronoplay-backend-service/helpers"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// IdempotencyRecord remembers the response of a mutating request per user and
+// Idempotency-Key so a retried request can be answered without running it again
+type IdempotencyRecord struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserId       uint32             `bson:"user_id" json:"user_id"`
+	Key          string             `bson:"key" json:"key"`
+	RequestHash  string             `bson:"request_hash" json:"request_hash"`
+	Status       string             `bson:"status" json:"status"`
+	ResponseCode int                `bson:"response_code,omitempty" json:"response_code,omitempty"`
+	ResponseBody []byte             `bson:"response_body,omitempty" json:"response_body,omitempty"`
+	CreatedAt    time.Time          `bson:"created_at" json:"created_at"`
+	ExpiresAt    time.Time          `bson:"expires_at" json:"expires_at"`
+}
+
+type IdempotencyRepository interface {
+	GetCollection() *mongo.Collection
+	EnsureIndexes(ctx context.Context) *helpers.CustomError
+	ReserveRecord(ctx context.Context, record IdempotencyRecord) (bool, *helpers.CustomError)
+	GetRecord(ctx context.Context, userId uint32, key string) (*IdempotencyRecord, *helpers.CustomError)
+	CommitRecord(ctx context.Context, userId uint32, key string) (bool, *helpers.CustomError)
+	CompleteRecord(ctx context.Context, userId uint32, key string, responseCode int, responseBody []byte) *helpers.CustomError
+	DeleteRecord(ctx context.Context, id primitive.ObjectID) *helpers.CustomError
+	DeleteInProgressRecord(ctx context.Context, userId uint32, key string) *helpers.CustomError
+}
+
+type mongoIdempotencyRepo struct {
+	collection *mongo.Collection
+}
+
+func NewIdempotencyRepository(col *mongo.Collection) IdempotencyRepository {
+	return &mongoIdempotencyRepo{collection: col}
+}
+
+func (repo *mongoIdempotencyRepo) GetCollection() *mongo.Collection {
+	return repo.collection
+}
+
+// one record per user and key, mongo removes records once expires_at has passed
+func (repo *mongoIdempotencyRepo) EnsureIndexes(ctx context.Context) *helpers.CustomError {
+	_, err := repo.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "key", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	})
+	if err != nil {
+		return helpers.SystemError("Failed to create idempotency indexes", err)
+	}
+	return nil
+}
+
+// inserts the record unless the user already used the key, reports whether it was inserted
+func (repo *mongoIdempotencyRepo) ReserveRecord(ctx context.Context, record IdempotencyRecord) (bool, *helpers.CustomError) {
+	_, err := repo.collection.InsertOne(ctx, record)
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, helpers.SystemError("Failed to reserve idempotency key", err)
+	}
+	return true, nil
+}
+
+func (repo *mongoIdempotencyRepo) GetRecord(ctx context.Context, userId uint32, key string) (*IdempotencyRecord, *helpers.CustomError) {
+	var record IdempotencyRecord
+	err := repo.collection.FindOne(ctx, bson.M{"user_id": userId, "key": key}).Decode(&record)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, helpers.SystemError("Failed to get idempotency record", err)
+	}
+	return &record, nil
+}
+
+// marks a claimed key as committed, reports false when it is no longer held by a running request
+func (repo *mongoIdempotencyRepo) CommitRecord(ctx context.Context, userId uint32, key string) (bool, *helpers.CustomError) {
+	result, err := repo.collection.UpdateOne(ctx, bson.M{"user_id": userId, "key": key, "status": IDEMPOTENCY_STATUS_IN_PROGRESS}, bson.M{
+		"$set": bson.M{"status": IDEMPOTENCY_STATUS_COMMITTED},
+	})
+	if err != nil {
+		return false, helpers.SystemError("Failed to commit idempotency record", err)
+	}
+	return result.MatchedCount == 1, nil
+}
+
+func (repo *mongoIdempotencyRepo) CompleteRecord(ctx context.Context, userId uint32, key string, responseCode int, responseBody []byte) *helpers.CustomError {
+	_, err := repo.collection.UpdateOne(ctx, bson.M{"user_id": userId, "key": key}, bson.M{
+		"$set": bson.M{
+			"status":        IDEMPOTENCY_STATUS_COMPLETED,
+			"response_code": responseCode,
+			"response_body": responseBody,
+		},
+	})
+	if err != nil {
+		return helpers.SystemError("Failed to complete idempotency record", err)
+	}
+	return nil
+}
+
+func (repo *mongoIdempotencyRepo) DeleteRecord(ctx context.Context, id primitive.ObjectID) *helpers.CustomError {
+	_, err := repo.collection.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return helpers.SystemError("Failed to delete idempotency record", err)
+	}
+	return nil
+}
+
+func (repo *mongoIdempotencyRepo) DeleteInProgressRecord(ctx context.Context, userId uint32, key string) *helpers.CustomError {
+	_, err := repo.collection.DeleteOne(ctx, bson.M{"user_id": userId, "key": key, "status": IDEMPOTENCY_STATUS_IN_PROGRESS})
+	if err != nil {
+		return helpers.SystemError("Failed to delete idempotency record", err)
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ChronoPlay/chronoplay-backend-service/helpers"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// LedgerPosting is one side of a movement of cash or cards. Every movement is
+// written as a debit on the account the asset leaves and a credit on the account
+// it enters, so for each asset the debits and credits of a batch always balance.
+// An account's balance of an asset is sum(credit) - sum(debit).
+type LedgerPosting struct {
+	ID              primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	PostingId       uint32             `bson:"posting_id" json:"posting_id"`
+	TransactionGuid uint32             `bson:"transaction_guid,omitempty" json:"transaction_guid,omitempty"`
+	Kind            string             `bson:"kind" json:"kind"`
+	Account         uint32             `bson:"account" json:"account"` // user id, LEDGER_ACCOUNT_SYSTEM for the game itself
+	Asset           string             `bson:"asset" json:"asset"`     // LEDGER_ASSET_CASH or a card number
+	Debit           int64              `bson:"debit" json:"debit"`     // minor units for cash, quantity for cards
+	Credit          int64              `bson:"credit" json:"credit"`
+	CreatedAt       primitive.DateTime `bson:"created_at" json:"created_at"`
+}
+
+// LedgerMovement is a single asset moving from one account to another
+type LedgerMovement struct {
+	Kind            string
+	TransactionGuid uint32
+	From            uint32
+	To              uint32
+	Asset           string
+	Amount          int64
+}
+
+// Postings returns the balanced debit and credit pair for the movement
+func (movement LedgerMovement) Postings() []LedgerPosting {
+	return []LedgerPosting{
+		{
+			TransactionGuid: movement.TransactionGuid,
+			Kind:            movement.Kind,
+			Account:         movement.From,
+			Asset:           movement.Asset,
+			Debit:           movement.Amount,
+		},
+		{
+			TransactionGuid: movement.TransactionGuid,
+			Kind:            movement.Kind,
+			Account:         movement.To,
+			Asset:           movement.Asset,
+			Credit:          movement.Amount,
+		},
+	}
+}
+
+type LedgerRepository interface {
+	GetCollection() *mongo.Collection
+	AddPostings(ctx context.Context, postings []LedgerPosting) *helpers.CustomError
+	GetBalances(ctx context.Context, account uint32) (map[string]int64, *helpers.CustomError)
+	GetBalancesBefore(ctx context.Context, account uint32, before time.Time) (map[string]int64, *helpers.CustomError)
+	GetPostings(ctx context.Context, account uint32, from time.Time, to time.Time) ([]LedgerPosting, *helpers.CustomError)
+	EnsureIndexes(ctx context.Context) *helpers.CustomError
+	HasPostings(ctx context.Context, account uint32) (bool, *helpers.CustomError)
+}
+
+type mongoLedgerRepo struct {
+	collection *mongo.Collection
+}
+
+func NewLedgerRepository(col *mongo.Collection) LedgerRepository {
+	return &mongoLedgerRepo{collection: col}
+}
+
+func (repo *mongoLedgerRepo) GetCollection() *mongo.Collection {
+	return repo.collection
+}
+
+func (repo *mongoLedgerRepo) AddPostings(ctx context.Context, postings []LedgerPosting) *helpers.CustomError {
+	if len(postings) == 0 {
+		return nil
+	}
+	balance := make(map[string]int64)
+	for _, posting := range postings {
+		balance[posting.Asset] += posting.Credit - posting.Debit
+	}
+	for asset, difference := range balance {
+		if difference != 0 {
+			return helpers.System(fmt.Sprintf("unbalanced ledger postings for %s: %d", asset, difference))
+		}
+	}
+
+	docs := make([]interface{}, len(postings))
+	for i := range postings {
+		nextId, err := GetNextSequence(ctx, repo.collection.Database(), "ledgerPostings")
+		if err != nil {
+			return helpers.SystemError("Failed to generate posting ID", err)
+		}
+		postings[i].PostingId = uint32(nextId)
+		postings[i].CreatedAt = primitive.NewDateTimeFromTime(time.Now())
+		docs[i] = postings[i]
+	}
+	_, err := repo.collection.InsertMany(ctx, docs)
+	if err != nil {
+		return helpers.SystemError("Failed to add ledger postings", err)
+	}
+	return nil
+}
+
+func (repo *mongoLedgerRepo) EnsureIndexes(ctx context.Context) *helpers.CustomError {
+	_, err := repo.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "account", Value: 1}, {Key: "created_at", Value: 1}}},
+	})
+	if err != nil {
+		return helpers.SystemError("Failed to create ledger indexes", err)
+	}
+	return nil
+}
+
+func (repo *mongoLedgerRepo) GetBalances(ctx context.Context, account uint32) (map[string]int64, *helpers.CustomError) {
+	return repo.aggregateBalances(ctx, bson.M{"account": account})
+}
+
+// balances of the account from the postings written before the given time
+func (repo *mongoLedgerRepo) GetBalancesBefore(ctx context.Context, account uint32, before time.Time) (map[string]int64, *helpers.CustomError) {
+	return repo.aggregateBalances(ctx, bson.M{
+		"account":    account,
+		"created_at": bson.M{"$lt": primitive.NewDateTimeFromTime(before)},
+	})
+}
+
+func (repo *mongoLedgerRepo) aggregateBalances(ctx context.Context, match bson.M) (map[string]int64, *helpers.CustomError) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$group", Value: bson.M{
+			"_id":     "$asset",
+			"balance": bson.M{"$sum": bson.M{"$subtract": bson.A{"$credit", "$debit"}}},
+		}}},
+	}
+	cursor, err := repo.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, helpers.SystemError("Failed to aggregate ledger balances", err)
+	}
+	defer cursor.Close(ctx)
+
+	balances := make(map[string]int64)
+	for cursor.Next(ctx) {
+		var result struct {
+			Asset   string `bson:"_id"`
+			Balance int64  `bson:"balance"`
+		}
+		if err := cursor.Decode(&result); err != nil {
+			return nil, helpers.SystemError("Failed to decode ledger balance", err)
+		}
+		balances[result.Asset] = result.Balance
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, helpers.SystemError("Failed to iterate cursor", err)
+	}
+	return balances, nil
+}
+
+func (repo *mongoLedgerRepo) HasPostings(ctx context.Context, account uint32) (bool, *helpers.CustomError) {
+	count, err := repo.collection.CountDocuments(ctx, bson.M{"account": account})
+	if err != nil {
+		return false, helpers.SystemError("Failed to count ledger postings", err)
+	}
+	return count > 0, nil
+}
+
+// postings of the account written in [from, to), in the order they were written
+func (repo *mongoLedgerRepo) GetPostings(ctx context.Context, account uint32, from time.Time, to time.Time) ([]LedgerPosting, *helpers.CustomError) {
+	postings := []LedgerPosting{}
+	cursor, err := repo.collection.Find(ctx, bson.M{
+		"account": account,
+		"created_at": bson.M{
+			"$gte": primitive.NewDateTimeFromTime(from),
+			"$lt":  primitive.NewDateTimeFromTime(to),
+		},
+	}, options.Find().SetSort(bson.D{{Key: "posting_id", Value: 1}}))
+	if err != nil {
+		return nil, helpers.SystemError("Failed to get ledger postings", err)
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		var posting LedgerPosting
+		if err := cursor.Decode(&posting); err != nil {
+			return nil, helpers.SystemError("Failed to decode ledger posting", err)
+		}
+		postings = append(postings, posting)
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, helpers.SystemError("Failed to iterate cursor", err)
+	}
+	return postings, nil
+}
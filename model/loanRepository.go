@@ -2,6 +2,7 @@ package model
 
 import (
 	"context"
+	"time"
 
 	"github.com/ChronoPlay/chronoplay-backend-service/helpers"
 	"go.mongodb.org/mongo-driver/bson"
@@ -12,13 +13,37 @@ import (
 type Loan struct {
 	ID                  primitive.ObjectID `bson:"_id,omitempty" json:"id"`
 	LoanId              uint32             `bson:"loan_id" json:"loan_id"`
-	Amount              float32            `bson:"amount" json:"amount"`
+	Amount              Money              `bson:"amount" json:"amount"`
 	LoanedBy            uint32             `bson:"loaned_by" json:"loaned_by"`
 	LoanedTo            uint32             `bson:"loaned_to" json:"loaned_to"`
 	Rate                float32            `bson:"rate" json:"rate"` // compound interest applied after each day
-	InterestAccumulated float32            `bson:"interest_accumulated" json:"interest_accumulated"`
-	PaidAmount          float32            `bson:"paid_amount" json:"paid_amount"`
+	InterestAccumulated Money              `bson:"interest_accumulated" json:"interest_accumulated"`
+	PaidAmount          Money              `bson:"paid_amount" json:"paid_amount"`
 	IsPaid              bool               `bson:"is_paid" json:"is_paid"`
+	Status              string             `bson:"status" json:"status"`
+	CreatedAt           primitive.DateTime `bson:"created_at" json:"created_at"`
+	UpdatedAt           primitive.DateTime `bson:"updated_at,omitempty" json:"updated_at,omitempty"`
+	LastAccruedOn       string             `bson:"last_accrued_on,omitempty" json:"last_accrued_on,omitempty"` // calendar day (UTC) interest was last applied for
+	Recovery            *LoanRecovery      `bson:"recovery,omitempty" json:"recovery,omitempty"`
+	Collateral          []CardOccupied     `bson:"collateral,omitempty" json:"collateral,omitempty"`
+	DurationDays        uint32             `bson:"duration_days,omitempty" json:"duration_days,omitempty"`
+	DueDate             primitive.DateTime `bson:"due_date,omitempty" json:"due_date,omitempty"` // set on acceptance when DurationDays is given
+}
+
+// what was seized from the borrower when the loan defaulted
+type LoanRecovery struct {
+	Cash        Money              `bson:"cash" json:"cash"`
+	Cards       []CardOccupied     `bson:"cards" json:"cards"`
+	DefaultedAt primitive.DateTime `bson:"defaulted_at" json:"defaulted_at"`
+}
+
+// amount the borrower still has to pay back including interest
+func (loan Loan) Outstanding() Money {
+	outstanding := loan.Amount + loan.InterestAccumulated - loan.PaidAmount
+	if outstanding < 0 {
+		return 0
+	}
+	return outstanding
 }
 
 type LoanRepository interface {
@@ -26,6 +51,9 @@ type LoanRepository interface {
 	GetLoansByUserId(ctx context.Context, userId uint32) ([]Loan, *helpers.CustomError)
 	GetLoanByLoanId(ctx context.Context, loanId uint32) (*Loan, *helpers.CustomError)
 	UpdateLoan(ctx context.Context, loan Loan) *helpers.CustomError
+	GetLoansByStatus(ctx context.Context, status string) ([]Loan, *helpers.CustomError)
+	GetOverdueCollateralizedLoans(ctx context.Context, now time.Time) ([]Loan, *helpers.CustomError)
+	AccrueInterest(ctx context.Context, loanId uint32, lastAccruedOn string, accruedOn string, interest Money) (bool, *helpers.CustomError)
 }
 
 type mongoLoanRepo struct {
@@ -39,12 +67,13 @@ func NewLoanRepository(col *mongo.Collection) LoanRepository {
 func (repo *mongoLoanRepo) AddLoan(ctx context.Context, loan Loan) (uint32, *helpers.CustomError) {
 	nextId, err := GetNextSequence(ctx, repo.collection.Database(), "loans")
 	if err != nil {
-		return 0, helpers.System("Failed to generate loan ID: " + err.Error())
+		return 0, helpers.SystemError("Failed to generate loan ID", err)
 	}
 	loan.LoanId = uint32(nextId)
+	loan.CreatedAt = primitive.NewDateTimeFromTime(time.Now())
 	_, err = repo.collection.InsertOne(ctx, loan)
 	if err != nil {
-		return 0, helpers.System("Failed to add loan: " + err.Error())
+		return 0, helpers.SystemError("Failed to add loan", err)
 	}
 	return loan.LoanId, nil
 }
@@ -56,18 +85,18 @@ func (repo *mongoLoanRepo) GetLoansByUserId(ctx context.Context, userId uint32)
 		{"loaned_to": userId},
 	}})
 	if err != nil {
-		return nil, helpers.System("Failed to get loans by user ID: " + err.Error())
+		return nil, helpers.SystemError("Failed to get loans by user ID", err)
 	}
 	defer cursor.Close(ctx)
 	for cursor.Next(ctx) {
 		var loan Loan
 		if err := cursor.Decode(&loan); err != nil {
-			return nil, helpers.System("Failed to decode loan: " + err.Error())
+			return nil, helpers.SystemError("Failed to decode loan", err)
 		}
 		loans = append(loans, loan)
 	}
 	if err := cursor.Err(); err != nil {
-		return nil, helpers.System("Failed to iterate cursor: " + err.Error())
+		return nil, helpers.SystemError("Failed to iterate cursor", err)
 	}
 	return loans, nil
 }
@@ -79,18 +108,91 @@ func (repo *mongoLoanRepo) GetLoanByLoanId(ctx context.Context, loanId uint32) (
 		if err == mongo.ErrNoDocuments {
 			return nil, helpers.NotFound("loan not found")
 		}
-		return nil, helpers.System("Failed to find loan by ID: " + err.Error())
+		return nil, helpers.SystemError("Failed to find loan by ID", err)
 	}
 	return &loan, nil
 }
 
 func (repo *mongoLoanRepo) UpdateLoan(ctx context.Context, loan Loan) *helpers.CustomError {
+	loan.UpdatedAt = primitive.NewDateTimeFromTime(time.Now())
 	result, err := repo.collection.UpdateOne(ctx, bson.M{"loan_id": loan.LoanId}, bson.M{"$set": loan})
 	if err != nil {
-		return helpers.System("Failed to update loan: " + err.Error())
+		return helpers.SystemError("Failed to update loan", err)
 	}
 	if result.MatchedCount == 0 {
 		return helpers.NotFound("loan not found")
 	}
 	return nil
 }
+
+func (repo *mongoLoanRepo) GetLoansByStatus(ctx context.Context, status string) ([]Loan, *helpers.CustomError) {
+	var loans []Loan
+	cursor, err := repo.collection.Find(ctx, bson.M{"status": status, "is_paid": false})
+	if err != nil {
+		return nil, helpers.SystemError("Failed to get loans by status", err)
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		var loan Loan
+		if err := cursor.Decode(&loan); err != nil {
+			return nil, helpers.SystemError("Failed to decode loan", err)
+		}
+		loans = append(loans, loan)
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, helpers.SystemError("Failed to iterate cursor", err)
+	}
+	return loans, nil
+}
+
+func (repo *mongoLoanRepo) GetOverdueCollateralizedLoans(ctx context.Context, now time.Time) ([]Loan, *helpers.CustomError) {
+	var loans []Loan
+	cursor, err := repo.collection.Find(ctx, bson.M{
+		"status":       LOAN_STATUS_ACTIVE,
+		"is_paid":      false,
+		"due_date":     bson.M{"$lt": primitive.NewDateTimeFromTime(now)},
+		"collateral.0": bson.M{"$exists": true},
+	})
+	if err != nil {
+		return nil, helpers.SystemError("Failed to get overdue loans", err)
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		var loan Loan
+		if err := cursor.Decode(&loan); err != nil {
+			return nil, helpers.SystemError("Failed to decode loan", err)
+		}
+		loans = append(loans, loan)
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, helpers.SystemError("Failed to iterate cursor", err)
+	}
+	return loans, nil
+}
+
+// applies interest only if nobody else accrued this loan since lastAccruedOn was read,
+// so running the job twice on the same day never charges twice
+func (repo *mongoLoanRepo) AccrueInterest(ctx context.Context, loanId uint32, lastAccruedOn string, accruedOn string, interest Money) (bool, *helpers.CustomError) {
+	filter := bson.M{
+		"loan_id": loanId,
+		"status":  LOAN_STATUS_ACTIVE,
+		"is_paid": false,
+	}
+	if lastAccruedOn == "" {
+		filter["last_accrued_on"] = bson.M{"$exists": false}
+	} else {
+		filter["last_accrued_on"] = lastAccruedOn
+	}
+	update := bson.M{
+		"$inc": bson.M{"interest_accumulated": interest},
+		"$set": bson.M{
+			"last_accrued_on": accruedOn,
+			"updated_at":      primitive.NewDateTimeFromTime(time.Now()),
+		},
+	}
+	result, err := repo.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, helpers.SystemError("Failed to accrue interest", err)
+	}
+	return result.ModifiedCount == 1, nil
+}
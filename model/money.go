@@ -0,0 +1,122 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Money is a cash amount kept as integer minor units (cents) so transfers and
+// interest never drift. It is stored in mongo as an int64 and travels over json
+// as a decimal number with two places, e.g. 12.50
+type Money int64
+
+const MONEY_MINOR_UNITS = 100
+
+const moneyDecimals = 2
+
+var ErrInvalidMoney = errors.New("invalid money amount")
+
+func NewMoney(units int64) Money {
+	return Money(units * MONEY_MINOR_UNITS)
+}
+
+// rounds half away from zero to the nearest minor unit
+func NewMoneyFromFloat(value float64) Money {
+	return Money(math.Round(value * MONEY_MINOR_UNITS))
+}
+
+// parses a decimal string exactly, more than two decimal places is an error
+func ParseMoney(value string) (Money, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, ErrInvalidMoney
+	}
+	negative := false
+	if value[0] == '-' || value[0] == '+' {
+		negative = value[0] == '-'
+		value = value[1:]
+	}
+	whole, fraction, hasFraction := strings.Cut(value, ".")
+	if whole == "" && (!hasFraction || fraction == "") {
+		return 0, ErrInvalidMoney
+	}
+	if !isDigits(whole) || !isDigits(fraction) {
+		return 0, ErrInvalidMoney
+	}
+	if len(fraction) > moneyDecimals {
+		return 0, fmt.Errorf("%w: at most %d decimal places are allowed", ErrInvalidMoney, moneyDecimals)
+	}
+	fraction += strings.Repeat("0", moneyDecimals-len(fraction))
+	if whole == "" {
+		whole = "0"
+	}
+	minor, err := strconv.ParseInt(whole+fraction, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s", ErrInvalidMoney, err.Error())
+	}
+	if negative {
+		minor = -minor
+	}
+	return Money(minor), nil
+}
+
+// the sign is handled by ParseMoney, so "--5" or "1.-5" must not reach ParseInt
+func isDigits(value string) bool {
+	for _, c := range value {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func (m Money) Float64() float64 {
+	return float64(m) / MONEY_MINOR_UNITS
+}
+
+func (m Money) String() string {
+	sign := ""
+	minor := int64(m)
+	if minor < 0 {
+		sign = "-"
+		minor = -minor
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, minor/MONEY_MINOR_UNITS, minor%MONEY_MINOR_UNITS)
+}
+
+// applies a rate (e.g. 0.05 for 5%) and rounds to the nearest minor unit
+func (m Money) MulRate(rate float64) Money {
+	return NewMoneyFromFloat(float64(m) * rate / MONEY_MINOR_UNITS)
+}
+
+// m * numerator / denominator rounded down, without overflowing on large balances
+func (m Money) MulDiv(numerator Money, denominator Money) Money {
+	if denominator == 0 {
+		return 0
+	}
+	result := new(big.Int).Mul(big.NewInt(int64(m)), big.NewInt(int64(numerator)))
+	result.Quo(result, big.NewInt(int64(denominator)))
+	return Money(result.Int64())
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// accepts both json numbers and strings, parsed from their text so no float rounding sneaks in
+func (m *Money) UnmarshalJSON(data []byte) error {
+	value := strings.Trim(string(data), `"`)
+	if value == "null" {
+		return nil
+	}
+	parsed, err := ParseMoney(value)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
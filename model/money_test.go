@@ -0,0 +1,179 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestParseMoney(t *testing.T) {
+	cases := []struct {
+		value string
+		want  Money
+		valid bool
+	}{
+		{value: "0", want: 0, valid: true},
+		{value: "12", want: 1200, valid: true},
+		{value: "12.5", want: 1250, valid: true},
+		{value: "12.50", want: 1250, valid: true},
+		{value: "0.01", want: 1, valid: true},
+		{value: ".5", want: 50, valid: true},
+		{value: "5.", want: 500, valid: true},
+		{value: " 7.25 ", want: 725, valid: true},
+		{value: "+3.10", want: 310, valid: true},
+		{value: "-3.10", want: -310, valid: true},
+		{value: "-0.05", want: -5, valid: true},
+		{value: "92233720368547758.07", want: 9223372036854775807, valid: true},
+		{value: "92233720368547758.08"},
+		{value: "-92233720368547758.09"},
+		{value: "1.001"},
+		{value: "0.999"},
+		{value: ""},
+		{value: "-"},
+		{value: "."},
+		{value: "-."},
+		{value: "--5"},
+		{value: "+-5"},
+		{value: "1.-5"},
+		{value: "1.2.3"},
+		{value: "1e3"},
+		{value: "abc"},
+	}
+	for _, c := range cases {
+		got, err := ParseMoney(c.value)
+		if !c.valid {
+			if err == nil {
+				t.Errorf("ParseMoney(%q) = %v, want an error", c.value, got)
+			} else if !errors.Is(err, ErrInvalidMoney) {
+				t.Errorf("ParseMoney(%q) error %v does not wrap ErrInvalidMoney", c.value, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseMoney(%q): %v", c.value, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("ParseMoney(%q) = %d, want %d", c.value, got, c.want)
+		}
+	}
+}
+
+func TestMoneyString(t *testing.T) {
+	cases := []struct {
+		money Money
+		want  string
+	}{
+		{money: 0, want: "0.00"},
+		{money: 1, want: "0.01"},
+		{money: 1250, want: "12.50"},
+		{money: -5, want: "-0.05"},
+		{money: -1250, want: "-12.50"},
+		{money: NewMoney(50), want: "50.00"},
+	}
+	for _, c := range cases {
+		if got := c.money.String(); got != c.want {
+			t.Errorf("Money(%d).String() = %q, want %q", c.money, got, c.want)
+		}
+	}
+}
+
+func TestMulRate(t *testing.T) {
+	cases := []struct {
+		money Money
+		rate  float64
+		want  Money
+	}{
+		{money: 10000, rate: 0.05, want: 500},
+		{money: 10000, rate: 0, want: 0},
+		{money: 1, rate: 0.5, want: 1},   // half a cent rounds up
+		{money: 1, rate: 0.49, want: 0},  // under half a cent rounds down
+		{money: -1, rate: 0.5, want: -1}, // half away from zero
+		{money: 333, rate: 0.1, want: 33},
+		{money: 335, rate: 0.1, want: 34},
+		{money: 12345, rate: 0.015, want: 185},
+		{money: 10000, rate: 1.05, want: 10500},
+	}
+	for _, c := range cases {
+		if got := c.money.MulRate(c.rate); got != c.want {
+			t.Errorf("Money(%d).MulRate(%v) = %d, want %d", c.money, c.rate, got, c.want)
+		}
+	}
+}
+
+func TestMulDiv(t *testing.T) {
+	cases := []struct {
+		money       Money
+		numerator   Money
+		denominator Money
+		want        Money
+	}{
+		{money: 1000, numerator: 1, denominator: 3, want: 333},
+		{money: 1000, numerator: 2, denominator: 3, want: 666},
+		{money: 1000, numerator: 3, denominator: 3, want: 1000},
+		{money: 1000, numerator: 0, denominator: 3, want: 0},
+		{money: 1000, numerator: 1, denominator: 0, want: 0},
+		// the product overflows int64 but the result does not
+		{money: 9000000000000000000, numerator: 4000000000000000000, denominator: 8000000000000000000, want: 4500000000000000000},
+	}
+	for _, c := range cases {
+		if got := c.money.MulDiv(c.numerator, c.denominator); got != c.want {
+			t.Errorf("Money(%d).MulDiv(%d, %d) = %d, want %d", c.money, c.numerator, c.denominator, got, c.want)
+		}
+	}
+}
+
+func TestMoneyJSON(t *testing.T) {
+	type payload struct {
+		Amount Money `json:"amount"`
+	}
+	for _, money := range []Money{0, 1, 1250, -5, 9223372036854775807} {
+		data, err := json.Marshal(payload{Amount: money})
+		if err != nil {
+			t.Fatalf("marshal %d: %v", money, err)
+		}
+		var decoded payload
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			t.Fatalf("unmarshal %s: %v", data, err)
+		}
+		if decoded.Amount != money {
+			t.Errorf("round trip of %d through %s gave %d", money, data, decoded.Amount)
+		}
+	}
+
+	cases := []struct {
+		data  string
+		want  Money
+		valid bool
+	}{
+		{data: `{"amount":12.5}`, want: 1250, valid: true},
+		{data: `{"amount":"12.5"}`, want: 1250, valid: true},
+		{data: `{"amount":0.1}`, want: 10, valid: true},
+		{data: `{"amount":null}`, want: 0, valid: true},
+		{data: `{}`, want: 0, valid: true},
+		{data: `{"amount":12.505}`},
+		{data: `{"amount":"abc"}`},
+	}
+	for _, c := range cases {
+		var decoded payload
+		err := json.Unmarshal([]byte(c.data), &decoded)
+		if !c.valid {
+			if err == nil {
+				t.Errorf("unmarshal %s = %d, want an error", c.data, decoded.Amount)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("unmarshal %s: %v", c.data, err)
+			continue
+		}
+		if decoded.Amount != c.want {
+			t.Errorf("unmarshal %s = %d, want %d", c.data, decoded.Amount, c.want)
+		}
+	}
+
+	data, _ := json.Marshal(payload{Amount: 1250})
+	if string(data) != `{"amount":12.50}` {
+		t.Errorf("marshal 1250 = %s, want {\"amount\":12.50}", data)
+	}
+}
@@ -54,7 +54,7 @@ func (repo *mongoNotificationRepo) AddNotifications(ctx context.Context, notific
 	for i := range notifications {
 		nextId, err := GetNextSequence(ctx, repo.collection.Database(), "notificationIds")
 		if err != nil {
-			return helpers.System("Failed to generate notification ID: " + err.Error())
+			return helpers.SystemError("Failed to generate notification ID", err)
 		}
 		notifications[i].NotificationId = uint32(nextId)
 	}
@@ -72,7 +72,7 @@ func (repo *mongoNotificationRepo) AddNotifications(ctx context.Context, notific
 
 	_, err := repo.collection.InsertMany(ctx, docs)
 	if err != nil {
-		return helpers.System("Failed to add notification: " + err.Error())
+		return helpers.SystemError("Failed to add notification", err)
 	}
 	return nil
 }
@@ -81,10 +81,10 @@ func (repo *mongoNotificationRepo) GetNotificationsByUserId(ctx context.Context,
 	var notifications []Notification
 	cursor, err := repo.collection.Find(ctx, bson.M{"user_id": userId})
 	if err != nil {
-		return nil, helpers.System("Failed to fetch notifications: " + err.Error())
+		return nil, helpers.SystemError("Failed to fetch notifications", err)
 	}
 	if err = cursor.All(ctx, &notifications); err != nil {
-		return nil, helpers.System("Failed to decode notifications: " + err.Error())
+		return nil, helpers.SystemError("Failed to decode notifications", err)
 	}
 	// sort decending by CreatedAt
 	sort.Slice(notifications, func(i, j int) bool {
@@ -105,7 +105,7 @@ func (repo *mongoNotificationRepo) MarkNotificationsAsRead(ctx context.Context,
 	}
 	_, err := repo.collection.UpdateMany(ctx, filter, update)
 	if err != nil {
-		return helpers.System("Failed to mark notifications as read: " + err.Error())
+		return helpers.SystemError("Failed to mark notifications as read", err)
 	}
 	return nil
 }
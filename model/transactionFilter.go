@@ -0,0 +1,119 @@
+package model
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// TransactionFilter selects the transaction legs a user gives or receives. It is
+// shared by the card and cash transaction repositories so both collections page
+// over the same transaction guids.
+type TransactionFilter struct {
+	UserId           uint32
+	Counterparty     uint32 // other side of the leg, 0 for anyone
+	Direction        string // TRANSACTION_DIRECTION_*, empty for both
+	Status           string
+	CardNumber       string // card transactions only
+	ChainGuid        uint32
+	TransactionGuids []uint32
+	From             time.Time
+	To               time.Time
+	Cursor           uint32 // guid of the last transaction of the previous page
+	Ascending        bool   // oldest guid first, newest first otherwise
+	Limit            int
+}
+
+func (filter TransactionFilter) query() bson.M {
+	sent := bson.M{"given_by": filter.UserId}
+	received := bson.M{"given_to": filter.UserId}
+	if filter.Counterparty != 0 {
+		sent["given_to"] = filter.Counterparty
+		received["given_by"] = filter.Counterparty
+	}
+	var conditions []bson.M
+	switch filter.Direction {
+	case TRANSACTION_DIRECTION_SENT:
+		conditions = append(conditions, sent)
+	case TRANSACTION_DIRECTION_RECEIVED:
+		conditions = append(conditions, received)
+	default:
+		conditions = append(conditions, bson.M{"$or": bson.A{sent, received}})
+	}
+	if filter.Status != "" {
+		conditions = append(conditions, bson.M{"status": filter.Status})
+	}
+	if filter.CardNumber != "" {
+		conditions = append(conditions, bson.M{"card_number": filter.CardNumber})
+	}
+	if filter.ChainGuid != 0 {
+		// the first proposal of a chain has no chain_guid of its own
+		conditions = append(conditions, bson.M{"$or": bson.A{
+			bson.M{"chain_guid": filter.ChainGuid},
+			bson.M{"transaction_guid": filter.ChainGuid},
+		}})
+	}
+	if len(filter.TransactionGuids) > 0 {
+		conditions = append(conditions, bson.M{"transaction_guid": bson.M{"$in": filter.TransactionGuids}})
+	}
+	createdAt := bson.M{}
+	if !filter.From.IsZero() {
+		createdAt["$gte"] = primitive.NewDateTimeFromTime(filter.From)
+	}
+	if !filter.To.IsZero() {
+		createdAt["$lt"] = primitive.NewDateTimeFromTime(filter.To)
+	}
+	if len(createdAt) > 0 {
+		conditions = append(conditions, bson.M{"created_at": createdAt})
+	}
+	if filter.Cursor != 0 {
+		operator := "$lt"
+		if filter.Ascending {
+			operator = "$gt"
+		}
+		conditions = append(conditions, bson.M{"transaction_guid": bson.M{operator: filter.Cursor}})
+	}
+	return bson.M{"$and": conditions}
+}
+
+func (filter TransactionFilter) findOptions() *options.FindOptions {
+	order := -1
+	if filter.Ascending {
+		order = 1
+	}
+	return options.Find().
+		SetSort(bson.D{{Key: "transaction_guid", Value: order}}).
+		SetProjection(bson.M{"transaction_guid": 1})
+}
+
+// legs are read in guid order until limit distinct guids were seen, so only the
+// legs of one page are loaded however long the history is
+func collectTransactionGuids(ctx context.Context, cursor *mongo.Cursor, limit int) ([]uint32, error) {
+	defer cursor.Close(ctx)
+	guids := []uint32{}
+	for len(guids) < limit && cursor.Next(ctx) {
+		var leg struct {
+			TransactionGuid uint32 `bson:"transaction_guid"`
+		}
+		if err := cursor.Decode(&leg); err != nil {
+			return nil, err
+		}
+		if len(guids) == 0 || guids[len(guids)-1] != leg.TransactionGuid {
+			guids = append(guids, leg.TransactionGuid)
+		}
+	}
+	return guids, cursor.Err()
+}
+
+// indexes behind the history queries, both transaction collections share them
+var transactionIndexes = []mongo.IndexModel{
+	{Keys: bson.D{{Key: "given_by", Value: 1}, {Key: "transaction_guid", Value: -1}}},
+	{Keys: bson.D{{Key: "given_to", Value: 1}, {Key: "transaction_guid", Value: -1}}},
+	{Keys: bson.D{{Key: "transaction_guid", Value: 1}}},
+	{Keys: bson.D{{Key: "chain_guid", Value: 1}}, Options: options.Index().SetSparse(true)},
+	{Keys: bson.D{{Key: "status", Value: 1}, {Key: "expires_at", Value: 1}}},
+}
@@ -2,12 +2,14 @@ package model
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 
 	"github.com/ChronoPlay/chronoplay-backend-service/helpers"
 )
@@ -21,18 +23,22 @@ type User struct {
 	Password     string             `bson:"password" json:"password"`
 	UserName     string             `bson:"user_name" json:"user_name"`
 	PhoneNumber  string             `bson:"phone_number" json:"phone_number"`
-	Cash         float32            `bson:"cash" json:"cash"`
+	Cash         Money              `bson:"cash" json:"cash"`
+	HeldCash     Money              `bson:"held_cash,omitempty" json:"held_cash,omitempty"` // promised in pending exchanges, not counted in Cash
 	IsAuthorized bool               `bson:"is_authorized" json:"is_authorized"`
 	CreatedAt    time.Time          `bson:"created_at" json:"created_at"`
 	UpdatedAt    time.Time          `bson:"updated_at" json:"updated_at"`
 	Cards        []CardOccupied     `bson:"cards" json:"cards"`
 	Friends      []uint32           `bson:"friends" json:"friends"`
 	Deactivated  bool               `bson:"deactivated" json:"deactivated"`
+	Version      uint32             `bson:"version" json:"version"`
 }
 
 type CardOccupied struct {
 	CardNumber string `bson:"card_number" json:"card_number"`
 	Occupied   uint32 `bson:"occupied" json:"occupied"`
+	Locked     uint32 `bson:"locked,omitempty" json:"locked,omitempty"` // held as loan collateral, not counted in Occupied
+	Held       uint32 `bson:"held,omitempty" json:"held,omitempty"`     // promised in pending exchanges, not counted in Occupied
 }
 
 type UserRepository interface {
@@ -40,8 +46,27 @@ type UserRepository interface {
 	RegisterUser(sessCtx mongo.SessionContext, user User) (uint32, *helpers.CustomError)
 	GetCollection() *mongo.Collection
 	GetUsers(ctx context.Context, req User) ([]User, *helpers.CustomError)
+	GetUserNames(ctx context.Context, userIds []uint32) (map[uint32]string, *helpers.CustomError)
 	UpdateUser(ctx context.Context, user User) *helpers.CustomError
 	UpdateField(ctx context.Context, filter bson.M, update bson.M) *helpers.CustomError
+	DebitBalance(ctx context.Context, userId uint32, cash Money, cards []CardOccupied) *helpers.CustomError
+	CreditBalance(ctx context.Context, userId uint32, cash Money, cards []CardOccupied) *helpers.CustomError
+	LockCards(ctx context.Context, userId uint32, cards []CardOccupied) *helpers.CustomError
+	UnlockCards(ctx context.Context, userId uint32, cards []CardOccupied) *helpers.CustomError
+	HoldBalance(ctx context.Context, userId uint32, cash Money, cards []CardOccupied) *helpers.CustomError
+	ReleaseHold(ctx context.Context, userId uint32, cash Money, cards []CardOccupied) *helpers.CustomError
+	DebitHeld(ctx context.Context, userId uint32, cash Money, cards []CardOccupied) *helpers.CustomError
+	WipeUser(ctx context.Context, userId uint32) *helpers.CustomError
+	SyncCardOwners(ctx context.Context, userId uint32, cardNumbers []string) *helpers.CustomError
+	GetTopHolders(ctx context.Context, cardNumber string, limit int64) ([]CardHolder, *helpers.CustomError)
+	EnsureIndexes(ctx context.Context) *helpers.CustomError
+}
+
+// CardHolder is a user holding a card, Quantity counts free, locked and held copies
+type CardHolder struct {
+	UserId   uint32 `bson:"user_id" json:"user_id"`
+	UserName string `bson:"user_name" json:"user_name"`
+	Quantity uint32 `bson:"quantity" json:"quantity"`
 }
 
 type mongoUserRepo struct {
@@ -59,7 +84,7 @@ func (repo *mongoUserRepo) FindByUserName(ctx context.Context, userName string)
 		if err == mongo.ErrNoDocuments {
 			return nil, helpers.NotFound("user not found")
 		}
-		return nil, helpers.System(err.Error())
+		return nil, helpers.SystemError("", err)
 	}
 	return &user, nil
 }
@@ -70,12 +95,12 @@ func (repo *mongoUserRepo) RegisterUser(sessCtx mongo.SessionContext, user User)
 
 	userId, err := GetNextSequence(sessCtx, repo.collection.Database(), COUNTER_ID_USER_ID)
 	if err != nil {
-		return 0, helpers.System(err.Error())
+		return 0, helpers.SystemError("", err)
 	}
 	user.UserId = uint32(userId)
 	_, err = repo.collection.InsertOne(sessCtx, user)
 	if err != nil {
-		return 0, helpers.System(err.Error())
+		return 0, helpers.SystemError("", err)
 	}
 	return user.UserId, nil
 }
@@ -85,28 +110,33 @@ func (r *mongoUserRepo) GetCollection() *mongo.Collection {
 }
 
 func (r *mongoUserRepo) UpdateUser(ctx context.Context, user User) *helpers.CustomError {
-	log.Println("Updating user:", user)
+	log.Printf("Updating user %d", user.UserId)
 	user.UpdatedAt = time.Now()
 
 	// Convert struct to bson.M
 	updateData, err := bson.Marshal(user)
 	if err != nil {
-		return helpers.System("failed to marshal user: " + err.Error())
+		return helpers.SystemError("failed to marshal user", err)
 	}
 
 	var updateDoc bson.M
 	if err := bson.Unmarshal(updateData, &updateDoc); err != nil {
-		return helpers.System("failed to unmarshal user: " + err.Error())
+		return helpers.SystemError("failed to unmarshal user", err)
 	}
 
-	// Wrap in $set
+	// the version is only ever bumped, never written back from the read copy
+	delete(updateDoc, "version")
 	update := bson.M{
 		"$set": updateDoc,
+		"$inc": bson.M{"version": 1},
 	}
 
-	_, err = r.collection.UpdateByID(ctx, user.ID, update)
+	result, err := r.collection.UpdateOne(ctx, versionFilter(user.ID, user.Version), update)
 	if err != nil {
-		return helpers.System(err.Error())
+		return helpers.SystemError("", err)
+	}
+	if result.MatchedCount == 0 {
+		return versionMismatch(ctx, r.collection, user.ID, "User")
 	}
 	return nil
 }
@@ -115,11 +145,36 @@ func (r *mongoUserRepo) UpdateUser(ctx context.Context, user User) *helpers.Cust
 func (r *mongoUserRepo) UpdateField(ctx context.Context, filter bson.M, update bson.M) *helpers.CustomError {
 	_, err := r.collection.UpdateOne(ctx, filter, update)
 	if err != nil {
-		return helpers.System("failed to update field: " + err.Error())
+		return helpers.SystemError("failed to update field", err)
 	}
 	return nil
 }
 
+// user names of the given users keyed by user id, unknown ids are left out
+func (r *mongoUserRepo) GetUserNames(ctx context.Context, userIds []uint32) (map[uint32]string, *helpers.CustomError) {
+	names := make(map[uint32]string)
+	if len(userIds) == 0 {
+		return names, nil
+	}
+	cursor, err := r.collection.Find(ctx, bson.M{"user_id": bson.M{"$in": userIds}},
+		options.Find().SetProjection(bson.M{"user_id": 1, "user_name": 1}))
+	if err != nil {
+		return nil, helpers.SystemError("Failed to get user names", err)
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		var user User
+		if err := cursor.Decode(&user); err != nil {
+			return nil, helpers.SystemError("Failed to decode user", err)
+		}
+		names[user.UserId] = user.UserName
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, helpers.SystemError("Failed to iterate cursor", err)
+	}
+	return names, nil
+}
+
 func (r *mongoUserRepo) GetUsers(ctx context.Context, req User) ([]User, *helpers.CustomError) {
 	users := []User{}
 	isValid := false
@@ -175,7 +230,7 @@ func (r *mongoUserRepo) GetUsers(ctx context.Context, req User) ([]User, *helper
 		}
 		cursor, err := r.collection.Find(ctx, filter)
 		if err != nil {
-			return users, helpers.System(err.Error())
+			return users, helpers.SystemError("", err)
 		}
 
 		defer cursor.Close(ctx)
@@ -190,8 +245,352 @@ func (r *mongoUserRepo) GetUsers(ctx context.Context, req User) ([]User, *helper
 		}
 
 		if err := cursor.Err(); err != nil {
-			return users, helpers.System(err.Error())
+			return users, helpers.SystemError("", err)
 		}
 	}
 	return users, nil
 }
+
+// Balance changes below never read the user first. Each one is a single update whose
+// filter only matches while the user still holds enough, so two concurrent debits can
+// not both spend the same cash or cards. For cards the Occupied field of the passed
+// CardOccupied is the quantity to move.
+
+// takes cash and free card quantities from the user, either all of it or nothing
+func (r *mongoUserRepo) DebitBalance(ctx context.Context, userId uint32, cash Money, cards []CardOccupied) *helpers.CustomError {
+	err := r.moveBalance(ctx, userId, cash, cards, "occupied", "")
+	if err != nil {
+		return err
+	}
+	err = r.pullEmptyCards(ctx, userId, cards)
+	if err != nil {
+		return err
+	}
+	return r.SyncCardOwners(ctx, userId, cardNumbers(cards))
+}
+
+func (r *mongoUserRepo) CreditBalance(ctx context.Context, userId uint32, cash Money, cards []CardOccupied) *helpers.CustomError {
+	if cash > 0 {
+		result, err := r.collection.UpdateOne(ctx, bson.M{"user_id": userId}, bson.M{
+			"$inc": bson.M{"cash": cash, "version": 1},
+			"$set": bson.M{"updated_at": time.Now()},
+		})
+		if err != nil {
+			return helpers.SystemError("failed to credit cash", err)
+		}
+		if result.MatchedCount == 0 {
+			return helpers.NotFound("User not found")
+		}
+	}
+	for _, card := range mergeCardQuantities(cards) {
+		err := r.creditCard(ctx, userId, card.CardNumber, card.Occupied)
+		if err != nil {
+			return err
+		}
+	}
+	return r.SyncCardOwners(ctx, userId, cardNumbers(cards))
+}
+
+// moves free cards into locked, used for loan collateral
+func (r *mongoUserRepo) LockCards(ctx context.Context, userId uint32, cards []CardOccupied) *helpers.CustomError {
+	return r.moveBalance(ctx, userId, 0, cards, "occupied", "locked")
+}
+
+func (r *mongoUserRepo) UnlockCards(ctx context.Context, userId uint32, cards []CardOccupied) *helpers.CustomError {
+	return r.moveBalance(ctx, userId, 0, cards, "locked", "occupied")
+}
+
+// moves free cash and cards into held, used for assets promised in a pending exchange
+func (r *mongoUserRepo) HoldBalance(ctx context.Context, userId uint32, cash Money, cards []CardOccupied) *helpers.CustomError {
+	return r.moveBalance(ctx, userId, cash, cards, "occupied", "held")
+}
+
+func (r *mongoUserRepo) ReleaseHold(ctx context.Context, userId uint32, cash Money, cards []CardOccupied) *helpers.CustomError {
+	return r.moveBalance(ctx, userId, cash, cards, "held", "occupied")
+}
+
+// takes held cash and cards from the user when the exchange they were promised in settles
+func (r *mongoUserRepo) DebitHeld(ctx context.Context, userId uint32, cash Money, cards []CardOccupied) *helpers.CustomError {
+	err := r.moveBalance(ctx, userId, cash, cards, "held", "")
+	if err != nil {
+		return err
+	}
+	err = r.pullEmptyCards(ctx, userId, cards)
+	if err != nil {
+		return err
+	}
+	return r.SyncCardOwners(ctx, userId, cardNumbers(cards))
+}
+
+// clears everything the user holds and deactivates them
+func (r *mongoUserRepo) WipeUser(ctx context.Context, userId uint32) *helpers.CustomError {
+	result, err := r.collection.UpdateOne(ctx, bson.M{"user_id": userId}, bson.M{
+		"$set": bson.M{
+			"cash":        Money(0),
+			"held_cash":   Money(0),
+			"cards":       []CardOccupied{},
+			"deactivated": true,
+			"updated_at":  time.Now(),
+		},
+		"$inc": bson.M{"version": 1},
+	})
+	if err != nil {
+		return helpers.SystemError("failed to wipe user", err)
+	}
+	if result.MatchedCount == 0 {
+		return helpers.NotFound("User not found")
+	}
+	_, err = r.cardCollection().UpdateMany(ctx, bson.M{"owners": userId}, bson.M{
+		"$pull": bson.M{"owners": userId},
+		"$inc":  bson.M{"version": 1},
+	})
+	if err != nil {
+		return helpers.SystemError("failed to remove user from card owners", err)
+	}
+	return nil
+}
+
+// Card.Owners lists every user holding a copy of a card, free, locked or held. It is
+// brought in line with the user's cards after each balance change, and a card is only
+// written when the user got their first copy or gave away their last one. The version
+// of the card is bumped, so an admin edit of a stale copy can't overwrite the owners.
+func (r *mongoUserRepo) SyncCardOwners(ctx context.Context, userId uint32, cardNumbers []string) *helpers.CustomError {
+	if len(cardNumbers) == 0 {
+		return nil
+	}
+	var user User
+	err := r.collection.FindOne(ctx, bson.M{"user_id": userId}, options.FindOne().SetProjection(bson.M{"cards": 1})).Decode(&user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return helpers.NotFound("User not found")
+		}
+		return helpers.SystemError("", err)
+	}
+	holds := make(map[string]bool)
+	for _, card := range user.Cards {
+		if card.Occupied > 0 || card.Locked > 0 || card.Held > 0 {
+			holds[card.CardNumber] = true
+		}
+	}
+	for _, cardNumber := range cardNumbers {
+		if holds[cardNumber] {
+			// cards added before owners were kept may still have null there
+			_, err = r.cardCollection().UpdateOne(ctx, bson.M{"number": cardNumber, "owners": bson.M{"$ne": userId}}, mongo.Pipeline{
+				{{Key: "$set", Value: bson.M{
+					"owners":  bson.M{"$concatArrays": bson.A{bson.M{"$ifNull": bson.A{"$owners", bson.A{}}}, bson.A{userId}}},
+					"version": bson.M{"$add": bson.A{"$version", 1}},
+				}}},
+			})
+		} else {
+			_, err = r.cardCollection().UpdateOne(ctx, bson.M{"number": cardNumber, "owners": userId}, bson.M{
+				"$pull": bson.M{"owners": userId},
+				"$inc":  bson.M{"version": 1},
+			})
+		}
+		if err != nil {
+			return helpers.SystemError("failed to update card owners", err)
+		}
+	}
+	return nil
+}
+
+// the biggest holders of a card, ties go to the lower user id
+func (r *mongoUserRepo) GetTopHolders(ctx context.Context, cardNumber string, limit int64) ([]CardHolder, *helpers.CustomError) {
+	quantity := func(field string) bson.M {
+		return bson.M{"$ifNull": bson.A{"$cards." + field, 0}}
+	}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"cards.card_number": cardNumber, "deactivated": bson.M{"$ne": true}}}},
+		{{Key: "$unwind", Value: "$cards"}},
+		{{Key: "$match", Value: bson.M{"cards.card_number": cardNumber}}},
+		{{Key: "$project", Value: bson.M{
+			"_id":       0,
+			"user_id":   1,
+			"user_name": 1,
+			"quantity":  bson.M{"$add": bson.A{quantity("occupied"), quantity("locked"), quantity("held")}},
+		}}},
+		{{Key: "$match", Value: bson.M{"quantity": bson.M{"$gt": 0}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "quantity", Value: -1}, {Key: "user_id", Value: 1}}}},
+		{{Key: "$limit", Value: limit}},
+	}
+	cursor, err := r.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, helpers.SystemError("Failed to get card holders", err)
+	}
+	defer cursor.Close(ctx)
+	holders := []CardHolder{}
+	for cursor.Next(ctx) {
+		var holder CardHolder
+		if err := cursor.Decode(&holder); err != nil {
+			return nil, helpers.SystemError("Failed to decode card holder", err)
+		}
+		holders = append(holders, holder)
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, helpers.SystemError("Failed to iterate cursor", err)
+	}
+	return holders, nil
+}
+
+// the card index backs the top holders of a card
+func (r *mongoUserRepo) EnsureIndexes(ctx context.Context) *helpers.CustomError {
+	_, err := r.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "cards.card_number", Value: 1}}},
+	})
+	if err != nil {
+		return helpers.SystemError("Failed to create user indexes", err)
+	}
+	return nil
+}
+
+// the owners lists live on the cards, in the same database
+func (r *mongoUserRepo) cardCollection() *mongo.Collection {
+	return r.collection.Database().Collection("cards")
+}
+
+// guarded update: every card quantity is taken from the from field and, if given, added
+// to the to field. Cash moves the same way between the matching cash fields, see cashField.
+func (r *mongoUserRepo) moveBalance(ctx context.Context, userId uint32, cash Money, cards []CardOccupied, from string, to string) *helpers.CustomError {
+	cards = mergeCardQuantities(cards)
+	if cash <= 0 && len(cards) == 0 {
+		return nil
+	}
+	guards := []bson.M{{"user_id": userId}}
+	inc := bson.M{"version": 1}
+	arrayFilters := []interface{}{}
+	if cash > 0 {
+		guards = append(guards, bson.M{cashField(from): bson.M{"$gte": cash}})
+		inc[cashField(from)] = -cash
+		if to != "" {
+			inc[cashField(to)] = cash
+		}
+	}
+	for i, card := range cards {
+		identifier := fmt.Sprintf("c%d", i)
+		guards = append(guards, bson.M{"cards": bson.M{"$elemMatch": bson.M{
+			"card_number": card.CardNumber,
+			from:          bson.M{"$gte": card.Occupied},
+		}}})
+		inc["cards.$["+identifier+"]."+from] = -int64(card.Occupied)
+		if to != "" {
+			inc["cards.$["+identifier+"]."+to] = int64(card.Occupied)
+		}
+		arrayFilters = append(arrayFilters, bson.M{identifier + ".card_number": card.CardNumber})
+	}
+	update := bson.M{
+		"$inc": inc,
+		"$set": bson.M{"updated_at": time.Now()},
+	}
+	opts := options.Update()
+	if len(arrayFilters) > 0 {
+		opts.SetArrayFilters(options.ArrayFilters{Filters: arrayFilters})
+	}
+	result, err := r.collection.UpdateOne(ctx, bson.M{"$and": guards}, update, opts)
+	if err != nil {
+		return helpers.SystemError("failed to update balance", err)
+	}
+	if result.MatchedCount == 0 {
+		return r.balanceMismatch(ctx, userId)
+	}
+	return nil
+}
+
+// increments the card if the user already has it, pushes it otherwise. Both steps are
+// guarded, so when a concurrent update wins the race in between we simply try again
+func (r *mongoUserRepo) creditCard(ctx context.Context, userId uint32, cardNumber string, quantity uint32) *helpers.CustomError {
+	for attempt := 0; attempt < 3; attempt++ {
+		result, err := r.collection.UpdateOne(ctx, bson.M{"user_id": userId, "cards.card_number": cardNumber}, bson.M{
+			"$inc": bson.M{"cards.$.occupied": int64(quantity), "version": 1},
+			"$set": bson.M{"updated_at": time.Now()},
+		})
+		if err != nil {
+			return helpers.SystemError("failed to credit card", err)
+		}
+		if result.MatchedCount > 0 {
+			return nil
+		}
+		result, err = r.collection.UpdateOne(ctx, bson.M{"user_id": userId, "cards.card_number": bson.M{"$ne": cardNumber}}, bson.M{
+			"$push": bson.M{"cards": CardOccupied{CardNumber: cardNumber, Occupied: quantity}},
+			"$inc":  bson.M{"version": 1},
+			"$set":  bson.M{"updated_at": time.Now()},
+		})
+		if err != nil {
+			return helpers.SystemError("failed to credit card", err)
+		}
+		if result.MatchedCount > 0 {
+			return nil
+		}
+		count, err := r.collection.CountDocuments(ctx, bson.M{"user_id": userId})
+		if err != nil {
+			return helpers.SystemError("", err)
+		}
+		if count == 0 {
+			return helpers.NotFound("User not found")
+		}
+	}
+	return helpers.System("failed to credit card " + cardNumber + " after concurrent updates")
+}
+
+// drops the given cards from the user once nothing of them is free, locked or held
+func (r *mongoUserRepo) pullEmptyCards(ctx context.Context, userId uint32, cards []CardOccupied) *helpers.CustomError {
+	if len(cards) == 0 {
+		return nil
+	}
+	_, err := r.collection.UpdateOne(ctx, bson.M{"user_id": userId}, bson.M{
+		"$pull": bson.M{"cards": bson.M{
+			"occupied": 0,
+			"locked":   bson.M{"$in": bson.A{nil, 0}},
+			"held":     bson.M{"$in": bson.A{nil, 0}},
+		}},
+		"$inc": bson.M{"version": 1},
+	})
+	if err != nil {
+		return helpers.SystemError("failed to clean up cards", err)
+	}
+	return nil
+}
+
+// the user field holding cash for a card quantity field, there is no locked cash
+func cashField(cardField string) string {
+	if cardField == "held" {
+		return "held_cash"
+	}
+	return "cash"
+}
+
+func (r *mongoUserRepo) balanceMismatch(ctx context.Context, userId uint32) *helpers.CustomError {
+	count, err := r.collection.CountDocuments(ctx, bson.M{"user_id": userId})
+	if err != nil {
+		return helpers.SystemError("", err)
+	}
+	if count == 0 {
+		return helpers.NotFound("User not found")
+	}
+	return helpers.BadRequest("Insufficient balance")
+}
+
+func cardNumbers(cards []CardOccupied) []string {
+	numbers := []string{}
+	for _, card := range mergeCardQuantities(cards) {
+		numbers = append(numbers, card.CardNumber)
+	}
+	return numbers
+}
+
+// sums quantities of the same card and drops empty ones
+func mergeCardQuantities(cards []CardOccupied) []CardOccupied {
+	merged := []CardOccupied{}
+	index := make(map[string]int)
+	for _, card := range cards {
+		if card.Occupied == 0 {
+			continue
+		}
+		if i, ok := index[card.CardNumber]; ok {
+			merged[i].Occupied += card.Occupied
+			continue
+		}
+		index[card.CardNumber] = len(merged)
+		merged = append(merged, CardOccupied{CardNumber: card.CardNumber, Occupied: card.Occupied})
+	}
+	return merged
+}
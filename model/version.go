@@ -0,0 +1,37 @@
+package model
+
+import (
+	"context"
+
+	"github.com/ChronoPlay/chronoplay-backend-service/helpers"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// User and Card carry a Version that every write increments. Full document updates
+// only match the version that was read, so a concurrent change is reported as a
+// conflict instead of being overwritten.
+
+// documents written before versioning have no version field, they count as version 0
+func versionFilter(id primitive.ObjectID, version uint32) bson.M {
+	if version == 0 {
+		return bson.M{"_id": id, "$or": bson.A{
+			bson.M{"version": 0},
+			bson.M{"version": bson.M{"$exists": false}},
+		}}
+	}
+	return bson.M{"_id": id, "version": version}
+}
+
+// tells a missing document apart from one that was changed since it was read
+func versionMismatch(ctx context.Context, col *mongo.Collection, id primitive.ObjectID, name string) *helpers.CustomError {
+	count, err := col.CountDocuments(ctx, bson.M{"_id": id})
+	if err != nil {
+		return helpers.SystemError("", err)
+	}
+	if count == 0 {
+		return helpers.NotFound(name + " not found")
+	}
+	return helpers.Conflict(name + " was modified concurrently, please retry")
+}
@@ -9,7 +9,7 @@ import (
 	middleware "github.com/ChronoPlay/chronoplay-backend-service/middlewares"
 )
 
-func SetupRoutes(r *gin.Engine, userController controller.UserController, cardController controller.CardController, loanController controller.LoanController, transactionController controller.TransactionController, notificationController controller.NotificationController) {
+func SetupRoutes(r *gin.Engine, userController controller.UserController, cardController controller.CardController, loanController controller.LoanController, transactionController controller.TransactionController, notificationController controller.NotificationController, ledgerController controller.LedgerController, statementController controller.StatementController, idempotency gin.HandlerFunc) {
 	auth := r.Group("/auth", middleware.CustomContextMiddleware())
 
 	fmt.Print("request has entered here- router \n")
@@ -34,16 +34,37 @@ func SetupRoutes(r *gin.Engine, userController controller.UserController, cardCo
 	{
 		card.POST("/add", cardController.AddCard)
 		card.GET("/get_card", cardController.GetCard)
+		card.GET("/catalog", cardController.GetCatalog)
+		card.GET("/rarities", cardController.GetRarities)
+		card.PATCH("/update", cardController.UpdateCard)
+		card.POST("/retire", cardController.RetireCard)
+		card.GET("/audits", cardController.GetCardAudits)
+		card.GET("/top_holders", cardController.GetTopHolders)
 	}
 
 	transaction := r.Group("/transaction", middleware.AuthorizeUser(), middleware.CustomContextMiddleware())
 	{
-		transaction.POST("/transfer_cash", transactionController.Transfercash)
-		transaction.POST("/transfer_cards", transactionController.Transfercards)
-		transaction.POST("/exchange", transactionController.Exchange)
+		transaction.POST("/transfer_cash", idempotency, transactionController.Transfercash)
+		transaction.POST("/transfer_cards", idempotency, transactionController.Transfercards)
+		transaction.POST("/give_cards", idempotency, transactionController.GiveCards)
+		transaction.POST("/exchange", idempotency, transactionController.Exchange)
 		transaction.GET("/get_transactions", transactionController.GetTransactions)
+		transaction.GET("/export", transactionController.ExportTransactions)
 		transaction.GET("/get_possible_exchange", transactionController.GetPossibleExchange)
 		transaction.POST("/execute_exchange", transactionController.ExecuteExchange)
+		transaction.POST("/respond_transfer", transactionController.RespondTransfer)
+		transaction.POST("/cancel_exchange", transactionController.CancelExchange)
+		transaction.POST("/counter_exchange", transactionController.CounterExchange)
+		transaction.POST("/trade", idempotency, transactionController.Trade)
+		transaction.POST("/respond_trade", transactionController.RespondTrade)
+	}
+
+	loan := r.Group("/loan", middleware.AuthorizeUser(), middleware.CustomContextMiddleware())
+	{
+		loan.POST("/request", loanController.RequestLoan)
+		loan.POST("/respond", loanController.RespondLoan)
+		loan.GET("/get_loans", loanController.GetLoans)
+		loan.POST("/repay", loanController.RepayLoan)
 	}
 
 	notification := r.Group("/notification", middleware.AuthorizeUser(), middleware.CustomContextMiddleware())
@@ -52,4 +73,15 @@ func SetupRoutes(r *gin.Engine, userController controller.UserController, cardCo
 		notification.PATCH("/mark_as_read", notificationController.MarkAsRead)
 	}
 
+	ledger := r.Group("/ledger", middleware.AuthorizeUser(), middleware.CustomContextMiddleware())
+	{
+		ledger.POST("/reconcile_balance", ledgerController.ReconcileBalance)
+	}
+
+	statement := r.Group("/statement", middleware.AuthorizeUser(), middleware.CustomContextMiddleware())
+	{
+		statement.GET("/get_statement", statementController.GetStatement)
+		statement.POST("/email_statement", statementController.EmailStatement)
+	}
+
 }
@@ -7,6 +7,7 @@ import (
 
 	"github.com/ChronoPlay/chronoplay-backend-service/dto"
 	"github.com/ChronoPlay/chronoplay-backend-service/helpers"
+	"github.com/ChronoPlay/chronoplay-backend-service/mapper"
 	model "github.com/ChronoPlay/chronoplay-backend-service/model"
 	"github.com/ChronoPlay/chronoplay-backend-service/utils"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -15,17 +16,28 @@ import (
 type CardService interface {
 	AddCard(ctx context.Context, req dto.AddCardRequest) *helpers.CustomError
 	GetCard(ctx context.Context, req dto.GetCardRequest) (res *model.Card, err *helpers.CustomError)
+	GetCatalog(ctx context.Context, req dto.GetCatalogRequest) (res dto.GetCatalogResponse, err *helpers.CustomError)
+	UpdateCard(ctx context.Context, req dto.UpdateCardRequest) (res dto.GetCardResponse, err *helpers.CustomError)
+	RetireCard(ctx context.Context, req dto.RetireCardRequest) *helpers.CustomError
+	GetCardAudits(ctx context.Context, req dto.GetCardAuditsRequest) ([]model.CardAudit, *helpers.CustomError)
+	GetTopHolders(ctx context.Context, req dto.GetTopHoldersRequest) (res dto.GetTopHoldersResponse, err *helpers.CustomError)
 }
 
 type cardService struct {
-	cardRepo model.CardRepository
-	UserRepo model.UserRepository
+	cardRepo      model.CardRepository
+	UserRepo      model.UserRepository
+	cardAuditRepo model.CardAuditRepository
+	imageStorage  utils.ImageStorage
+	unitOfWork    UnitOfWork
 }
 
-func NewCardService(cardRepo model.CardRepository, userRepo model.UserRepository) CardService {
+func NewCardService(cardRepo model.CardRepository, userRepo model.UserRepository, cardAuditRepo model.CardAuditRepository, imageStorage utils.ImageStorage, unitOfWork UnitOfWork) CardService {
 	return &cardService{
-		cardRepo: cardRepo,
-		UserRepo: userRepo,
+		cardRepo:      cardRepo,
+		UserRepo:      userRepo,
+		cardAuditRepo: cardAuditRepo,
+		imageStorage:  imageStorage,
+		unitOfWork:    unitOfWork,
 	}
 }
 
@@ -42,27 +54,22 @@ func (s *cardService) AddCard(ctx context.Context, req dto.AddCardRequest) *help
 	}
 	fmt.Println("User:", users[0])
 	req.UserType = users[0].UserType
+	if req.Rarity == "" {
+		req.Rarity = model.CARD_RARITY_COMMON
+	}
 
 	err = utils.ValidateAddCardRequest(req)
 	if err != nil {
 		return err
 	}
 
-	// uploading image on cloudinary
-	imageUrl, err := utils.UploadImageToCloudinary(ctx, req.Image)
+	imageUrl, err := s.imageStorage.Upload(ctx, req.Image)
 	if err != nil {
 		return err
 	}
 
 	log.Println("Entered here - RegisterUser (userService)")
-	session, derr := s.cardRepo.GetCollection().Database().Client().StartSession()
-	if derr != nil {
-		return helpers.System("Failed to start session: " + derr.Error())
-	}
-	defer session.EndSession(ctx)
-	log.Println("Successfully created session")
-
-	merr := mongo.WithSession(ctx, session, func(sessCtx mongo.SessionContext) error {
+	err = s.unitOfWork.Run(ctx, func(sessCtx mongo.SessionContext) *helpers.CustomError {
 		// You can use sessCtx instead of ctx for transactional operations
 
 		existingCards, err := s.cardRepo.GetCards(sessCtx, model.GetCardsRequest{
@@ -82,6 +89,8 @@ func (s *cardService) AddCard(ctx context.Context, req dto.AddCardRequest) *help
 			Total:       req.TotalCards,
 			Creator:     req.UserId,
 			ImageUrl:    imageUrl,
+			Rarity:      req.Rarity,
+			Owners:      []uint32{},
 		})
 		if err != nil {
 			return err
@@ -90,8 +99,8 @@ func (s *cardService) AddCard(ctx context.Context, req dto.AddCardRequest) *help
 		return nil // Will commit if nil
 	})
 
-	if merr != nil {
-		return helpers.NoType(merr)
+	if err != nil {
+		return err
 	}
 
 	return nil
@@ -112,3 +121,182 @@ func (s *cardService) GetCard(ctx context.Context, req dto.GetCardRequest) (res
 	}
 	return card, nil
 }
+
+func (s *cardService) GetCatalog(ctx context.Context, req dto.GetCatalogRequest) (res dto.GetCatalogResponse, err *helpers.CustomError) {
+	if req.Page == 0 {
+		req.Page = 1
+	}
+	if req.Limit == 0 {
+		req.Limit = model.CARD_CATALOG_DEFAULT_LIMIT
+	}
+	err = utils.ValidateGetCatalogRequest(req)
+	if err != nil {
+		return res, err
+	}
+	cards, total, err := s.cardRepo.SearchCards(ctx, model.CardCatalogFilter{
+		Search:       req.Search,
+		Rarity:       req.Rarity,
+		Creator:      req.Creator,
+		MinAvailable: req.MinAvailable,
+		Sort:         req.Sort,
+		Skip:         (req.Page - 1) * req.Limit,
+		Limit:        req.Limit,
+	})
+	if err != nil {
+		return res, err
+	}
+	return mapper.EncodeGetCatalogResponse(cards, req, total), nil
+}
+
+// changes the set fields of a card and records the change. The total can be raised
+// up to the maximum supply of the card's rarity or capped, but never below the copies
+// already handed out.
+func (s *cardService) UpdateCard(ctx context.Context, req dto.UpdateCardRequest) (res dto.GetCardResponse, err *helpers.CustomError) {
+	err = utils.ValidateUpdateCardRequest(req)
+	if err != nil {
+		return res, err
+	}
+	err = s.checkAdmin(ctx, req.UserId, "Only admin can edit cards")
+	if err != nil {
+		return res, err
+	}
+	imageUrl := ""
+	if req.Image != nil {
+		imageUrl, err = s.imageStorage.Upload(ctx, req.Image)
+		if err != nil {
+			return res, err
+		}
+	}
+
+	err = s.unitOfWork.Run(ctx, func(sessCtx mongo.SessionContext) *helpers.CustomError {
+		card, err := s.cardRepo.GetCardByNumber(sessCtx, req.CardNumber)
+		if err != nil {
+			return err
+		}
+		if card.Retired {
+			return helpers.BadRequest("Retired cards cannot be edited")
+		}
+		changes := []model.CardChange{}
+		if req.CardName != nil && *req.CardName != card.Name {
+			changes = append(changes, model.CardChange{Field: "name", From: card.Name, To: *req.CardName})
+			card.Name = *req.CardName
+		}
+		if req.CardDescription != nil && *req.CardDescription != card.Description {
+			changes = append(changes, model.CardChange{Field: "description", From: card.Description, To: *req.CardDescription})
+			card.Description = *req.CardDescription
+		}
+		if req.TotalCards != nil && *req.TotalCards != card.Total {
+			if *req.TotalCards < card.Occupied {
+				return helpers.BadRequest(fmt.Sprintf("Total cannot go below the %d copies already handed out", card.Occupied))
+			}
+			maxSupply, ok := model.CardRarityMaxSupply[card.Rarity]
+			if ok && *req.TotalCards > maxSupply {
+				return helpers.BadRequest(fmt.Sprintf("Total of a %s card can be at most %d", card.Rarity, maxSupply))
+			}
+			changes = append(changes, model.CardChange{Field: "total", From: fmt.Sprint(card.Total), To: fmt.Sprint(*req.TotalCards)})
+			card.Total = *req.TotalCards
+		}
+		if imageUrl != "" {
+			changes = append(changes, model.CardChange{Field: "image_url", From: card.ImageUrl, To: imageUrl})
+			card.ImageUrl = imageUrl
+		}
+		res = mapper.EncodeGetCardResponse(card)
+		if len(changes) == 0 {
+			return nil
+		}
+		err = s.cardRepo.UpdateCard(sessCtx, *card)
+		if err != nil {
+			return err
+		}
+		return s.cardAuditRepo.AddAudit(sessCtx, model.CardAudit{
+			CardNumber: card.Number,
+			Action:     model.CARD_AUDIT_ACTION_UPDATE,
+			ChangedBy:  req.UserId,
+			Changes:    changes,
+		})
+	})
+	return res, err
+}
+
+// a retired card stays with its owners but can no longer be minted or traded
+func (s *cardService) RetireCard(ctx context.Context, req dto.RetireCardRequest) *helpers.CustomError {
+	err := utils.ValidateRetireCardRequest(req)
+	if err != nil {
+		return err
+	}
+	err = s.checkAdmin(ctx, req.UserId, "Only admin can retire cards")
+	if err != nil {
+		return err
+	}
+	return s.unitOfWork.Run(ctx, func(sessCtx mongo.SessionContext) *helpers.CustomError {
+		card, err := s.cardRepo.GetCardByNumber(sessCtx, req.CardNumber)
+		if err != nil {
+			return err
+		}
+		if card.Retired {
+			return helpers.BadRequest("Card is already retired")
+		}
+		card.Retired = true
+		err = s.cardRepo.UpdateCard(sessCtx, *card)
+		if err != nil {
+			return err
+		}
+		return s.cardAuditRepo.AddAudit(sessCtx, model.CardAudit{
+			CardNumber: card.Number,
+			Action:     model.CARD_AUDIT_ACTION_RETIRE,
+			ChangedBy:  req.UserId,
+			Changes:    []model.CardChange{{Field: "retired", From: "false", To: "true"}},
+		})
+	})
+}
+
+func (s *cardService) GetCardAudits(ctx context.Context, req dto.GetCardAuditsRequest) ([]model.CardAudit, *helpers.CustomError) {
+	err := utils.ValidateGetCardAuditsRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	err = s.checkAdmin(ctx, req.UserId, "Only admin can read card audits")
+	if err != nil {
+		return nil, err
+	}
+	_, err = s.cardRepo.GetCardByNumber(ctx, req.CardNumber)
+	if err != nil {
+		return nil, err
+	}
+	return s.cardAuditRepo.GetAuditsByCardNumber(ctx, req.CardNumber)
+}
+
+// the users holding the most copies of a card, the owner count comes from the
+// owners kept on the card
+func (s *cardService) GetTopHolders(ctx context.Context, req dto.GetTopHoldersRequest) (res dto.GetTopHoldersResponse, err *helpers.CustomError) {
+	if req.Limit == 0 {
+		req.Limit = model.CARD_TOP_HOLDERS_DEFAULT_LIMIT
+	}
+	err = utils.ValidateGetTopHoldersRequest(req)
+	if err != nil {
+		return res, err
+	}
+	card, err := s.cardRepo.GetCardByNumber(ctx, req.CardNumber)
+	if err != nil {
+		return res, err
+	}
+	holders, err := s.UserRepo.GetTopHolders(ctx, req.CardNumber, req.Limit)
+	if err != nil {
+		return res, err
+	}
+	return mapper.EncodeGetTopHoldersResponse(card, holders), nil
+}
+
+func (s *cardService) checkAdmin(ctx context.Context, userId uint32, message string) *helpers.CustomError {
+	users, err := s.UserRepo.GetUsers(ctx, model.User{UserId: userId})
+	if err != nil {
+		return err
+	}
+	if len(users) == 0 {
+		return helpers.NotFound("User not found")
+	}
+	if !utils.IsAdmin(users[0].UserType) {
+		return helpers.Unauthorized(message)
+	}
+	return nil
+}
@@ -0,0 +1,337 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/ChronoPlay/chronoplay-backend-service/dto"
+	model "github.com/ChronoPlay/chronoplay-backend-service/model"
+)
+
+// These tests fire concurrent transfers and exchange acceptances at one balance and
+// check that nothing is spent twice and that the ledger agrees with the balances.
+// They need a MongoDB replica set (transactions) and are skipped unless MONGODB_URI
+// or MONGO_URI is set. Each test uses and drops its own scratch database.
+
+const (
+	concurrencyWorkers    = 20
+	concurrencyCardNumber = "CONCURRENCY-TEST"
+)
+
+type concurrencyFixture struct {
+	db                 *mongo.Database
+	userRepo           model.UserRepository
+	ledgerRepo         model.LedgerRepository
+	transactionService TransactionService
+}
+
+func newConcurrencyFixture(t *testing.T, name string) *concurrencyFixture {
+	uri := os.Getenv("MONGODB_URI")
+	if uri == "" {
+		uri = os.Getenv("MONGO_URI")
+	}
+	if uri == "" {
+		t.Skip("MONGODB_URI not set, skipping concurrency test against MongoDB")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	if err := client.Ping(ctx, nil); err != nil {
+		t.Fatalf("ping: %v", err)
+	}
+	dbName := os.Getenv("MONGO_DB_NAME")
+	if dbName == "" {
+		dbName = "chronoplay"
+	}
+	db := client.Database(dbName + "_concurrency_" + name)
+	// a previous run that failed leaves its data behind
+	if err := db.Drop(ctx); err != nil {
+		t.Fatalf("reset scratch database: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := db.Drop(context.Background()); err != nil {
+			t.Logf("drop scratch database: %v", err)
+		}
+		client.Disconnect(context.Background())
+	})
+
+	userRepo := model.NewUserRepository(db.Collection("users"))
+	ledgerRepo := model.NewLedgerRepository(db.Collection("ledger_entries"))
+	unitOfWork := NewUnitOfWork(client)
+	transactionService := NewTransactionService(
+		model.NewCardTransactionRepository(db.Collection("card_transactions")),
+		model.NewCashTransactionRepository(db.Collection("cash_transactions")),
+		userRepo,
+		model.NewCardRepository(db.Collection("cards")),
+		NewNotificationService(model.NewNotificationRepository(db.Collection("notifications"))),
+		NewLedgerService(ledgerRepo, userRepo, unitOfWork),
+		NewIdempotencyService(model.NewIdempotencyRepository(db.Collection("idempotency_keys")), time.Hour),
+		unitOfWork,
+		time.Hour,
+	)
+	// collections can't be created inside a transaction on older servers
+	for _, name := range []string{"users", "cards", "card_transactions", "cash_transactions", "notifications", "ledger_entries", "counters"} {
+		if err := db.CreateCollection(ctx, name); err != nil {
+			t.Fatalf("create collection %s: %v", name, err)
+		}
+	}
+	_, err = db.Collection("cards").InsertOne(ctx, model.Card{
+		Number: concurrencyCardNumber,
+		Name:   "Concurrency",
+		Total:  1000,
+		Owners: []uint32{},
+		Rarity: model.CARD_RARITY_COMMON,
+	})
+	if err != nil {
+		t.Fatalf("create card: %v", err)
+	}
+	return &concurrencyFixture{
+		db:                 db,
+		userRepo:           userRepo,
+		ledgerRepo:         ledgerRepo,
+		transactionService: transactionService,
+	}
+}
+
+// creates the user together with an opening balance in the ledger
+func (f *concurrencyFixture) addUser(t *testing.T, userId uint32, cash model.Money, cards uint32) {
+	user := model.User{
+		UserId:       userId,
+		UserName:     fmt.Sprintf("user%d", userId),
+		UserType:     "user",
+		Cash:         cash,
+		IsAuthorized: true,
+		Cards:        []model.CardOccupied{},
+		Friends:      []uint32{},
+	}
+	movements := []model.LedgerMovement{{Asset: model.LEDGER_ASSET_CASH, Amount: int64(cash)}}
+	if cards > 0 {
+		user.Cards = append(user.Cards, model.CardOccupied{CardNumber: concurrencyCardNumber, Occupied: cards})
+		movements = append(movements, model.LedgerMovement{Asset: concurrencyCardNumber, Amount: int64(cards)})
+	}
+	if _, err := f.db.Collection("users").InsertOne(context.Background(), user); err != nil {
+		t.Fatalf("create user %d: %v", userId, err)
+	}
+	postings := []model.LedgerPosting{}
+	for _, movement := range movements {
+		if movement.Amount <= 0 {
+			continue
+		}
+		movement.Kind = model.LEDGER_KIND_OPENING_BALANCE
+		movement.From = model.LEDGER_ACCOUNT_SYSTEM
+		movement.To = userId
+		postings = append(postings, movement.Postings()...)
+	}
+	if err := f.ledgerRepo.AddPostings(context.Background(), postings); err != nil {
+		t.Fatalf("opening balance of user %d: %v", userId, err)
+	}
+}
+
+func (f *concurrencyFixture) user(t *testing.T, userId uint32) model.User {
+	users, err := f.userRepo.GetUsers(context.Background(), model.User{UserId: userId})
+	if err != nil || len(users) == 0 {
+		t.Fatalf("read user %d: %v", userId, err)
+	}
+	return users[0]
+}
+
+func cardQuantity(user model.User, field string) uint32 {
+	for _, card := range user.Cards {
+		if card.CardNumber != concurrencyCardNumber {
+			continue
+		}
+		switch field {
+		case "held":
+			return card.Held
+		case "locked":
+			return card.Locked
+		}
+		return card.Occupied
+	}
+	return 0
+}
+
+// the stored balances, counting held and locked assets, must equal the ledger's
+func (f *concurrencyFixture) assertLedgerMatches(t *testing.T, userId uint32) {
+	user := f.user(t, userId)
+	balances, err := f.ledgerRepo.GetBalances(context.Background(), userId)
+	if err != nil {
+		t.Fatalf("ledger balances of user %d: %v", userId, err)
+	}
+	if stored := int64(user.Cash) + int64(user.HeldCash); balances[model.LEDGER_ASSET_CASH] != stored {
+		t.Errorf("user %d: ledger cash %d, stored %d", userId, balances[model.LEDGER_ASSET_CASH], stored)
+	}
+	stored := int64(cardQuantity(user, "occupied")) + int64(cardQuantity(user, "held")) + int64(cardQuantity(user, "locked"))
+	if balances[concurrencyCardNumber] != stored {
+		t.Errorf("user %d: ledger cards %d, stored %d", userId, balances[concurrencyCardNumber], stored)
+	}
+}
+
+// distinct transactions of the kind that reached the ledger for the account
+func (f *concurrencyFixture) ledgerTransactions(t *testing.T, userId uint32, kind string) int {
+	postings, err := f.ledgerRepo.GetPostings(context.Background(), userId, time.Time{}, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("ledger postings of user %d: %v", userId, err)
+	}
+	guids := make(map[uint32]bool)
+	for _, posting := range postings {
+		if posting.Kind == kind {
+			guids[posting.TransactionGuid] = true
+		}
+	}
+	return len(guids)
+}
+
+// guids come back from Distinct as whatever integer type they were stored as
+func transactionGuid(t *testing.T, value interface{}) uint32 {
+	switch guid := value.(type) {
+	case int32:
+		return uint32(guid)
+	case int64:
+		return uint32(guid)
+	}
+	t.Fatalf("unexpected transaction guid %v", value)
+	return 0
+}
+
+func TestConcurrentTransferCashDoesNotDoubleSpend(t *testing.T) {
+	f := newConcurrencyFixture(t, "cash")
+	const giver, receiver uint32 = 1, 2
+	startingCash := model.NewMoney(100)
+	amount := model.NewMoney(30)
+	f.addUser(t, giver, startingCash, 0)
+	f.addUser(t, receiver, 0, 0)
+
+	var succeeded int64
+	var wg sync.WaitGroup
+	for i := 0; i < concurrencyWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := f.transactionService.TransferCash(context.Background(), dto.TransferCashRequest{
+				Amount:  amount,
+				GivenBy: giver,
+				GivenTo: receiver,
+				Status:  model.TRANSACTION_STATUS_SUCCESS,
+				UserId:  giver,
+			})
+			if err == nil {
+				atomic.AddInt64(&succeeded, 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	maxTransfers := int64(startingCash / amount)
+	if succeeded == 0 || succeeded > maxTransfers {
+		t.Fatalf("%d transfers succeeded, want between 1 and %d", succeeded, maxTransfers)
+	}
+	spent := model.Money(succeeded) * amount
+	if cash := f.user(t, giver).Cash; cash != startingCash-spent {
+		t.Errorf("giver has %s, want %s", cash, startingCash-spent)
+	}
+	if cash := f.user(t, receiver).Cash; cash != spent {
+		t.Errorf("receiver has %s, want %s", cash, spent)
+	}
+	if n := f.ledgerTransactions(t, giver, model.LEDGER_KIND_TRANSFER); int64(n) != succeeded {
+		t.Errorf("%d transfers in the ledger, %d succeeded", n, succeeded)
+	}
+	f.assertLedgerMatches(t, giver)
+	f.assertLedgerMatches(t, receiver)
+}
+
+// the seller proposes one exchange per card, the buyer can only afford some of them
+// and every exchange is accepted twice at the same time
+func TestConcurrentExecuteExchangeDoesNotDoubleSpend(t *testing.T) {
+	f := newConcurrencyFixture(t, "exchange")
+	const seller, buyer uint32 = 1, 2
+	const exchanges = concurrencyWorkers / 2
+	startingCash := model.NewMoney(100)
+	price := model.NewMoney(30)
+	f.addUser(t, seller, 0, exchanges)
+	f.addUser(t, buyer, startingCash, 0)
+
+	ctx := context.Background()
+	for i := 0; i < exchanges; i++ {
+		err := f.transactionService.Exchange(ctx, dto.ExchangeRequest{
+			GivenBy:      seller,
+			GivenTo:      buyer,
+			CashRecieved: price,
+			CardsSent:    []dto.Card{{CardNumber: concurrencyCardNumber, Amount: 1}},
+			UserId:       seller,
+		})
+		if err != nil {
+			t.Fatalf("propose exchange %d: %v", i, err)
+		}
+	}
+	guids, err := f.db.Collection("card_transactions").Distinct(ctx, "transaction_guid", bson.M{"type": model.TRANSACTION_TYPE_EXCHANGE})
+	if err != nil {
+		t.Fatalf("read exchanges: %v", err)
+	}
+	if len(guids) != exchanges {
+		t.Fatalf("%d exchanges proposed, want %d", len(guids), exchanges)
+	}
+
+	var succeeded int64
+	var wg sync.WaitGroup
+	for _, guid := range guids {
+		for attempt := 0; attempt < 2; attempt++ {
+			wg.Add(1)
+			go func(guid uint32) {
+				defer wg.Done()
+				err := f.transactionService.ExecuteExchange(ctx, dto.ExecuteExchangeRequest{
+					TransactionGuid: guid,
+					UserId:          buyer,
+					IsAccepted:      true,
+				})
+				if err == nil {
+					atomic.AddInt64(&succeeded, 1)
+				}
+			}(transactionGuid(t, guid))
+		}
+	}
+	wg.Wait()
+
+	maxExchanges := int64(startingCash / price)
+	if succeeded == 0 || succeeded > maxExchanges {
+		t.Fatalf("%d acceptances succeeded, want between 1 and %d", succeeded, maxExchanges)
+	}
+	settled, err := f.db.Collection("card_transactions").Distinct(ctx, "transaction_guid", bson.M{"status": model.TRANSACTION_STATUS_SUCCESS})
+	if err != nil {
+		t.Fatalf("read settled exchanges: %v", err)
+	}
+	if int64(len(settled)) != succeeded {
+		t.Errorf("%d exchanges settled, %d acceptances succeeded", len(settled), succeeded)
+	}
+	spent := model.Money(succeeded) * price
+	buyerUser, sellerUser := f.user(t, buyer), f.user(t, seller)
+	if buyerUser.Cash != startingCash-spent {
+		t.Errorf("buyer has %s, want %s", buyerUser.Cash, startingCash-spent)
+	}
+	if got := cardQuantity(buyerUser, "occupied"); int64(got) != succeeded {
+		t.Errorf("buyer has %d cards, want %d", got, succeeded)
+	}
+	if sellerUser.Cash != spent {
+		t.Errorf("seller has %s, want %s", sellerUser.Cash, spent)
+	}
+	if got := cardQuantity(sellerUser, "held"); int64(got) != exchanges-succeeded {
+		t.Errorf("seller holds %d cards for open exchanges, want %d", got, exchanges-succeeded)
+	}
+	if n := f.ledgerTransactions(t, buyer, model.LEDGER_KIND_EXCHANGE); int64(n) != succeeded {
+		t.Errorf("%d exchanges in the ledger, %d succeeded", n, succeeded)
+	}
+	f.assertLedgerMatches(t, seller)
+	f.assertLedgerMatches(t, buyer)
+}
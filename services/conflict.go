@@ -0,0 +1,24 @@
+package service
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/ChronoPlay/chronoplay-backend-service/helpers"
+)
+
+const conflictRetries = 3
+
+// reruns work while it loses a version race, work has to re-read whatever it updates.
+// After the last attempt the conflict is returned to the caller
+func retryOnConflict(work func() *helpers.CustomError) *helpers.CustomError {
+	var err *helpers.CustomError
+	for attempt := 1; attempt <= conflictRetries; attempt++ {
+		err = work()
+		if err == nil || err.Code != http.StatusConflict {
+			return err
+		}
+		log.Printf("Version conflict on attempt %d: %v", attempt, err)
+	}
+	return err
+}
@@ -0,0 +1,111 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/ChronoPlay/chronoplay-backend-service/helpers"
+	model "github.com/ChronoPlay/chronoplay-backend-service/model"
+)
+
+// a request still in progress after this long is assumed to have died with its server.
+// It has to outlast the 120s a transaction keeps retrying, otherwise a slow request
+// could lose its key to a retry of itself.
+const idempotencyLockTimeout = 5 * time.Minute
+
+type IdempotencyService interface {
+	Begin(ctx context.Context, userId uint32, key string, requestHash string) (*model.IdempotencyRecord, *helpers.CustomError)
+	Commit(ctx context.Context, userId uint32, key string) *helpers.CustomError
+	Complete(ctx context.Context, userId uint32, key string, responseCode int, responseBody []byte) *helpers.CustomError
+	Abandon(ctx context.Context, userId uint32, key string) *helpers.CustomError
+}
+
+type idempotencyService struct {
+	idempotencyRepo model.IdempotencyRepository
+	ttl             time.Duration
+}
+
+func NewIdempotencyService(idempotencyRepo model.IdempotencyRepository, ttl time.Duration) IdempotencyService {
+	return &idempotencyService{
+		idempotencyRepo: idempotencyRepo,
+		ttl:             ttl,
+	}
+}
+
+// Begin claims the key for a request. It returns the stored record when the request
+// was already answered and should be replayed, or nil when the caller has to run it
+func (s *idempotencyService) Begin(ctx context.Context, userId uint32, key string, requestHash string) (*model.IdempotencyRecord, *helpers.CustomError) {
+	for attempt := 0; attempt < 2; attempt++ {
+		now := time.Now()
+		reserved, err := s.idempotencyRepo.ReserveRecord(ctx, model.IdempotencyRecord{
+			UserId:      userId,
+			Key:         key,
+			RequestHash: requestHash,
+			Status:      model.IDEMPOTENCY_STATUS_IN_PROGRESS,
+			CreatedAt:   now,
+			ExpiresAt:   now.Add(s.ttl),
+		})
+		if err != nil {
+			return nil, err
+		}
+		if reserved {
+			return nil, nil
+		}
+		existing, err := s.idempotencyRepo.GetRecord(ctx, userId, key)
+		if err != nil {
+			return nil, err
+		}
+		if existing == nil {
+			continue
+		}
+		// mongo only sweeps expired records every minute, and a crashed request must not block its key
+		expired := now.After(existing.ExpiresAt)
+		stuck := existing.Status == model.IDEMPOTENCY_STATUS_IN_PROGRESS && now.Sub(existing.CreatedAt) > idempotencyLockTimeout
+		if expired || stuck {
+			err = s.idempotencyRepo.DeleteRecord(ctx, existing.ID)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if existing.RequestHash != requestHash {
+			return nil, helpers.BadRequest("Idempotency-Key was already used for a different request")
+		}
+		if existing.Status == model.IDEMPOTENCY_STATUS_IN_PROGRESS {
+			return nil, helpers.Conflict("A request with this Idempotency-Key is still being processed")
+		}
+		// its writes went through but the response was lost, running it again would repeat them
+		if existing.Status == model.IDEMPOTENCY_STATUS_COMMITTED {
+			return nil, helpers.Conflict("A request with this Idempotency-Key was already processed")
+		}
+		return existing, nil
+	}
+	return nil, helpers.Conflict("A request with this Idempotency-Key is still being processed")
+}
+
+// Commit is called with the transaction of the request, so its writes and the key are
+// committed together. A committed key is never taken over as stuck, and a request whose
+// key was taken over meanwhile fails instead of running twice. Requests without a key
+// are not tracked.
+func (s *idempotencyService) Commit(ctx context.Context, userId uint32, key string) *helpers.CustomError {
+	if key == "" {
+		return nil
+	}
+	committed, err := s.idempotencyRepo.CommitRecord(ctx, userId, key)
+	if err != nil {
+		return err
+	}
+	if !committed {
+		return helpers.Conflict("A request with this Idempotency-Key was already processed")
+	}
+	return nil
+}
+
+func (s *idempotencyService) Complete(ctx context.Context, userId uint32, key string, responseCode int, responseBody []byte) *helpers.CustomError {
+	return s.idempotencyRepo.CompleteRecord(ctx, userId, key, responseCode, responseBody)
+}
+
+// releases the key after a failure that is worth retrying
+func (s *idempotencyService) Abandon(ctx context.Context, userId uint32, key string) *helpers.CustomError {
+	return s.idempotencyRepo.DeleteInProgressRecord(ctx, userId, key)
+}
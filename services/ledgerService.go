@@ -0,0 +1,224 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/ChronoPlay/chronoplay-backend-service/dto"
+	"github.com/ChronoPlay/chronoplay-backend-service/helpers"
+	model "github.com/ChronoPlay/chronoplay-backend-service/model"
+	"github.com/ChronoPlay/chronoplay-backend-service/utils"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type LedgerService interface {
+	RecordMovements(ctx context.Context, movements []model.LedgerMovement) *helpers.CustomError
+	CloseAccount(ctx context.Context, account uint32, kind string) *helpers.CustomError
+	ReconcileUserBalance(ctx context.Context, req dto.ReconcileBalanceRequest) (dto.ReconcileBalanceResponse, *helpers.CustomError)
+}
+
+type ledgerService struct {
+	ledgerRepo model.LedgerRepository
+	userRepo   model.UserRepository
+	unitOfWork UnitOfWork
+}
+
+func NewLedgerService(ledgerRepo model.LedgerRepository, userRepo model.UserRepository, unitOfWork UnitOfWork) LedgerService {
+	return &ledgerService{
+		ledgerRepo: ledgerRepo,
+		userRepo:   userRepo,
+		unitOfWork: unitOfWork,
+	}
+}
+
+// writes the debit and credit postings of every movement as one batch, empty
+// movements are skipped
+func (s *ledgerService) RecordMovements(ctx context.Context, movements []model.LedgerMovement) *helpers.CustomError {
+	postings := []model.LedgerPosting{}
+	for _, movement := range movements {
+		if movement.Amount <= 0 || movement.From == movement.To {
+			continue
+		}
+		postings = append(postings, movement.Postings()...)
+	}
+	return s.ledgerRepo.AddPostings(ctx, postings)
+}
+
+// moves everything the ledger says the account holds back to the system account,
+// used when a user is wiped
+func (s *ledgerService) CloseAccount(ctx context.Context, account uint32, kind string) *helpers.CustomError {
+	balances, err := s.ledgerRepo.GetBalances(ctx, account)
+	if err != nil {
+		return err
+	}
+	movements := []model.LedgerMovement{}
+	for asset, balance := range balances {
+		movement := model.LedgerMovement{
+			Kind:   kind,
+			From:   account,
+			To:     model.LEDGER_ACCOUNT_SYSTEM,
+			Asset:  asset,
+			Amount: balance,
+		}
+		if balance < 0 {
+			movement.From, movement.To, movement.Amount = model.LEDGER_ACCOUNT_SYSTEM, account, -balance
+		}
+		movements = append(movements, movement)
+	}
+	return s.RecordMovements(ctx, movements)
+}
+
+// rebuilds the balances of a user from the ledger and compares them with what is
+// stored on the user document. Locked collateral and assets held for pending exchanges
+// are still owned by the user, so cash is compared as free + held and a card as
+// free + locked + held. With Apply the free balances are overwritten with the ledger
+// ones minus what is locked or held.
+func (s *ledgerService) ReconcileUserBalance(ctx context.Context, req dto.ReconcileBalanceRequest) (resp dto.ReconcileBalanceResponse, err *helpers.CustomError) {
+	// the ledger and the user are read from one snapshot and the user, its cards and
+	// the card owners are written together
+	err = s.unitOfWork.Run(ctx, func(sessCtx mongo.SessionContext) *helpers.CustomError {
+		resp, err = s.reconcileUserBalance(sessCtx, req)
+		return err
+	})
+	return resp, err
+}
+
+func (s *ledgerService) reconcileUserBalance(ctx context.Context, req dto.ReconcileBalanceRequest) (resp dto.ReconcileBalanceResponse, err *helpers.CustomError) {
+	err = utils.ValidateReconcileBalanceRequest(req)
+	if err != nil {
+		return resp, err
+	}
+	users, err := s.userRepo.GetUsers(ctx, model.User{UserId: req.UserId})
+	if err != nil {
+		return resp, err
+	}
+	if len(users) == 0 {
+		return resp, helpers.NotFound("User not found")
+	}
+	if !utils.IsAdmin(users[0].UserType) {
+		return resp, helpers.Unauthorized("Only admin can reconcile balances")
+	}
+	users, err = s.userRepo.GetUsers(ctx, model.User{UserId: req.TargetUserId})
+	if err != nil {
+		return resp, err
+	}
+	if len(users) == 0 {
+		return resp, helpers.NotFound("User not found")
+	}
+	user := users[0]
+
+	ledgerBalances, err := s.ledgerRepo.GetBalances(ctx, user.UserId)
+	if err != nil {
+		return resp, err
+	}
+	storedBalances := map[string]int64{
+		model.LEDGER_ASSET_CASH: int64(user.Cash) + int64(user.HeldCash),
+	}
+	lockedCards := make(map[string]uint32)
+	for _, card := range user.Cards {
+		storedBalances[card.CardNumber] += int64(card.Occupied) + int64(card.Locked) + int64(card.Held)
+		lockedCards[card.CardNumber] += card.Locked + card.Held
+	}
+
+	assets := []string{}
+	seen := make(map[string]bool)
+	for asset := range storedBalances {
+		assets = append(assets, asset)
+		seen[asset] = true
+	}
+	for asset := range ledgerBalances {
+		if !seen[asset] {
+			assets = append(assets, asset)
+		}
+	}
+	sort.Strings(assets)
+
+	resp = dto.ReconcileBalanceResponse{
+		UserId:        user.UserId,
+		Cash:          model.Money(ledgerBalances[model.LEDGER_ASSET_CASH]),
+		Cards:         []dto.Card{},
+		Discrepancies: []dto.BalanceDiscrepancy{},
+	}
+	for _, asset := range assets {
+		ledgerBalance := ledgerBalances[asset]
+		if asset != model.LEDGER_ASSET_CASH && ledgerBalance > 0 {
+			resp.Cards = append(resp.Cards, dto.Card{
+				CardNumber: asset,
+				Amount:     uint32(ledgerBalance),
+			})
+		}
+		if ledgerBalance != storedBalances[asset] {
+			resp.Discrepancies = append(resp.Discrepancies, dto.BalanceDiscrepancy{
+				Asset:      asset,
+				Stored:     storedBalances[asset],
+				Ledger:     ledgerBalance,
+				Difference: ledgerBalance - storedBalances[asset],
+			})
+		}
+	}
+	if len(resp.Discrepancies) > 0 {
+		log.Printf("Ledger reconciliation found %d discrepancies for user %d", len(resp.Discrepancies), user.UserId)
+	}
+	if !req.Apply || len(resp.Discrepancies) == 0 {
+		return resp, nil
+	}
+
+	if resp.Cash < user.HeldCash {
+		return resp, helpers.BadRequest("Ledger cash balance is below the held cash, cannot apply it")
+	}
+	balances := make(map[string]uint32)
+	for _, asset := range assets {
+		if asset == model.LEDGER_ASSET_CASH {
+			continue
+		}
+		ledgerBalance := ledgerBalances[asset]
+		if ledgerBalance < int64(lockedCards[asset]) {
+			return resp, helpers.BadRequest(fmt.Sprintf("Ledger balance of card %s is below its locked and held quantity, cannot apply it", asset))
+		}
+		balances[asset] = uint32(ledgerBalance - int64(lockedCards[asset]))
+	}
+	user.Cash = resp.Cash - user.HeldCash
+	user.Cards = applyCardBalances(user.Cards, balances)
+	err = s.userRepo.UpdateUser(ctx, user)
+	if err != nil {
+		return resp, err
+	}
+	changedCards := []string{}
+	for _, discrepancy := range resp.Discrepancies {
+		if discrepancy.Asset != model.LEDGER_ASSET_CASH {
+			changedCards = append(changedCards, discrepancy.Asset)
+		}
+	}
+	err = s.userRepo.SyncCardOwners(ctx, user.UserId, changedCards)
+	if err != nil {
+		return resp, err
+	}
+	resp.Applied = true
+	log.Printf("Ledger balances applied to user %d", user.UserId)
+	return resp, nil
+}
+
+// writes the new free quantities back onto the user's cards while keeping locked and
+// held quantities intact, and drops cards the user no longer holds at all
+func applyCardBalances(cards []model.CardOccupied, balances map[string]uint32) []model.CardOccupied {
+	newCards := []model.CardOccupied{}
+	seen := make(map[string]bool)
+	for _, card := range cards {
+		card.Occupied = balances[card.CardNumber]
+		seen[card.CardNumber] = true
+		if card.Occupied > 0 || card.Locked > 0 || card.Held > 0 {
+			newCards = append(newCards, card)
+		}
+	}
+	for cardNumber, occupied := range balances {
+		if !seen[cardNumber] && occupied > 0 {
+			newCards = append(newCards, model.CardOccupied{
+				CardNumber: cardNumber,
+				Occupied:   occupied,
+			})
+		}
+	}
+	return newCards
+}
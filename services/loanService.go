@@ -1,16 +1,659 @@
 package service
 
-import model "github.com/ChronoPlay/chronoplay-backend-service/model"
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/ChronoPlay/chronoplay-backend-service/dto"
+	"github.com/ChronoPlay/chronoplay-backend-service/helpers"
+	"github.com/ChronoPlay/chronoplay-backend-service/mapper"
+	model "github.com/ChronoPlay/chronoplay-backend-service/model"
+	"github.com/ChronoPlay/chronoplay-backend-service/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
 
 type LoanService interface {
+	RequestLoan(ctx context.Context, req dto.RequestLoanRequest) (dto.RequestLoanResponse, *helpers.CustomError)
+	RespondLoan(ctx context.Context, req dto.RespondLoanRequest) *helpers.CustomError
+	GetLoans(ctx context.Context, req dto.GetLoansRequest) (dto.GetLoansResponse, *helpers.CustomError)
+	RepayLoan(ctx context.Context, req dto.RepayLoanRequest) *helpers.CustomError
+	AccrueDailyInterest(ctx context.Context, now time.Time) *helpers.CustomError
+	CloseLoansOfUser(ctx context.Context, user model.User) *helpers.CustomError
+	LiquidateOverdueLoans(ctx context.Context, now time.Time) *helpers.CustomError
 }
 
 type loanService struct {
-	loanRepo model.LoanRepository
+	loanRepo            model.LoanRepository
+	userRepo            model.UserRepository
+	transactionService  TransactionService
+	notificationService NotificationService
+	unitOfWork          UnitOfWork
 }
 
-func NewLoanService(loanRepo model.LoanRepository) LoanService {
+func NewLoanService(loanRepo model.LoanRepository, userRepo model.UserRepository, transactionService TransactionService, notificationService NotificationService, unitOfWork UnitOfWork) LoanService {
 	return &loanService{
-		loanRepo: loanRepo,
+		loanRepo:            loanRepo,
+		userRepo:            userRepo,
+		transactionService:  transactionService,
+		notificationService: notificationService,
+		unitOfWork:          unitOfWork,
+	}
+}
+
+func (s *loanService) RequestLoan(ctx context.Context, req dto.RequestLoanRequest) (resp dto.RequestLoanResponse, err *helpers.CustomError) {
+	err = s.unitOfWork.Run(ctx, func(sessCtx mongo.SessionContext) *helpers.CustomError {
+		resp, err = s.requestLoan(sessCtx, req)
+		return err
+	})
+	return resp, err
+}
+
+func (s *loanService) requestLoan(ctx context.Context, req dto.RequestLoanRequest) (resp dto.RequestLoanResponse, err *helpers.CustomError) {
+	err = utils.ValidateRequestLoanRequest(req)
+	if err != nil {
+		return resp, err
+	}
+	users, err := s.userRepo.GetUsers(ctx, model.User{UserId: req.UserId})
+	if err != nil {
+		return resp, err
+	}
+	if len(users) == 0 {
+		return resp, helpers.NotFound("User not found")
+	}
+	borrower := users[0]
+	users, err = s.userRepo.GetUsers(ctx, model.User{UserId: req.LenderId})
+	if err != nil {
+		return resp, err
+	}
+	if len(users) == 0 {
+		return resp, helpers.NotFound("Lender not found")
+	}
+	lender := users[0]
+	if lender.Deactivated {
+		return resp, helpers.BadRequest("Lender account is deactivated")
+	}
+
+	collateral := []model.CardOccupied{}
+	for _, card := range req.Collateral {
+		collateral = append(collateral, model.CardOccupied{
+			CardNumber: card.CardNumber,
+			Occupied:   card.Amount,
+		})
+	}
+	err = isCollateralAvailable(borrower, collateral)
+	if err != nil {
+		return resp, err
+	}
+	// locked right away, so the borrower can't trade it away before the lender answers
+	if len(collateral) > 0 {
+		err = s.lockCollateral(ctx, borrower.UserId, collateral)
+		if err != nil {
+			return resp, err
+		}
+	}
+
+	loanId, err := s.loanRepo.AddLoan(ctx, model.Loan{
+		Amount:       req.Amount,
+		LoanedBy:     lender.UserId,
+		LoanedTo:     borrower.UserId,
+		Rate:         req.Rate,
+		Status:       model.LOAN_STATUS_REQUESTED,
+		Collateral:   collateral,
+		DurationDays: req.DurationDays,
+	})
+	if err != nil {
+		return resp, err
+	}
+	log.Printf("Loan %d requested by user %d from user %d\n", loanId, borrower.UserId, lender.UserId)
+
+	err = s.notificationService.SendNotification(ctx, dto.SendNotificationRequest{
+		UserIds: []uint32{lender.UserId},
+		Title:   "Loan Requested",
+		Message: fmt.Sprintf("User %s has requested a loan of %s at a daily rate of %.2f%%.", borrower.UserName, req.Amount, req.Rate*100),
+	})
+	if err != nil {
+		return resp, err
+	}
+	return dto.RequestLoanResponse{LoanId: loanId}, nil
+}
+
+func (s *loanService) RespondLoan(ctx context.Context, req dto.RespondLoanRequest) *helpers.CustomError {
+	return s.unitOfWork.Run(ctx, func(sessCtx mongo.SessionContext) *helpers.CustomError {
+		return s.respondLoan(sessCtx, req)
+	})
+}
+
+func (s *loanService) respondLoan(ctx context.Context, req dto.RespondLoanRequest) *helpers.CustomError {
+	err := utils.ValidateRespondLoanRequest(req)
+	if err != nil {
+		return err
+	}
+	loan, err := s.loanRepo.GetLoanByLoanId(ctx, req.LoanId)
+	if err != nil {
+		return err
+	}
+	if loan.LoanedBy != req.UserId {
+		return helpers.Unauthorized("Only the lender can respond to this loan")
+	}
+	if loan.Status != model.LOAN_STATUS_REQUESTED {
+		return helpers.BadRequest("Loan is not in requested state")
+	}
+
+	if !req.IsAccepted {
+		err = s.declineLoan(ctx, *loan)
+		if err != nil {
+			return err
+		}
+		return s.notificationService.SendNotification(ctx, dto.SendNotificationRequest{
+			UserIds: []uint32{loan.LoanedTo},
+			Title:   "Loan Declined",
+			Message: fmt.Sprintf("Your loan request #%d of %s was declined.", loan.LoanId, loan.Amount),
+		})
+	}
+
+	// lender pays the borrower through the regular cash ledger
+	err = s.transactionService.TransferCash(ctx, dto.TransferCashRequest{
+		Amount:  loan.Amount,
+		GivenBy: loan.LoanedBy,
+		GivenTo: loan.LoanedTo,
+		Status:  model.TRANSACTION_STATUS_SUCCESS,
+		UserId:  req.UserId,
+		LoanId:  loan.LoanId,
+	})
+	if err != nil {
+		return err
+	}
+	loan.Status = model.LOAN_STATUS_ACTIVE
+	if loan.DurationDays > 0 {
+		loan.DueDate = primitive.NewDateTimeFromTime(time.Now().AddDate(0, 0, int(loan.DurationDays)))
+	}
+	// interest starts compounding from the next day
+	loan.LastAccruedOn = time.Now().UTC().Format(model.LOAN_ACCRUAL_DATE_FORMAT)
+	err = s.loanRepo.UpdateLoan(ctx, *loan)
+	if err != nil {
+		return err
+	}
+	return s.notificationService.SendNotification(ctx, dto.SendNotificationRequest{
+		UserIds: []uint32{loan.LoanedTo},
+		Title:   "Loan Accepted",
+		Message: fmt.Sprintf("Your loan request #%d was accepted and %s has been credited to your account.", loan.LoanId, loan.Amount),
+	})
+}
+
+func (s *loanService) GetLoans(ctx context.Context, req dto.GetLoansRequest) (resp dto.GetLoansResponse, err *helpers.CustomError) {
+	err = utils.ValidateGetLoansRequest(req)
+	if err != nil {
+		return resp, err
+	}
+	loans, err := s.loanRepo.GetLoansByUserId(ctx, req.UserId)
+	if err != nil {
+		return resp, err
+	}
+	filteredLoans := []model.Loan{}
+	for _, loan := range loans {
+		if req.Role == model.LOAN_ROLE_LENDER && loan.LoanedBy != req.UserId {
+			continue
+		}
+		if req.Role == model.LOAN_ROLE_BORROWER && loan.LoanedTo != req.UserId {
+			continue
+		}
+		filteredLoans = append(filteredLoans, loan)
+	}
+	resp.Loans = mapper.MapLoansToResponse(filteredLoans)
+	return resp, nil
+}
+
+func (s *loanService) RepayLoan(ctx context.Context, req dto.RepayLoanRequest) *helpers.CustomError {
+	return s.unitOfWork.Run(ctx, func(sessCtx mongo.SessionContext) *helpers.CustomError {
+		return s.repayLoan(sessCtx, req)
+	})
+}
+
+func (s *loanService) repayLoan(ctx context.Context, req dto.RepayLoanRequest) *helpers.CustomError {
+	err := utils.ValidateRepayLoanRequest(req)
+	if err != nil {
+		return err
+	}
+	loan, err := s.loanRepo.GetLoanByLoanId(ctx, req.LoanId)
+	if err != nil {
+		return err
+	}
+	if loan.LoanedTo != req.UserId {
+		return helpers.Unauthorized("Only the borrower can repay this loan")
+	}
+	if loan.Status != model.LOAN_STATUS_ACTIVE {
+		return helpers.BadRequest("Loan is not active")
+	}
+	outstanding := loan.Outstanding()
+	if req.Amount > outstanding {
+		log.Printf("Repayment of %s for loan %d exceeds outstanding %s, capping\n", req.Amount, loan.LoanId, outstanding)
+		req.Amount = outstanding
+	}
+
+	err = s.transactionService.TransferCash(ctx, dto.TransferCashRequest{
+		Amount:  req.Amount,
+		GivenBy: loan.LoanedTo,
+		GivenTo: loan.LoanedBy,
+		Status:  model.TRANSACTION_STATUS_SUCCESS,
+		UserId:  req.UserId,
+		LoanId:  loan.LoanId,
+	})
+	if err != nil {
+		return err
+	}
+	loan.PaidAmount += req.Amount
+	if loan.Outstanding() == 0 {
+		loan.IsPaid = true
+		loan.Status = model.LOAN_STATUS_PAID
+		if len(loan.Collateral) > 0 {
+			err = s.releaseCollateral(ctx, loan.LoanedTo, loan.Collateral)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	err = s.loanRepo.UpdateLoan(ctx, *loan)
+	if err != nil {
+		return err
+	}
+
+	message := fmt.Sprintf("User %d repaid %s towards loan #%d. Remaining: %s", loan.LoanedTo, req.Amount, loan.LoanId, loan.Outstanding())
+	if loan.IsPaid {
+		message = fmt.Sprintf("Loan #%d has been fully repaid.", loan.LoanId)
+	}
+	return s.notificationService.SendNotification(ctx, dto.SendNotificationRequest{
+		UserIds: []uint32{loan.LoanedBy},
+		Title:   "Loan Repayment",
+		Message: message,
+	})
+}
+
+func (s *loanService) AccrueDailyInterest(ctx context.Context, now time.Time) *helpers.CustomError {
+	today := now.UTC().Format(model.LOAN_ACCRUAL_DATE_FORMAT)
+	loans, err := s.loanRepo.GetLoansByStatus(ctx, model.LOAN_STATUS_ACTIVE)
+	if err != nil {
+		return err
+	}
+	for _, loan := range loans {
+		interest, perr := dueInterest(loan, today)
+		if perr != nil {
+			log.Printf("Invalid last accrual date %q for loan %d: %v", loan.LastAccruedOn, loan.LoanId, perr)
+			continue
+		}
+		if interest <= 0 {
+			continue
+		}
+		applied, err := s.loanRepo.AccrueInterest(ctx, loan.LoanId, loan.LastAccruedOn, today, interest)
+		if err != nil {
+			log.Printf("Error accruing interest for loan %d: %v", loan.LoanId, err)
+			continue
+		}
+		if !applied {
+			log.Printf("Interest for loan %d already accrued for %s, skipping", loan.LoanId, today)
+			continue
+		}
+		err = s.notificationService.SendNotification(ctx, dto.SendNotificationRequest{
+			UserIds: []uint32{loan.LoanedTo},
+			Title:   "Loan Interest Accrued",
+			Message: fmt.Sprintf("Interest of %s has been added to loan #%d. Outstanding amount: %s", interest, loan.LoanId, loan.Outstanding()+interest),
+		})
+		if err != nil {
+			log.Printf("Error sending interest notification for loan %d: %v", loan.LoanId, err)
+		}
+	}
+	return nil
+}
+
+// interest owed on the loan for every day since it was last accrued up to today, compounded
+// daily. A loan that never accrued owes one day, one already accrued today owes nothing.
+func dueInterest(loan model.Loan, today string) (model.Money, error) {
+	days := 1
+	if loan.LastAccruedOn != "" {
+		lastAccrued, err := time.Parse(model.LOAN_ACCRUAL_DATE_FORMAT, loan.LastAccruedOn)
+		if err != nil {
+			return 0, err
+		}
+		current, err := time.Parse(model.LOAN_ACCRUAL_DATE_FORMAT, today)
+		if err != nil {
+			return 0, err
+		}
+		// catch up on days missed while the job was not running
+		days = int(current.Sub(lastAccrued).Hours() / 24)
+	}
+	if days <= 0 || loan.Rate == 0 {
+		return 0, nil
+	}
+	return loan.Outstanding().MulRate(math.Pow(1+float64(loan.Rate), float64(days)) - 1), nil
+}
+
+// called before a user gets wiped by the survival tax. Their pending transactions are
+// failed first, so everything they hold for exchanges and trades is free again. Loans
+// they gave are forgiven and the borrowers get their collateral back. Whatever the user
+// still holds is then seized pro rata to each of their lenders' outstanding amount and
+// those loans are closed as defaulted. Open loan requests of the user are declined.
+func (s *loanService) CloseLoansOfUser(ctx context.Context, user model.User) *helpers.CustomError {
+	return s.unitOfWork.Run(ctx, func(sessCtx mongo.SessionContext) *helpers.CustomError {
+		return s.closeLoansOfUser(sessCtx, user)
+	})
+}
+
+func (s *loanService) closeLoansOfUser(ctx context.Context, borrower model.User) *helpers.CustomError {
+	err := s.transactionService.FailPendingTransactionsOfUser(ctx, borrower.UserId)
+	if err != nil {
+		return err
+	}
+	loans, err := s.loanRepo.GetLoansByUserId(ctx, borrower.UserId)
+	if err != nil {
+		return err
+	}
+	for _, loan := range loans {
+		if loan.Status == model.LOAN_STATUS_REQUESTED {
+			err = s.declineLoan(ctx, loan)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	// balances may have moved since the caller read the borrower
+	users, err := s.userRepo.GetUsers(ctx, model.User{UserId: borrower.UserId})
+	if err != nil {
+		return err
+	}
+	if len(users) == 0 {
+		return helpers.NotFound("Borrower not found")
+	}
+	borrower = users[0]
+	activeLoans := []model.Loan{}
+	for _, loan := range loans {
+		if loan.Status == model.LOAN_STATUS_ACTIVE && loan.LoanedBy == borrower.UserId {
+			err = s.forgiveLoan(ctx, loan, borrower)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if loan.Status == model.LOAN_STATUS_ACTIVE && loan.LoanedTo == borrower.UserId && loan.Outstanding() > 0 {
+			activeLoans = append(activeLoans, loan)
+		}
+	}
+	if len(activeLoans) == 0 {
+		return nil
+	}
+
+	totalOutstanding := model.Money(0)
+	for _, loan := range activeLoans {
+		totalOutstanding += loan.Outstanding()
+	}
+	cashToSeize := borrower.Cash
+	if cashToSeize > totalOutstanding {
+		cashToSeize = totalOutstanding
+	}
+	cashShares := splitCashProRata(cashToSeize, activeLoans, totalOutstanding)
+	cardShares := splitCardsProRata(borrower.Cards, activeLoans, totalOutstanding)
+
+	for i, loan := range activeLoans {
+		// collateral belongs to its own lender before anything is shared
+		recoveredCards := []model.CardOccupied{}
+		if len(loan.Collateral) > 0 {
+			err = s.seizeCollateral(ctx, loan)
+			if err != nil {
+				return err
+			}
+			recoveredCards = append(recoveredCards, loan.Collateral...)
+		}
+		if cashShares[i] > 0 {
+			err = s.transactionService.TransferCash(ctx, dto.TransferCashRequest{
+				Amount:  cashShares[i],
+				GivenBy: borrower.UserId,
+				GivenTo: loan.LoanedBy,
+				Status:  model.TRANSACTION_STATUS_SUCCESS,
+				UserId:  borrower.UserId,
+				LoanId:  loan.LoanId,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		cardsToTransfer := []dto.TransferCard{}
+		for _, card := range cardShares[i] {
+			recoveredCards = append(recoveredCards, card)
+			cardsToTransfer = append(cardsToTransfer, dto.TransferCard{
+				CardNumber: card.CardNumber,
+				Amount:     card.Occupied,
+			})
+		}
+		if len(cardsToTransfer) > 0 {
+			err = s.transactionService.TransferCards(ctx, dto.TransferCardRequest{
+				Cards:   cardsToTransfer,
+				GivenBy: borrower.UserId,
+				GivenTo: loan.LoanedBy,
+				Status:  model.TRANSACTION_STATUS_SUCCESS,
+				UserId:  borrower.UserId,
+				LoanId:  loan.LoanId,
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		loan.PaidAmount += cashShares[i]
+		loan.Status = model.LOAN_STATUS_DEFAULTED
+		loan.Recovery = &model.LoanRecovery{
+			Cash:        cashShares[i],
+			Cards:       recoveredCards,
+			DefaultedAt: primitive.NewDateTimeFromTime(time.Now()),
+		}
+		err = s.loanRepo.UpdateLoan(ctx, loan)
+		if err != nil {
+			return err
+		}
+		log.Printf("Loan %d defaulted, recovered cash %s and %d card types for lender %d", loan.LoanId, cashShares[i], len(recoveredCards), loan.LoanedBy)
+
+		err = s.notificationService.SendNotification(ctx, dto.SendNotificationRequest{
+			UserIds: []uint32{loan.LoanedBy},
+			Title:   "Loan Defaulted",
+			Message: fmt.Sprintf("User %s could not survive and defaulted on loan #%d. Recovered %s cash and %d cards. Unrecovered amount: %s", borrower.UserName, loan.LoanId, cashShares[i], countCards(recoveredCards), loan.Outstanding()),
+		})
+		if err != nil {
+			log.Printf("Error sending default notification for loan %d: %v", loan.LoanId, err)
+		}
+	}
+	return nil
+}
+
+// gives the collateral locked with the request back to the borrower
+func (s *loanService) declineLoan(ctx context.Context, loan model.Loan) *helpers.CustomError {
+	if len(loan.Collateral) > 0 {
+		err := s.releaseCollateral(ctx, loan.LoanedTo, loan.Collateral)
+		if err != nil {
+			return err
+		}
+	}
+	loan.Status = model.LOAN_STATUS_DECLINED
+	return s.loanRepo.UpdateLoan(ctx, loan)
+}
+
+// the lender is leaving the game, so the borrower owes nothing anymore and gets the
+// collateral back
+func (s *loanService) forgiveLoan(ctx context.Context, loan model.Loan, lender model.User) *helpers.CustomError {
+	if len(loan.Collateral) > 0 {
+		err := s.releaseCollateral(ctx, loan.LoanedTo, loan.Collateral)
+		if err != nil {
+			return err
+		}
+	}
+	outstanding := loan.Outstanding()
+	loan.Status = model.LOAN_STATUS_FORGIVEN
+	err := s.loanRepo.UpdateLoan(ctx, loan)
+	if err != nil {
+		return err
+	}
+	log.Printf("Loan %d forgiven, lender %d was deactivated with %s outstanding", loan.LoanId, lender.UserId, outstanding)
+
+	err = s.notificationService.SendNotification(ctx, dto.SendNotificationRequest{
+		UserIds: []uint32{loan.LoanedTo},
+		Title:   "Loan Forgiven",
+		Message: fmt.Sprintf("User %s could not survive, so loan #%d is forgiven. The outstanding %s no longer has to be repaid and %d collateral cards have been unlocked.", lender.UserName, loan.LoanId, outstanding, countCards(loan.Collateral)),
+	})
+	if err != nil {
+		log.Printf("Error sending forgiveness notification for loan %d: %v", loan.LoanId, err)
+	}
+	return nil
+}
+
+// last loan takes whatever is left so rounding never loses cash
+func splitCashProRata(cash model.Money, loans []model.Loan, totalOutstanding model.Money) []model.Money {
+	shares := make([]model.Money, len(loans))
+	remaining := cash
+	for i, loan := range loans {
+		if i == len(loans)-1 {
+			shares[i] = remaining
+			break
+		}
+		shares[i] = cash.MulDiv(loan.Outstanding(), totalOutstanding)
+		remaining -= shares[i]
+	}
+	return shares
+}
+
+// each card type is split with the largest remainder method so every card ends up with some lender
+func splitCardsProRata(cards []model.CardOccupied, loans []model.Loan, totalOutstanding model.Money) [][]model.CardOccupied {
+	shares := make([][]model.CardOccupied, len(loans))
+	for _, card := range cards {
+		if card.Occupied == 0 {
+			continue
+		}
+		quantities := make([]uint32, len(loans))
+		remainders := make([]float64, len(loans))
+		distributed := uint32(0)
+		for i, loan := range loans {
+			exact := float64(card.Occupied) * float64(loan.Outstanding()) / float64(totalOutstanding)
+			quantities[i] = uint32(math.Floor(exact))
+			remainders[i] = exact - math.Floor(exact)
+			distributed += quantities[i]
+		}
+		order := make([]int, len(loans))
+		for i := range order {
+			order[i] = i
+		}
+		sort.SliceStable(order, func(a, b int) bool {
+			return remainders[order[a]] > remainders[order[b]]
+		})
+		for i := 0; distributed < card.Occupied; i++ {
+			quantities[order[i%len(order)]]++
+			distributed++
+		}
+		for i, quantity := range quantities {
+			if quantity > 0 {
+				shares[i] = append(shares[i], model.CardOccupied{
+					CardNumber: card.CardNumber,
+					Occupied:   quantity,
+				})
+			}
+		}
+	}
+	return shares
+}
+
+func countCards(cards []model.CardOccupied) uint32 {
+	total := uint32(0)
+	for _, card := range cards {
+		total += card.Occupied
+	}
+	return total
+}
+
+// overdue loans backed by collateral are closed by handing the collateral to the lender
+func (s *loanService) LiquidateOverdueLoans(ctx context.Context, now time.Time) *helpers.CustomError {
+	loans, err := s.loanRepo.GetOverdueCollateralizedLoans(ctx, now)
+	if err != nil {
+		return err
+	}
+	for _, loan := range loans {
+		err = s.unitOfWork.Run(ctx, func(sessCtx mongo.SessionContext) *helpers.CustomError {
+			err := s.seizeCollateral(sessCtx, loan)
+			if err != nil {
+				return err
+			}
+			loan.Status = model.LOAN_STATUS_LIQUIDATED
+			loan.Recovery = &model.LoanRecovery{
+				Cards:       loan.Collateral,
+				DefaultedAt: primitive.NewDateTimeFromTime(now),
+			}
+			return s.loanRepo.UpdateLoan(sessCtx, loan)
+		})
+		if err != nil {
+			log.Printf("Error liquidating loan %d: %v", loan.LoanId, err)
+			continue
+		}
+		log.Printf("Loan %d liquidated, %d collateral cards moved to lender %d", loan.LoanId, countCards(loan.Collateral), loan.LoanedBy)
+
+		err = s.notificationService.SendNotification(ctx, dto.SendNotificationRequest{
+			UserIds: []uint32{loan.LoanedBy},
+			Title:   "Loan Liquidated",
+			Message: fmt.Sprintf("Loan #%d was not repaid by its due date. %d collateral cards have been transferred to you.", loan.LoanId, countCards(loan.Collateral)),
+		})
+		if err != nil {
+			log.Printf("Error sending liquidation notification for loan %d: %v", loan.LoanId, err)
+		}
+		err = s.notificationService.SendNotification(ctx, dto.SendNotificationRequest{
+			UserIds: []uint32{loan.LoanedTo},
+			Title:   "Loan Liquidated",
+			Message: fmt.Sprintf("Loan #%d passed its due date unpaid. Your collateral of %d cards has been transferred to the lender.", loan.LoanId, countCards(loan.Collateral)),
+		})
+		if err != nil {
+			log.Printf("Error sending liquidation notification for loan %d: %v", loan.LoanId, err)
+		}
+	}
+	return nil
+}
+
+func isCollateralAvailable(borrower model.User, collateral []model.CardOccupied) *helpers.CustomError {
+	occupiedMap := make(map[string]uint32)
+	for _, card := range borrower.Cards {
+		occupiedMap[card.CardNumber] = card.Occupied
+	}
+	for _, card := range collateral {
+		if occupiedMap[card.CardNumber] < card.Occupied {
+			return helpers.BadRequest("Insufficient card balance for collateral card: " + card.CardNumber)
+		}
+	}
+	return nil
+}
+
+// moves collateral out of the borrower's free cards so it can't be traded while the loan is requested or open
+func (s *loanService) lockCollateral(ctx context.Context, borrowerId uint32, collateral []model.CardOccupied) *helpers.CustomError {
+	return s.userRepo.LockCards(ctx, borrowerId, collateral)
+}
+
+func (s *loanService) releaseCollateral(ctx context.Context, borrowerId uint32, collateral []model.CardOccupied) *helpers.CustomError {
+	return s.userRepo.UnlockCards(ctx, borrowerId, collateral)
+}
+
+// unlocks the collateral and transfers it from the borrower to the lender
+func (s *loanService) seizeCollateral(ctx context.Context, loan model.Loan) *helpers.CustomError {
+	err := s.releaseCollateral(ctx, loan.LoanedTo, loan.Collateral)
+	if err != nil {
+		return err
+	}
+	cardsToTransfer := []dto.TransferCard{}
+	for _, card := range loan.Collateral {
+		cardsToTransfer = append(cardsToTransfer, dto.TransferCard{
+			CardNumber: card.CardNumber,
+			Amount:     card.Occupied,
+		})
 	}
+	return s.transactionService.TransferCards(ctx, dto.TransferCardRequest{
+		Cards:   cardsToTransfer,
+		GivenBy: loan.LoanedTo,
+		GivenTo: loan.LoanedBy,
+		Status:  model.TRANSACTION_STATUS_SUCCESS,
+		UserId:  loan.LoanedTo,
+		LoanId:  loan.LoanId,
+	})
 }
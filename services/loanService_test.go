@@ -0,0 +1,145 @@
+package service
+
+import (
+	"testing"
+
+	model "github.com/ChronoPlay/chronoplay-backend-service/model"
+)
+
+func loansWithOutstanding(amounts ...int64) ([]model.Loan, model.Money) {
+	loans := make([]model.Loan, len(amounts))
+	total := model.Money(0)
+	for i, amount := range amounts {
+		loans[i] = model.Loan{LoanId: uint32(i + 1), Amount: model.Money(amount)}
+		total += loans[i].Outstanding()
+	}
+	return loans, total
+}
+
+func TestSplitCashProRata(t *testing.T) {
+	cases := []struct {
+		name        string
+		cash        model.Money
+		outstanding []int64
+		want        []model.Money
+	}{
+		{name: "single loan", cash: 1234, outstanding: []int64{5000}, want: []model.Money{1234}},
+		{name: "equal outstanding", cash: 900, outstanding: []int64{100, 100, 100}, want: []model.Money{300, 300, 300}},
+		{name: "odd remainder goes to the last loan", cash: 1000, outstanding: []int64{100, 100, 100}, want: []model.Money{333, 333, 334}},
+		{name: "proportional", cash: 1000, outstanding: []int64{300, 100}, want: []model.Money{750, 250}},
+		{name: "uneven with remainder", cash: 101, outstanding: []int64{2, 3, 5}, want: []model.Money{20, 30, 51}},
+		{name: "nothing to seize", cash: 0, outstanding: []int64{100, 200}, want: []model.Money{0, 0}},
+	}
+	for _, c := range cases {
+		loans, total := loansWithOutstanding(c.outstanding...)
+		shares := splitCashProRata(c.cash, loans, total)
+		if len(shares) != len(loans) {
+			t.Fatalf("%s: got %d shares for %d loans", c.name, len(shares), len(loans))
+		}
+		sum := model.Money(0)
+		for i, share := range shares {
+			sum += share
+			if share != c.want[i] {
+				t.Errorf("%s: share %d = %d, want %d", c.name, i, share, c.want[i])
+			}
+		}
+		if sum != c.cash {
+			t.Errorf("%s: shares sum to %d, want %d", c.name, sum, c.cash)
+		}
+	}
+}
+
+func TestSplitCardsProRata(t *testing.T) {
+	cases := []struct {
+		name        string
+		cards       []model.CardOccupied
+		outstanding []int64
+	}{
+		{name: "single loan", cards: []model.CardOccupied{{CardNumber: "a", Occupied: 7}}, outstanding: []int64{100}},
+		{name: "equal outstanding", cards: []model.CardOccupied{{CardNumber: "a", Occupied: 9}}, outstanding: []int64{100, 100, 100}},
+		{name: "odd remainder", cards: []model.CardOccupied{{CardNumber: "a", Occupied: 10}, {CardNumber: "b", Occupied: 1}}, outstanding: []int64{100, 100, 100}},
+		{name: "uneven", cards: []model.CardOccupied{{CardNumber: "a", Occupied: 5}, {CardNumber: "b", Occupied: 13}}, outstanding: []int64{1, 2, 7}},
+		{name: "empty card is skipped", cards: []model.CardOccupied{{CardNumber: "a", Occupied: 0}, {CardNumber: "b", Occupied: 2}}, outstanding: []int64{50, 50}},
+	}
+	for _, c := range cases {
+		loans, total := loansWithOutstanding(c.outstanding...)
+		shares := splitCardsProRata(c.cards, loans, total)
+		if len(shares) != len(loans) {
+			t.Fatalf("%s: got %d shares for %d loans", c.name, len(shares), len(loans))
+		}
+		for _, card := range c.cards {
+			sum := uint32(0)
+			for i, share := range shares {
+				quantity := uint32(0)
+				for _, got := range share {
+					if got.CardNumber == card.CardNumber {
+						if got.Occupied == 0 {
+							t.Errorf("%s: loan %d got an empty share of %s", c.name, i, card.CardNumber)
+						}
+						quantity += got.Occupied
+					}
+				}
+				// largest remainder never moves a share by more than one card
+				exact := float64(card.Occupied) * float64(loans[i].Outstanding()) / float64(total)
+				if float64(quantity) < exact-1 || float64(quantity) > exact+1 {
+					t.Errorf("%s: loan %d got %d of %s, want about %.2f", c.name, i, quantity, card.CardNumber, exact)
+				}
+				sum += quantity
+			}
+			if sum != card.Occupied {
+				t.Errorf("%s: shares of %s sum to %d, want %d", c.name, card.CardNumber, sum, card.Occupied)
+			}
+		}
+	}
+
+	loans, total := loansWithOutstanding(100, 100, 100)
+	shares := splitCardsProRata([]model.CardOccupied{{CardNumber: "a", Occupied: 9}}, loans, total)
+	for i, share := range shares {
+		if len(share) != 1 || share[0].Occupied != 3 {
+			t.Errorf("equal outstanding: loan %d got %v, want 3 of a", i, share)
+		}
+	}
+}
+
+func TestDueInterest(t *testing.T) {
+	loan := model.Loan{Amount: model.NewMoney(100), Rate: 0.1}
+	cases := []struct {
+		name          string
+		lastAccruedOn string
+		today         string
+		want          model.Money
+	}{
+		{name: "never accrued", lastAccruedOn: "", today: "2026-03-10", want: 1000},
+		{name: "one day", lastAccruedOn: "2026-03-09", today: "2026-03-10", want: 1000},
+		{name: "same day rerun", lastAccruedOn: "2026-03-10", today: "2026-03-10", want: 0},
+		{name: "clock went back", lastAccruedOn: "2026-03-11", today: "2026-03-10", want: 0},
+		{name: "missed days compound", lastAccruedOn: "2026-03-07", today: "2026-03-10", want: 3310},
+		{name: "month boundary", lastAccruedOn: "2026-01-31", today: "2026-02-01", want: 1000},
+		{name: "end of february", lastAccruedOn: "2026-02-28", today: "2026-03-01", want: 1000},
+		{name: "leap day", lastAccruedOn: "2024-02-28", today: "2024-03-01", want: 2100},
+		{name: "year boundary", lastAccruedOn: "2025-12-30", today: "2026-01-01", want: 2100},
+	}
+	for _, c := range cases {
+		loan.LastAccruedOn = c.lastAccruedOn
+		got, err := dueInterest(loan, c.today)
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("%s: interest = %d, want %d", c.name, got, c.want)
+		}
+	}
+
+	// interest compounds on what is still owed, not on the original amount
+	repaid := model.Loan{Amount: model.NewMoney(100), InterestAccumulated: model.NewMoney(20), PaidAmount: model.NewMoney(70), Rate: 0.1, LastAccruedOn: "2026-03-09"}
+	if got, _ := dueInterest(repaid, "2026-03-10"); got != 500 {
+		t.Errorf("partly repaid: interest = %d, want 500", got)
+	}
+	if got, _ := dueInterest(model.Loan{Amount: model.NewMoney(100)}, "2026-03-10"); got != 0 {
+		t.Errorf("zero rate: interest = %d, want 0", got)
+	}
+	if _, err := dueInterest(model.Loan{Amount: model.NewMoney(100), Rate: 0.1, LastAccruedOn: "10/03/2026"}, "2026-03-10"); err == nil {
+		t.Errorf("invalid last accrual date: want an error")
+	}
+}
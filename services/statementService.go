@@ -0,0 +1,240 @@
+package service
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/ChronoPlay/chronoplay-backend-service/dto"
+	"github.com/ChronoPlay/chronoplay-backend-service/helpers"
+	model "github.com/ChronoPlay/chronoplay-backend-service/model"
+	"github.com/ChronoPlay/chronoplay-backend-service/utils"
+)
+
+type StatementService interface {
+	GetStatement(ctx context.Context, req dto.StatementRequest) (dto.Statement, *helpers.CustomError)
+	EmailStatement(ctx context.Context, req dto.StatementRequest) (dto.Statement, *helpers.CustomError)
+}
+
+type statementService struct {
+	ledgerRepo          model.LedgerRepository
+	userRepo            model.UserRepository
+	cardTransactionRepo model.CardTransactionRepository
+	cashTransactionRepo model.CashTransactionRepository
+}
+
+func NewStatementService(ledgerRepo model.LedgerRepository, userRepo model.UserRepository, cardTransactionRepo model.CardTransactionRepository, cashTransactionRepo model.CashTransactionRepository) StatementService {
+	return &statementService{
+		ledgerRepo:          ledgerRepo,
+		userRepo:            userRepo,
+		cardTransactionRepo: cardTransactionRepo,
+		cashTransactionRepo: cashTransactionRepo,
+	}
+}
+
+func (s *statementService) GetStatement(ctx context.Context, req dto.StatementRequest) (dto.Statement, *helpers.CustomError) {
+	statement, _, err := s.buildStatement(ctx, req)
+	return statement, err
+}
+
+// builds the statement and sends it as html to the email of the user it is about
+func (s *statementService) EmailStatement(ctx context.Context, req dto.StatementRequest) (dto.Statement, *helpers.CustomError) {
+	statement, user, err := s.buildStatement(ctx, req)
+	if err != nil {
+		return statement, err
+	}
+	if user.Email == "" {
+		return statement, helpers.BadRequest("User has no email address")
+	}
+	body, rerr := utils.RenderStatementHtml(statement)
+	if rerr != nil {
+		return statement, helpers.SystemError("Failed to render statement", rerr)
+	}
+	err = utils.SendEmail([]string{user.Email}, "ChronoPlay statement for "+statement.Month, body)
+	if err != nil {
+		return statement, err
+	}
+	return statement, nil
+}
+
+// Balances and movements come from the ledger, the only place every change of a
+// balance is written to, including the survival tax. The card and cash transactions
+// of the movements add the transaction type and the counterparty.
+func (s *statementService) buildStatement(ctx context.Context, req dto.StatementRequest) (statement dto.Statement, user model.User, err *helpers.CustomError) {
+	err = utils.ValidateStatementRequest(req)
+	if err != nil {
+		return statement, user, err
+	}
+	user, err = s.getStatementUser(ctx, req)
+	if err != nil {
+		return statement, user, err
+	}
+	from, _ := time.Parse(model.STATEMENT_MONTH_FORMAT, req.Month)
+	to := from.AddDate(0, 1, 0)
+	now := time.Now().UTC()
+	if !from.Before(now) {
+		return statement, user, helpers.BadRequest("Statement month has not started yet")
+	}
+	if to.After(now) {
+		to = now
+	}
+
+	balances, err := s.ledgerRepo.GetBalancesBefore(ctx, user.UserId, from)
+	if err != nil {
+		return statement, user, err
+	}
+	postings, err := s.ledgerRepo.GetPostings(ctx, user.UserId, from, to)
+	if err != nil {
+		return statement, user, err
+	}
+	legs, err := s.getStatementLegs(ctx, user.UserId, postings)
+	if err != nil {
+		return statement, user, err
+	}
+
+	statement = dto.Statement{
+		UserId:        user.UserId,
+		UserName:      user.UserName,
+		Month:         req.Month,
+		From:          from,
+		To:            to,
+		OpeningCash:   model.Money(balances[model.LEDGER_ASSET_CASH]),
+		OpeningCards:  statementCards(balances),
+		CashMovements: []dto.StatementCashMovement{},
+		CardMovements: []dto.StatementCardMovement{},
+	}
+	cash := statement.OpeningCash
+	for _, posting := range postings {
+		amount := posting.Credit - posting.Debit
+		balances[posting.Asset] += amount
+		leg := legs[statementLegKey{guid: posting.TransactionGuid, asset: posting.Asset, outgoing: amount < 0}]
+		if posting.Asset != model.LEDGER_ASSET_CASH {
+			statement.CardMovements = append(statement.CardMovements, dto.StatementCardMovement{
+				Time:            posting.CreatedAt.Time(),
+				TransactionGuid: posting.TransactionGuid,
+				Kind:            posting.Kind,
+				Type:            leg.transactionType,
+				Counterparty:    leg.counterparty,
+				CardNumber:      posting.Asset,
+				Quantity:        amount,
+			})
+			continue
+		}
+		cash += model.Money(amount)
+		if amount > 0 {
+			statement.CashIn += model.Money(amount)
+		} else {
+			statement.CashOut -= model.Money(amount)
+		}
+		if posting.Kind == model.LEDGER_KIND_SURVIVAL_TAX {
+			statement.SurvivalTax -= model.Money(amount)
+		}
+		statement.CashMovements = append(statement.CashMovements, dto.StatementCashMovement{
+			Time:            posting.CreatedAt.Time(),
+			TransactionGuid: posting.TransactionGuid,
+			Kind:            posting.Kind,
+			Type:            leg.transactionType,
+			Counterparty:    leg.counterparty,
+			Amount:          model.Money(amount),
+			Balance:         cash,
+		})
+	}
+	statement.ClosingCash = cash
+	statement.ClosingCards = statementCards(balances)
+	return statement, user, nil
+}
+
+// only admins can read the statement of another user
+func (s *statementService) getStatementUser(ctx context.Context, req dto.StatementRequest) (model.User, *helpers.CustomError) {
+	users, err := s.userRepo.GetUsers(ctx, model.User{UserId: req.UserId})
+	if err != nil {
+		return model.User{}, err
+	}
+	if len(users) == 0 {
+		return model.User{}, helpers.NotFound("User not found")
+	}
+	if req.TargetUserId == req.UserId {
+		return users[0], nil
+	}
+	if !utils.IsAdmin(users[0].UserType) {
+		return model.User{}, helpers.Unauthorized("Only admin can read the statement of another user")
+	}
+	users, err = s.userRepo.GetUsers(ctx, model.User{UserId: req.TargetUserId})
+	if err != nil {
+		return model.User{}, err
+	}
+	if len(users) == 0 {
+		return model.User{}, helpers.NotFound("User not found")
+	}
+	return users[0], nil
+}
+
+type statementLegKey struct {
+	guid     uint32
+	asset    string
+	outgoing bool
+}
+
+type statementLeg struct {
+	transactionType string
+	counterparty    uint32
+}
+
+// the transaction legs behind the postings, keyed by what moved and in which
+// direction. Postings without a transaction (tax, deactivation, opening balances)
+// have no leg and keep the system as counterparty.
+func (s *statementService) getStatementLegs(ctx context.Context, userId uint32, postings []model.LedgerPosting) (map[statementLegKey]statementLeg, *helpers.CustomError) {
+	legs := make(map[statementLegKey]statementLeg)
+	seen := make(map[uint32]bool)
+	guids := []uint32{}
+	for _, posting := range postings {
+		if posting.TransactionGuid != 0 && !seen[posting.TransactionGuid] {
+			seen[posting.TransactionGuid] = true
+			guids = append(guids, posting.TransactionGuid)
+		}
+	}
+	add := func(guid uint32, asset string, givenBy uint32, givenTo uint32, transactionType string) {
+		key := statementLegKey{guid: guid, asset: asset, outgoing: givenBy == userId}
+		if _, ok := legs[key]; ok {
+			return
+		}
+		leg := statementLeg{transactionType: transactionType, counterparty: givenBy}
+		if key.outgoing {
+			leg.counterparty = givenTo
+		}
+		legs[key] = leg
+	}
+	cardTransactions, err := s.cardTransactionRepo.GetCardTransactionsOfUser(ctx, userId, guids)
+	if err != nil {
+		return nil, err
+	}
+	for _, transaction := range cardTransactions {
+		add(transaction.TransactionGuid, transaction.CardNumber, transaction.GivenBy, transaction.GivenTo, transaction.Type)
+	}
+	cashTransactions, err := s.cashTransactionRepo.GetCashTransactionsOfUser(ctx, userId, guids)
+	if err != nil {
+		return nil, err
+	}
+	for _, transaction := range cashTransactions {
+		add(transaction.TransactionGuid, model.LEDGER_ASSET_CASH, transaction.GivenBy, transaction.GivenTo, transaction.Type)
+	}
+	return legs, nil
+}
+
+// the card balances out of ledger balances, sorted by card number
+func statementCards(balances map[string]int64) []dto.Card {
+	cards := []dto.Card{}
+	for asset, balance := range balances {
+		if asset == model.LEDGER_ASSET_CASH || balance <= 0 {
+			continue
+		}
+		cards = append(cards, dto.Card{
+			CardNumber: asset,
+			Amount:     uint32(balance),
+		})
+	}
+	sort.Slice(cards, func(i, j int) bool {
+		return cards[i].CardNumber < cards[j].CardNumber
+	})
+	return cards
+}
@@ -2,24 +2,36 @@ package service
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"sort"
+	"time"
 
 	"github.com/ChronoPlay/chronoplay-backend-service/dto"
 	"github.com/ChronoPlay/chronoplay-backend-service/helpers"
 	"github.com/ChronoPlay/chronoplay-backend-service/mapper"
 	model "github.com/ChronoPlay/chronoplay-backend-service/model"
 	"github.com/ChronoPlay/chronoplay-backend-service/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type TransactionService interface {
 	TransferCash(ctx context.Context, req dto.TransferCashRequest) *helpers.CustomError
 	TransferCards(ctx context.Context, req dto.TransferCardRequest) *helpers.CustomError
-	GiveCards(ctx context.Context, req dto.TransferCardRequest) *helpers.CustomError
+	GiveCards(ctx context.Context, req dto.GiveCardsRequest) (dto.GiveCardsResponse, *helpers.CustomError)
 	GetTransactions(ctx context.Context, req dto.GetTransactionsRequest) (dto.GetTransactionsResponse, *helpers.CustomError)
+	ExportTransactions(ctx context.Context, req dto.ExportTransactionsRequest, writer utils.TransactionExportWriter) *helpers.CustomError
 	Exchange(ctx context.Context, req dto.ExchangeRequest) *helpers.CustomError
 	GetPossibleExchange(ctx context.Context, req dto.GetPossibleExchangeRequest) (dto.GetPossibleExchangeResponse, *helpers.CustomError)
 	ExecuteExchange(ctx context.Context, req dto.ExecuteExchangeRequest) *helpers.CustomError
+	FailPendingTransactionsOfUser(ctx context.Context, userId uint32) *helpers.CustomError
+	RespondTransfer(ctx context.Context, req dto.RespondTransferRequest) *helpers.CustomError
+	CancelExchange(ctx context.Context, req dto.CancelExchangeRequest) *helpers.CustomError
+	CounterExchange(ctx context.Context, req dto.CounterExchangeRequest) (dto.CounterExchangeResponse, *helpers.CustomError)
+	Trade(ctx context.Context, req dto.TradeRequest) (dto.TradeResponse, *helpers.CustomError)
+	RespondTrade(ctx context.Context, req dto.RespondTradeRequest) *helpers.CustomError
+	ExpireExchanges(ctx context.Context, now time.Time) *helpers.CustomError
 }
 
 type transactionService struct {
@@ -28,19 +40,37 @@ type transactionService struct {
 	userRepo            model.UserRepository
 	cardRepo            model.CardRepository
 	notificationService NotificationService
+	ledgerService       LedgerService
+	idempotencyService  IdempotencyService
+	unitOfWork          UnitOfWork
+	exchangeTTL         time.Duration
 }
 
-func NewTransactionService(cardTransactionRepo model.CardTransactionRepository, cashTransactionRepo model.CashTransactionRepository, userRepo model.UserRepository, cardRepo model.CardRepository, notificationService NotificationService) TransactionService {
+func NewTransactionService(cardTransactionRepo model.CardTransactionRepository, cashTransactionRepo model.CashTransactionRepository, userRepo model.UserRepository, cardRepo model.CardRepository, notificationService NotificationService, ledgerService LedgerService, idempotencyService IdempotencyService, unitOfWork UnitOfWork, exchangeTTL time.Duration) TransactionService {
 	return &transactionService{
 		cardTransactionRepo: cardTransactionRepo,
 		cashTransactionRepo: cashTransactionRepo,
 		userRepo:            userRepo,
 		cardRepo:            cardRepo,
 		notificationService: notificationService,
+		ledgerService:       ledgerService,
+		idempotencyService:  idempotencyService,
+		unitOfWork:          unitOfWork,
+		exchangeTTL:         exchangeTTL,
 	}
 }
 
-func (s *transactionService) TransferCash(ctx context.Context, req dto.TransferCashRequest) (err *helpers.CustomError) {
+func (s *transactionService) TransferCash(ctx context.Context, req dto.TransferCashRequest) *helpers.CustomError {
+	return s.unitOfWork.Run(ctx, func(sessCtx mongo.SessionContext) *helpers.CustomError {
+		err := s.transferCash(sessCtx, req)
+		if err != nil {
+			return err
+		}
+		return s.idempotencyService.Commit(sessCtx, req.UserId, req.IdempotencyKey)
+	})
+}
+
+func (s *transactionService) transferCash(ctx context.Context, req dto.TransferCashRequest) (err *helpers.CustomError) {
 	users, err := s.userRepo.GetUsers(ctx, model.User{UserId: req.UserId})
 	if err != nil {
 		return err
@@ -88,46 +118,34 @@ func (s *transactionService) TransferCash(ctx context.Context, req dto.TransferC
 		GivenBy:   req.GivenBy,
 		GivenTo:   req.GivenTo,
 		Status:    req.Status,
-		CreatedBy: req.GivenBy,
+		CreatedBy: req.UserId,
+		LoanId:    req.LoanId,
+		Type:      model.TRANSACTION_TYPE_TRANSFER,
 	}
 
-	session, serr := s.cashTransactionRepo.GetCollection().Database().Client().StartSession()
-	if serr != nil {
-		return helpers.System("Failed to start session: " + serr.Error())
-	}
-	defer session.EndSession(ctx)
-	serr = session.StartTransaction()
-	if serr != nil {
-		return helpers.System("Failed to start transaction: " + serr.Error())
-	}
-	defer func() {
-		if err != nil {
-			if abortErr := session.AbortTransaction(ctx); abortErr != nil {
-				err = helpers.System("Failed to abort transaction: " + abortErr.Error())
-			}
-		} else {
-			if commitErr := session.CommitTransaction(ctx); commitErr != nil {
-				err = helpers.System("Failed to commit transaction: " + commitErr.Error())
-			}
-		}
-	}()
-	_, err = s.cashTransactionRepo.AddCashTransaction(ctx, transaction)
+	transactionGuid, err := s.cashTransactionRepo.AddCashTransaction(ctx, transaction)
 	if err != nil {
 		return err
 	}
 	if transaction.Status == model.TRANSACTION_STATUS_SUCCESS {
-		var user model.User
+		err = s.ledgerService.RecordMovements(ctx, []model.LedgerMovement{{
+			Kind:            transferLedgerKind(req.LoanId),
+			TransactionGuid: transactionGuid,
+			From:            req.GivenBy,
+			To:              req.GivenTo,
+			Asset:           model.LEDGER_ASSET_CASH,
+			Amount:          int64(req.Amount),
+		}})
+		if err != nil {
+			return err
+		}
 		if req.GivenBy != 0 {
-			user = users[0]
-			user.Cash = user.Cash - req.Amount
-			err = s.userRepo.UpdateUser(ctx, user)
+			err = s.userRepo.DebitBalance(ctx, req.GivenBy, req.Amount, nil)
 			if err != nil {
 				return err
 			}
 		}
-		user = recieverUsers[0]
-		user.Cash = user.Cash + req.Amount
-		err = s.userRepo.UpdateUser(ctx, user)
+		err = s.userRepo.CreditBalance(ctx, req.GivenTo, req.Amount, nil)
 		if err != nil {
 			return err
 		}
@@ -135,7 +153,17 @@ func (s *transactionService) TransferCash(ctx context.Context, req dto.TransferC
 	return nil
 }
 
-func (s *transactionService) TransferCards(ctx context.Context, req dto.TransferCardRequest) (err *helpers.CustomError) {
+func (s *transactionService) TransferCards(ctx context.Context, req dto.TransferCardRequest) *helpers.CustomError {
+	return s.unitOfWork.Run(ctx, func(sessCtx mongo.SessionContext) *helpers.CustomError {
+		err := s.transferCards(sessCtx, req)
+		if err != nil {
+			return err
+		}
+		return s.idempotencyService.Commit(sessCtx, req.UserId, req.IdempotencyKey)
+	})
+}
+
+func (s *transactionService) transferCards(ctx context.Context, req dto.TransferCardRequest) (err *helpers.CustomError) {
 	users, err := s.userRepo.GetUsers(ctx, model.User{UserId: req.UserId})
 	if err != nil {
 		return err
@@ -177,6 +205,13 @@ func (s *transactionService) TransferCards(ctx context.Context, req dto.Transfer
 	if len(cards) == 0 || len(cards) != len(req.Cards) {
 		return helpers.NotFound("Some cards not found")
 	}
+	// collateral of a loan still settles after its card was retired
+	if req.LoanId == 0 {
+		err = checkCardsNotRetired(cards)
+		if err != nil {
+			return err
+		}
+	}
 	cardsToTransferMap := make(map[string]uint32)
 	for _, card := range req.Cards {
 		cardsToTransferMap[card.CardNumber] = card.Amount
@@ -210,97 +245,53 @@ func (s *transactionService) TransferCards(ctx context.Context, req dto.Transfer
 			GivenBy:    req.GivenBy,
 			GivenTo:    req.GivenTo,
 			Status:     req.Status,
-			CreatedBy:  req.GivenBy,
+			CreatedBy:  req.UserId,
+			LoanId:     req.LoanId,
+			Type:       model.TRANSACTION_TYPE_TRANSFER,
 		}
 		transactions = append(transactions, transaction)
 	}
-	session, serr := s.cardTransactionRepo.GetCollection().Database().Client().StartSession()
-	if serr != nil {
-		return helpers.System("Failed to start session: " + serr.Error())
-	}
-	defer session.EndSession(ctx)
-	serr = session.StartTransaction()
-	if serr != nil {
-		return helpers.System("Failed to start transaction: " + serr.Error())
-	}
-	defer func() {
-		if err != nil {
-			if abortErr := session.AbortTransaction(ctx); abortErr != nil {
-				err = helpers.System("Failed to abort transaction: " + abortErr.Error())
-			}
-		} else {
-			if commitErr := session.CommitTransaction(ctx); commitErr != nil {
-				err = helpers.System("Failed to commit transaction: " + commitErr.Error())
-			}
-		}
-	}()
-	_, err = s.cardTransactionRepo.AddCardTransactions(ctx, transactions)
+	transactionGuid, err := s.cardTransactionRepo.AddCardTransactions(ctx, transactions)
 	if err != nil {
 		return err
 	}
 	if req.Status == model.TRANSACTION_STATUS_SUCCESS {
+		movements := []model.LedgerMovement{}
+		for _, card := range req.Cards {
+			movements = append(movements, model.LedgerMovement{
+				Kind:            transferLedgerKind(req.LoanId),
+				TransactionGuid: transactionGuid,
+				From:            req.GivenBy,
+				To:              req.GivenTo,
+				Asset:           card.CardNumber,
+				Amount:          int64(card.Amount),
+			})
+		}
+		err = s.ledgerService.RecordMovements(ctx, movements)
+		if err != nil {
+			return err
+		}
+		cardsToMove := []model.CardOccupied{}
+		for _, card := range req.Cards {
+			cardsToMove = append(cardsToMove, model.CardOccupied{
+				CardNumber: card.CardNumber,
+				Occupied:   card.Amount,
+			})
+		}
 		if req.GivenBy != 0 {
-			user := users[0]
-			for _, card := range req.Cards {
-				cardFound := false
-				for i, userCard := range user.Cards {
-					if userCard.CardNumber == card.CardNumber {
-						cardFound = true
-						if userCard.Occupied < card.Amount {
-							return helpers.BadRequest("Insufficient card balance")
-						}
-						user.Cards[i].Occupied -= card.Amount
-						break
-					}
-				}
-				if !cardFound {
-					return helpers.BadRequest("User does not have the required card")
-				}
-			}
-			err = s.userRepo.UpdateUser(ctx, user)
+			err = s.userRepo.DebitBalance(ctx, req.GivenBy, 0, cardsToMove)
 			if err != nil {
 				return err
 			}
 		} else {
-			for _, cardToTransfer := range req.Cards {
-				cardFound := false
-				for i, card := range cards {
-					if cardToTransfer.CardNumber == card.Number {
-						cardFound = true
-						if card.Total-card.Occupied < cardToTransfer.Amount {
-							return helpers.BadRequest("Insufficient card balance")
-						}
-						cards[i].Occupied += cardToTransfer.Amount
-						break
-					}
-				}
-				if !cardFound {
-					return helpers.BadRequest("card does not exist: " + cardToTransfer.CardNumber)
-				}
-			}
-			err = s.cardRepo.UpdateCards(ctx, cards)
-			if err != nil {
-				return err
-			}
-		}
-		recieverUser := recieverUsers[0]
-		for _, card := range req.Cards {
-			cardFound := false
-			for i, recieversCard := range recieverUser.Cards {
-				if card.CardNumber == recieversCard.CardNumber {
-					cardFound = true
-					recieverUser.Cards[i].Occupied += card.Amount
-					break
+			for _, card := range cardsToMove {
+				err = s.cardRepo.ReserveCards(ctx, card.CardNumber, card.Occupied)
+				if err != nil {
+					return err
 				}
 			}
-			if !cardFound {
-				recieverUser.Cards = append(recieverUser.Cards, model.CardOccupied{
-					CardNumber: card.CardNumber,
-					Occupied:   card.Amount,
-				})
-			}
 		}
-		err = s.userRepo.UpdateUser(ctx, recieverUser)
+		err = s.userRepo.CreditBalance(ctx, req.GivenTo, 0, cardsToMove)
 		if err != nil {
 			return err
 		}
@@ -308,50 +299,173 @@ func (s *transactionService) TransferCards(ctx context.Context, req dto.Transfer
 	return nil
 }
 
-func (s *transactionService) GiveCards(ctx context.Context, req dto.TransferCardRequest) (err *helpers.CustomError) {
+func (s *transactionService) GiveCards(ctx context.Context, req dto.GiveCardsRequest) (resp dto.GiveCardsResponse, err *helpers.CustomError) {
+	err = s.unitOfWork.Run(ctx, func(sessCtx mongo.SessionContext) *helpers.CustomError {
+		resp, err = s.giveCards(sessCtx, req)
+		if err != nil {
+			return err
+		}
+		return s.idempotencyService.Commit(sessCtx, req.UserId, req.IdempotencyKey)
+	})
+	return resp, err
+}
+
+// mints cards from the remaining supply (Total - Occupied) of each card to one or more
+// users. Every grant is a successful card transaction from the system account with its
+// own guid, and either all grants go through or none.
+func (s *transactionService) giveCards(ctx context.Context, req dto.GiveCardsRequest) (resp dto.GiveCardsResponse, err *helpers.CustomError) {
 	err = utils.ValidateGiveCardsRequest(req)
 	if err != nil {
-		return err
+		return resp, err
 	}
 	users, err := s.userRepo.GetUsers(ctx, model.User{UserId: req.UserId})
 	if err != nil {
-		return err
+		return resp, err
 	}
 	if len(users) == 0 {
-		return helpers.NotFound("User not found")
+		return resp, helpers.NotFound("User not found")
 	}
 	if !utils.IsAdmin(users[0].UserType) {
-		return helpers.Unauthorized("Only admin can give cards to users")
+		return resp, helpers.Unauthorized("Only admin can give cards to users")
 	}
 
-	// Implement the logic to give cards
-	return nil
+	cardNumbers := []string{}
+	seen := make(map[string]bool)
+	for _, grant := range req.Grants {
+		for _, card := range grant.Cards {
+			if !seen[card.CardNumber] {
+				seen[card.CardNumber] = true
+				cardNumbers = append(cardNumbers, card.CardNumber)
+			}
+		}
+	}
+	cards, err := s.cardRepo.GetCards(ctx, model.GetCardsRequest{
+		Numbers: cardNumbers,
+	})
+	if err != nil {
+		return resp, err
+	}
+	if len(cards) != len(cardNumbers) {
+		return resp, helpers.NotFound("Some cards not found")
+	}
+	err = checkCardsNotRetired(cards)
+	if err != nil {
+		return resp, err
+	}
+
+	resp.TransactionGuids = []uint32{}
+	for _, grant := range req.Grants {
+		recieverUsers, err := s.userRepo.GetUsers(ctx, model.User{UserId: grant.GivenTo})
+		if err != nil {
+			return resp, err
+		}
+		if len(recieverUsers) == 0 {
+			return resp, helpers.NotFound("User not found")
+		}
+		transactions := []model.CardTransaction{}
+		cardsToGive := []model.CardOccupied{}
+		for _, card := range grant.Cards {
+			transactions = append(transactions, model.CardTransaction{
+				CardNumber: card.CardNumber,
+				Amount:     card.Amount,
+				GivenBy:    model.LEDGER_ACCOUNT_SYSTEM,
+				GivenTo:    grant.GivenTo,
+				Status:     model.TRANSACTION_STATUS_SUCCESS,
+				CreatedBy:  req.UserId,
+				Type:       model.TRANSACTION_TYPE_TRANSFER,
+			})
+			cardsToGive = append(cardsToGive, model.CardOccupied{
+				CardNumber: card.CardNumber,
+				Occupied:   card.Amount,
+			})
+		}
+		transactionGuid, err := s.cardTransactionRepo.AddCardTransactions(ctx, transactions)
+		if err != nil {
+			return resp, err
+		}
+		// guarded on the card, so concurrent grants can never mint more than its total
+		movements := []model.LedgerMovement{}
+		for _, card := range cardsToGive {
+			err = s.cardRepo.ReserveCards(ctx, card.CardNumber, card.Occupied)
+			if err != nil {
+				return resp, err
+			}
+			movements = append(movements, model.LedgerMovement{
+				Kind:            model.LEDGER_KIND_MINT,
+				TransactionGuid: transactionGuid,
+				From:            model.LEDGER_ACCOUNT_SYSTEM,
+				To:              grant.GivenTo,
+				Asset:           card.CardNumber,
+				Amount:          int64(card.Occupied),
+			})
+		}
+		err = s.userRepo.CreditBalance(ctx, grant.GivenTo, 0, cardsToGive)
+		if err != nil {
+			return resp, err
+		}
+		err = s.ledgerService.RecordMovements(ctx, movements)
+		if err != nil {
+			return resp, err
+		}
+		err = s.notificationService.SendNotification(ctx, dto.SendNotificationRequest{
+			UserIds: []uint32{grant.GivenTo},
+			Title:   "Cards Received",
+			Message: fmt.Sprintf("You received %d cards from ChronoPlay.", countCards(cardsToGive)),
+		})
+		if err != nil {
+			return resp, err
+		}
+		resp.TransactionGuids = append(resp.TransactionGuids, transactionGuid)
+	}
+	log.Printf("Admin %d gave cards to %d users", req.UserId, len(req.Grants))
+	return resp, nil
 }
 
 func (s *transactionService) Exchange(ctx context.Context, req dto.ExchangeRequest) *helpers.CustomError {
-	log.Printf("Exchange request received: %+v\n", req)
+	return s.unitOfWork.Run(ctx, func(sessCtx mongo.SessionContext) *helpers.CustomError {
+		_, err := s.createExchange(sessCtx, req, 0, 0)
+		if err != nil {
+			return err
+		}
+		return s.idempotencyService.Commit(sessCtx, req.UserId, req.IdempotencyKey)
+	})
+}
+
+// writes a pending exchange proposal and returns its guid. A counter-offer passes the
+// proposal it answers as parentGuid and the guid of the first proposal of the
+// negotiation as chainGuid, a fresh exchange passes zero for both.
+func (s *transactionService) createExchange(ctx context.Context, req dto.ExchangeRequest, parentGuid uint32, chainGuid uint32) (uint32, *helpers.CustomError) {
+	log.Printf("Exchange request received from user %d to user %d\n", req.GivenBy, req.GivenTo)
 	err := utils.ValidateExchangeRequest(req)
 	if err != nil {
-		return err
+		return 0, err
+	}
+	cardNumbers := []string{}
+	for _, card := range append(append([]dto.Card{}, req.CardsSent...), req.CardsRecieved...) {
+		cardNumbers = append(cardNumbers, card.CardNumber)
+	}
+	err = s.checkCardNumbersNotRetired(ctx, cardNumbers)
+	if err != nil {
+		return 0, err
 	}
 	users, err := s.userRepo.GetUsers(ctx, model.User{UserId: req.GivenBy})
 	if err != nil {
-		return err
+		return 0, err
 	}
 	if len(users) == 0 {
-		return helpers.NotFound("User not found")
+		return 0, helpers.NotFound("User not found")
 	}
 	sender := users[0]
 	users, err = s.userRepo.GetUsers(ctx, model.User{UserId: req.GivenTo})
 	if err != nil {
-		return err
+		return 0, err
 	}
 	if len(users) == 0 {
-		return helpers.NotFound("User not found")
+		return 0, helpers.NotFound("User not found")
 	}
 	receiver := users[0]
 	if sender.UserId == receiver.UserId {
-		return helpers.BadRequest("Sender and receiver cannot be the same user")
+		return 0, helpers.BadRequest("Sender and receiver cannot be the same user")
 	}
 
 	err = IsExchangePossible(dto.IsExhangePossibleRequest{
@@ -363,9 +477,10 @@ func (s *transactionService) Exchange(ctx context.Context, req dto.ExchangeReque
 		CardsRecieved: req.CardsRecieved,
 	})
 	if err != nil {
-		return err
+		return 0, err
 	}
 
+	expiresAt := primitive.NewDateTimeFromTime(time.Now().Add(s.exchangeTTL))
 	cardTransactions := []model.CardTransaction{}
 	for _, card := range req.CardsSent {
 		cardTransactions = append(cardTransactions, model.CardTransaction{
@@ -375,6 +490,11 @@ func (s *transactionService) Exchange(ctx context.Context, req dto.ExchangeReque
 			GivenTo:    receiver.UserId,
 			Status:     model.TRANSACTION_STATUS_PENDING,
 			CreatedBy:  req.UserId,
+			Type:       model.TRANSACTION_TYPE_EXCHANGE,
+			ExpiresAt:  expiresAt,
+			ParentGuid: parentGuid,
+			ChainGuid:  chainGuid,
+			Held:       sender.UserId == req.UserId,
 		})
 	}
 	for _, card := range req.CardsRecieved {
@@ -385,113 +505,158 @@ func (s *transactionService) Exchange(ctx context.Context, req dto.ExchangeReque
 			GivenTo:    sender.UserId,
 			Status:     model.TRANSACTION_STATUS_PENDING,
 			CreatedBy:  req.UserId,
+			Type:       model.TRANSACTION_TYPE_EXCHANGE,
+			ExpiresAt:  expiresAt,
+			ParentGuid: parentGuid,
+			ChainGuid:  chainGuid,
+			Held:       receiver.UserId == req.UserId,
 		})
 	}
 
-	// need to add transaction commit and abort and rollback handling here
 	paymentGuid := uint32(0)
 	if len(cardTransactions) > 0 {
 		paymentGuid, err = s.cardTransactionRepo.AddCardTransactions(ctx, cardTransactions)
 		if err != nil {
-			return err
+			return 0, err
 		}
 	}
 
-	cashTransaction := model.CashTransaction{}
-	var validCashTransaction bool
+	// cash can go both ways, e.g. a deposit returned to one side and a fee paid by it
+	cashTransactions := []model.CashTransaction{}
 	if req.CashSent > 0 {
-		cashTransaction = model.CashTransaction{
-			Amount:          req.CashSent,
-			GivenBy:         sender.UserId,
-			GivenTo:         receiver.UserId,
-			Status:          model.TRANSACTION_STATUS_PENDING,
-			CreatedBy:       req.UserId,
-			TransactionGuid: paymentGuid,
+		cashTransactions = append(cashTransactions, model.CashTransaction{
+			Amount:     req.CashSent,
+			GivenBy:    sender.UserId,
+			GivenTo:    receiver.UserId,
+			Status:     model.TRANSACTION_STATUS_PENDING,
+			CreatedBy:  req.UserId,
+			Type:       model.TRANSACTION_TYPE_EXCHANGE,
+			ExpiresAt:  expiresAt,
+			ParentGuid: parentGuid,
+			ChainGuid:  chainGuid,
+			Held:       sender.UserId == req.UserId,
+		})
+	}
+	if req.CashRecieved > 0 {
+		cashTransactions = append(cashTransactions, model.CashTransaction{
+			Amount:     req.CashRecieved,
+			GivenBy:    receiver.UserId,
+			GivenTo:    sender.UserId,
+			Status:     model.TRANSACTION_STATUS_PENDING,
+			CreatedBy:  req.UserId,
+			Type:       model.TRANSACTION_TYPE_EXCHANGE,
+			ExpiresAt:  expiresAt,
+			ParentGuid: parentGuid,
+			ChainGuid:  chainGuid,
+			Held:       receiver.UserId == req.UserId,
+		})
+	}
+	if len(cardTransactions) == 0 && len(cashTransactions) == 0 {
+		return 0, helpers.BadRequest("No transactions to process")
+	}
+	for _, transaction := range cashTransactions {
+		transaction.TransactionGuid = paymentGuid
+		paymentGuid, err = s.cashTransactionRepo.AddCashTransaction(ctx, transaction)
+		if err != nil {
+			return 0, err
 		}
-		validCashTransaction = true
-	} else if req.CashRecieved > 0 {
-		cashTransaction = model.CashTransaction{
-			Amount:          req.CashRecieved,
-			GivenBy:         receiver.UserId,
-			GivenTo:         sender.UserId,
-			Status:          model.TRANSACTION_STATUS_PENDING,
-			CreatedBy:       req.UserId,
-			TransactionGuid: paymentGuid,
+	}
+
+	// what the proposer gives is put on hold until the exchange settles, so it can not
+	// be spent or promised again in the meantime. The other side only commits on accept.
+	heldCash := model.Money(0)
+	heldCards := []model.CardOccupied{}
+	for _, transaction := range cardTransactions {
+		if transaction.Held {
+			heldCards = append(heldCards, model.CardOccupied{CardNumber: transaction.CardNumber, Occupied: transaction.Amount})
 		}
-		validCashTransaction = true
 	}
-	if validCashTransaction {
-		_, err = s.cashTransactionRepo.AddCashTransaction(ctx, cashTransaction)
-		if err != nil {
-			return err
+	for _, transaction := range cashTransactions {
+		if transaction.Held {
+			heldCash += transaction.Amount
 		}
 	}
-	if len(cardTransactions) == 0 && !validCashTransaction {
-		return helpers.BadRequest("No transactions to process")
+	err = s.userRepo.HoldBalance(ctx, req.UserId, heldCash, heldCards)
+	if err != nil {
+		return 0, err
 	}
 	log.Printf("Exchange transaction created with GUID: %d\n", paymentGuid)
-	return nil
+	return paymentGuid, nil
 }
 
+// pages through the history of a user. The filters run in mongo on both transaction
+// collections, which only return the guids of one page, and just the legs of those
+// guids are loaded and merged. A negotiation chain is listed oldest first, everything
+// else newest first.
 func (s *transactionService) GetTransactions(ctx context.Context, req dto.GetTransactionsRequest) (resp dto.GetTransactionsResponse, err *helpers.CustomError) {
-	if req.UserId == 0 {
-		return resp, helpers.BadRequest("User ID is required")
-	}
-	// first i need to get all cashtransactions which are given by recieved by this user
-	cashTransactionsByUser, err := s.cashTransactionRepo.GetCashTransactionsByUserId(ctx, req.UserId)
+	err = utils.ValidateGetTransactionsRequest(req)
 	if err != nil {
 		return resp, err
 	}
-	cashTransactionsToUser, err := s.cashTransactionRepo.GetCashTransactionsToUserId(ctx, req.UserId)
+	limit := req.Limit
+	if limit == 0 {
+		limit = model.TRANSACTION_HISTORY_DEFAULT_LIMIT
+	}
+	filter := model.TransactionFilter{
+		UserId:           req.UserId,
+		Counterparty:     req.Counterparty,
+		Direction:        req.Direction,
+		Status:           req.Status,
+		CardNumber:       req.CardNumber,
+		ChainGuid:        req.ChainGuid,
+		TransactionGuids: req.TransactionGuids,
+		From:             req.From,
+		To:               req.To,
+		Cursor:           req.Cursor,
+		Ascending:        req.ChainGuid != 0,
+		Limit:            limit + 1, // one more tells whether there is a next page
+	}
+	cardGuids, err := s.cardTransactionRepo.GetCardTransactionGuids(ctx, filter)
 	if err != nil {
 		return resp, err
 	}
-
-	// then i need to map those by transaction guid
-	guidToCashTransactionsByUserMap := make(map[uint32][]model.CashTransaction)
-	for _, transaction := range cashTransactionsByUser {
-		guidToCashTransactionsByUserMap[transaction.TransactionGuid] = append(guidToCashTransactionsByUserMap[transaction.TransactionGuid], transaction)
-	}
-	guidToCashTransactionsToUserMap := make(map[uint32][]model.CashTransaction)
-	for _, transaction := range cashTransactionsToUser {
-		guidToCashTransactionsToUserMap[transaction.TransactionGuid] = append(guidToCashTransactionsToUserMap[transaction.TransactionGuid], transaction)
+	cashGuids := []uint32{}
+	if req.CardNumber == "" {
+		cashGuids, err = s.cashTransactionRepo.GetCashTransactionGuids(ctx, filter)
+		if err != nil {
+			return resp, err
+		}
 	}
+	guids, nextCursor := pageTransactionGuids(cardGuids, cashGuids, filter.Ascending, limit)
+	resp.NextCursor = nextCursor
 
-	// then i need to get all card transactions which are given by recieved by this user
-	cardTransactionsByUser, err := s.cardTransactionRepo.GetCardTransactionsByUserId(ctx, req.UserId)
+	cardTransactions, err := s.cardTransactionRepo.GetCardTransactionsOfUser(ctx, req.UserId, guids)
 	if err != nil {
 		return resp, err
 	}
-	cardTransactionsToUser, err := s.cardTransactionRepo.GetCardTransactionsToUserId(ctx, req.UserId)
+	cashTransactions, err := s.cashTransactionRepo.GetCashTransactionsOfUser(ctx, req.UserId, guids)
 	if err != nil {
 		return resp, err
 	}
 
-	// then i need to map those by transaction guid
+	// map the legs by transaction guid and by the side the user is on
 	guidToCardTransactionsByUserMap := make(map[uint32][]model.CardTransaction)
-	for _, transaction := range cardTransactionsByUser {
-		guidToCardTransactionsByUserMap[transaction.TransactionGuid] = append(guidToCardTransactionsByUserMap[transaction.TransactionGuid], transaction)
-	}
 	guidToCardTransactionsToUserMap := make(map[uint32][]model.CardTransaction)
-	for _, transaction := range cardTransactionsToUser {
-		guidToCardTransactionsToUserMap[transaction.TransactionGuid] = append(guidToCardTransactionsToUserMap[transaction.TransactionGuid], transaction)
-	}
-
-	// after this i need to merge those maps on guid basis and return my response
-	uniqueGuids := make(map[uint32]bool)
-	for guid := range guidToCashTransactionsByUserMap {
-		uniqueGuids[guid] = true
-	}
-	for guid := range guidToCardTransactionsByUserMap {
-		uniqueGuids[guid] = true
-	}
-	for guid := range guidToCashTransactionsToUserMap {
-		uniqueGuids[guid] = true
+	for _, transaction := range cardTransactions {
+		if transaction.GivenBy == req.UserId {
+			guidToCardTransactionsByUserMap[transaction.TransactionGuid] = append(guidToCardTransactionsByUserMap[transaction.TransactionGuid], transaction)
+		} else {
+			guidToCardTransactionsToUserMap[transaction.TransactionGuid] = append(guidToCardTransactionsToUserMap[transaction.TransactionGuid], transaction)
+		}
 	}
-	for guid := range guidToCardTransactionsToUserMap {
-		uniqueGuids[guid] = true
+	guidToCashTransactionsByUserMap := make(map[uint32][]model.CashTransaction)
+	guidToCashTransactionsToUserMap := make(map[uint32][]model.CashTransaction)
+	for _, transaction := range cashTransactions {
+		if transaction.GivenBy == req.UserId {
+			guidToCashTransactionsByUserMap[transaction.TransactionGuid] = append(guidToCashTransactionsByUserMap[transaction.TransactionGuid], transaction)
+		} else {
+			guidToCashTransactionsToUserMap[transaction.TransactionGuid] = append(guidToCashTransactionsToUserMap[transaction.TransactionGuid], transaction)
+		}
 	}
-	for guid := range uniqueGuids {
+
+	// then merge the legs of every guid of the page in page order
+	resp.Transactions = []dto.Transaction{}
+	for _, guid := range guids {
 		transaction := dto.Transaction{
 			TransactionGuid: guid,
 		}
@@ -502,10 +667,14 @@ func (s *transactionService) GetTransactions(ctx context.Context, req dto.GetTra
 					CardNumber: cardTransaction.CardNumber,
 					Amount:     cardTransaction.Amount,
 				})
+				if cardTransaction.LoanId != 0 {
+					transaction.LoanId = cardTransaction.LoanId
+				}
 			}
 			transaction.Time = cardTransactionsToUser[0].CreatedAt.Time()
 			transaction.TransactionWith = cardTransactionsToUser[0].GivenBy
 			transaction.Status = cardTransactionsToUser[0].Status
+			setProposalDetails(&transaction, cardTransactionsToUser[0].Type, cardTransactionsToUser[0].ExpiresAt, cardTransactionsToUser[0].ParentGuid, cardTransactionsToUser[0].ChainGuid)
 		}
 		cardTransactionsByUser, ok := guidToCardTransactionsByUserMap[guid]
 		if ok {
@@ -514,6 +683,9 @@ func (s *transactionService) GetTransactions(ctx context.Context, req dto.GetTra
 					CardNumber: cardTransaction.CardNumber,
 					Amount:     cardTransaction.Amount,
 				})
+				if cardTransaction.LoanId != 0 {
+					transaction.LoanId = cardTransaction.LoanId
+				}
 			}
 			if transaction.Time.IsZero() {
 				transaction.Time = cardTransactionsByUser[0].CreatedAt.Time()
@@ -524,11 +696,15 @@ func (s *transactionService) GetTransactions(ctx context.Context, req dto.GetTra
 			if transaction.Status == "" {
 				transaction.Status = cardTransactionsByUser[0].Status
 			}
+			setProposalDetails(&transaction, cardTransactionsByUser[0].Type, cardTransactionsByUser[0].ExpiresAt, cardTransactionsByUser[0].ParentGuid, cardTransactionsByUser[0].ChainGuid)
 		}
 		cashTransactionsByUser, ok := guidToCashTransactionsByUserMap[guid]
 		if ok {
 			for _, cashTransaction := range cashTransactionsByUser {
 				transaction.CashSent += cashTransaction.Amount
+				if cashTransaction.LoanId != 0 {
+					transaction.LoanId = cashTransaction.LoanId
+				}
 			}
 			if transaction.Time.IsZero() {
 				transaction.Time = cashTransactionsByUser[0].CreatedAt.Time()
@@ -539,11 +715,15 @@ func (s *transactionService) GetTransactions(ctx context.Context, req dto.GetTra
 			if transaction.Status == "" {
 				transaction.Status = cashTransactionsByUser[0].Status
 			}
+			setProposalDetails(&transaction, cashTransactionsByUser[0].Type, cashTransactionsByUser[0].ExpiresAt, cashTransactionsByUser[0].ParentGuid, cashTransactionsByUser[0].ChainGuid)
 		}
 		cashTransactionsToUser, ok := guidToCashTransactionsToUserMap[guid]
 		if ok {
 			for _, cashTransaction := range cashTransactionsToUser {
 				transaction.CashRecieved += cashTransaction.Amount
+				if cashTransaction.LoanId != 0 {
+					transaction.LoanId = cashTransaction.LoanId
+				}
 			}
 			if transaction.Time.IsZero() {
 				transaction.Time = cashTransactionsToUser[0].CreatedAt.Time()
@@ -554,28 +734,77 @@ func (s *transactionService) GetTransactions(ctx context.Context, req dto.GetTra
 			if transaction.Status == "" {
 				transaction.Status = cashTransactionsToUser[0].Status
 			}
+			setProposalDetails(&transaction, cashTransactionsToUser[0].Type, cashTransactionsToUser[0].ExpiresAt, cashTransactionsToUser[0].ParentGuid, cashTransactionsToUser[0].ChainGuid)
+		}
+		if transaction.Type == model.TRANSACTION_TYPE_TRADE {
+			err = s.setTradeDetails(ctx, &transaction)
+			if err != nil {
+				return resp, err
+			}
 		}
 		resp.Transactions = append(resp.Transactions, transaction)
 	}
-	sort.Slice(resp.Transactions, func(i, j int) bool {
-		return resp.Transactions[i].TransactionGuid > resp.Transactions[j].TransactionGuid
-	})
-	if len(req.TransactionGuids) > 0 {
-		filteredTransactions := []dto.Transaction{}
-		guidSet := make(map[uint32]bool)
-		for _, guid := range req.TransactionGuids {
-			guidSet[guid] = true
+	return resp, nil
+}
+
+// streams the whole filtered history of a user into the writer one page at a time,
+// so memory stays bounded by the page size whatever the length of the history
+func (s *transactionService) ExportTransactions(ctx context.Context, req dto.ExportTransactionsRequest, writer utils.TransactionExportWriter) *helpers.CustomError {
+	err := utils.ValidateExportTransactionsRequest(req)
+	if err != nil {
+		return err
+	}
+	if req.TargetUserId != req.UserId {
+		users, err := s.userRepo.GetUsers(ctx, model.User{UserId: req.UserId})
+		if err != nil {
+			return err
+		}
+		if len(users) == 0 {
+			return helpers.NotFound("User not found")
+		}
+		if !utils.IsAdmin(users[0].UserType) {
+			return helpers.Unauthorized("Only admin can export the history of another user")
+		}
+	}
+
+	filter := req.Filter
+	filter.UserId = req.TargetUserId
+	filter.Limit = model.TRANSACTION_HISTORY_MAX_LIMIT
+	for {
+		page, err := s.GetTransactions(ctx, filter)
+		if err != nil {
+			return err
+		}
+		// names are looked up per page and dropped with it
+		seen := make(map[uint32]bool)
+		counterparties := []uint32{}
+		for _, transaction := range page.Transactions {
+			if !seen[transaction.TransactionWith] && transaction.TransactionWith != model.LEDGER_ACCOUNT_SYSTEM {
+				seen[transaction.TransactionWith] = true
+				counterparties = append(counterparties, transaction.TransactionWith)
+			}
 		}
-		for _, transaction := range resp.Transactions {
-			if guidSet[transaction.TransactionGuid] {
-				filteredTransactions = append(filteredTransactions, transaction)
+		userNames, err := s.userRepo.GetUserNames(ctx, counterparties)
+		if err != nil {
+			return err
+		}
+		for _, transaction := range page.Transactions {
+			werr := writer.Write(dto.TransactionExportRow{
+				Transaction:          transaction,
+				CounterpartyUsername: userNames[transaction.TransactionWith],
+			})
+			if werr != nil {
+				return helpers.SystemError("Failed to write transaction export", werr)
 			}
 		}
-		return dto.GetTransactionsResponse{
-			Transactions: filteredTransactions,
-		}, nil
+		if werr := writer.Flush(); werr != nil {
+			return helpers.SystemError("Failed to write transaction export", werr)
+		}
+		if page.NextCursor == 0 {
+			return nil
+		}
+		filter.Cursor = page.NextCursor
 	}
-	return resp, nil
 }
 
 func (s *transactionService) IsCashTransactionPossible(ctx context.Context, req dto.IsCashTransactionPossibleRequest) *helpers.CustomError {
@@ -584,12 +813,15 @@ func (s *transactionService) IsCashTransactionPossible(ctx context.Context, req
 		// amount is being paid by system
 		return nil
 	}
+	// cash held for pending exchanges is not part of Cash
 	if req.User.Cash < req.Amount {
 		return helpers.BadRequest("Insufficient cash")
 	}
 	return nil
 }
 
+// cards locked as loan collateral or held for a pending exchange are not part of
+// Occupied, so they can never be transferred here
 func (s *transactionService) IsCardTransactionPossible(ctx context.Context, req dto.IsCardTransactionPossibleRequest) *helpers.CustomError {
 	if req.GivenBy != 0 {
 		for cardNumber, amount := range req.CardsToTransferMap {
@@ -615,6 +847,8 @@ func (s *transactionService) IsCardTransactionPossible(ctx context.Context, req
 	return nil
 }
 
+// only the free quantities count, locked collateral and assets held for other pending
+// exchanges are excluded from Occupied and Cash
 func IsExchangePossible(req dto.IsExhangePossibleRequest) *helpers.CustomError {
 	err := IsValidCardExchange(req.CardsSent, req.CardsRecieved)
 	if err != nil {
@@ -701,8 +935,6 @@ func (s *transactionService) GetPossibleExchange(ctx context.Context, req dto.Ge
 		return dto.GetPossibleExchangeResponse{}, helpers.NotFound("Trader not found")
 	}
 	trader := traderUsers[0]
-	log.Printf("Current User: %+v\n", curUser)
-	log.Printf("Trader User: %+v\n", trader)
 
 	curUserCardNumbers := []string{}
 	curUserCardOccupiedMap := make(map[string]uint32)
@@ -738,12 +970,19 @@ func (s *transactionService) GetPossibleExchange(ctx context.Context, req dto.Ge
 }
 
 func (s *transactionService) ExecuteExchange(ctx context.Context, req dto.ExecuteExchangeRequest) *helpers.CustomError {
+	return s.unitOfWork.Run(ctx, func(sessCtx mongo.SessionContext) *helpers.CustomError {
+		return s.executeExchange(sessCtx, req)
+	})
+}
+
+func (s *transactionService) executeExchange(ctx context.Context, req dto.ExecuteExchangeRequest) *helpers.CustomError {
 	status := model.TRANSACTION_STATUS_FAILED
 	var transactionExists bool
 	var traderId uint32
 	if req.IsAccepted {
 		status = model.TRANSACTION_STATUS_SUCCESS
 	}
+	now := time.Now()
 	err := utils.ValidateExecuteExchangeRequest(req)
 	if err != nil {
 		return err
@@ -765,6 +1004,15 @@ func (s *transactionService) ExecuteExchange(ctx context.Context, req dto.Execut
 			if transaction.Status != model.TRANSACTION_STATUS_PENDING {
 				return helpers.BadRequest("Transaction is not in pending state")
 			}
+			if transaction.Type == model.TRANSACTION_TYPE_TRANSFER {
+				return helpers.BadRequest("Transaction is a transfer, use respond_transfer to confirm it")
+			}
+			if transaction.Type == model.TRANSACTION_TYPE_TRADE {
+				return helpers.BadRequest("Transaction is a trade, use respond_trade to confirm it")
+			}
+			if isExpired(transaction.ExpiresAt, now) {
+				return helpers.BadRequest("Exchange has expired")
+			}
 			err = utils.IsValidTransactionConfirmer(dto.IsvalidTransactionConfirmerRequest{
 				UserId:    req.UserId,
 				CreatedBy: transaction.CreatedBy,
@@ -781,22 +1029,28 @@ func (s *transactionService) ExecuteExchange(ctx context.Context, req dto.Execut
 	if err != nil {
 		return err
 	}
-	if len(cashTransactions) > 1 {
-		return helpers.BadRequest("Multiple cash transactions found for the given transaction guid")
-	}
-	if len(cashTransactions) != 0 {
+	// an exchange can carry any number of cash legs, in either direction
+	for _, cashTransaction := range cashTransactions {
 		transactionExists = true
 		if traderId == 0 {
-			if cashTransactions[0].GivenBy == req.UserId {
-				traderId = cashTransactions[0].GivenTo
+			if cashTransaction.GivenBy == req.UserId {
+				traderId = cashTransaction.GivenTo
 			} else {
-				traderId = cashTransactions[0].GivenBy
+				traderId = cashTransaction.GivenBy
 			}
 		}
-		cashTransaction := cashTransactions[0]
 		if cashTransaction.Status != model.TRANSACTION_STATUS_PENDING {
 			return helpers.BadRequest("Cash transaction is not in pending state")
 		}
+		if cashTransaction.Type == model.TRANSACTION_TYPE_TRANSFER {
+			return helpers.BadRequest("Transaction is a transfer, use respond_transfer to confirm it")
+		}
+		if cashTransaction.Type == model.TRANSACTION_TYPE_TRADE {
+			return helpers.BadRequest("Transaction is a trade, use respond_trade to confirm it")
+		}
+		if isExpired(cashTransaction.ExpiresAt, now) {
+			return helpers.BadRequest("Exchange has expired")
+		}
 
 		err = utils.IsValidTransactionConfirmer(dto.IsvalidTransactionConfirmerRequest{
 			UserId:    req.UserId,
@@ -811,27 +1065,13 @@ func (s *transactionService) ExecuteExchange(ctx context.Context, req dto.Execut
 	if !transactionExists {
 		return helpers.NotFound("No transaction found for the given transaction guid")
 	}
-
-	session, serr := s.cardTransactionRepo.GetCollection().Database().Client().StartSession()
-	if serr != nil {
-		return helpers.System("Failed to start session: " + serr.Error())
-	}
-	defer session.EndSession(ctx)
-	serr = session.StartTransaction()
-	if serr != nil {
-		return helpers.System("Failed to start transaction: " + serr.Error())
-	}
-	defer func() {
+	if req.IsAccepted {
+		err = s.checkCardTransactionsNotRetired(ctx, cardTransactions)
 		if err != nil {
-			if abortErr := session.AbortTransaction(ctx); abortErr != nil {
-				err = helpers.System("Failed to abort transaction: " + abortErr.Error())
-			}
-		} else {
-			if commitErr := session.CommitTransaction(ctx); commitErr != nil {
-				err = helpers.System("Failed to commit transaction: " + commitErr.Error())
-			}
+			return err
 		}
-	}()
+	}
+
 	for _, transaction := range cardTransactions {
 		transaction.Status = status
 		transaction.UpdatedBy = req.UserId
@@ -840,10 +1080,16 @@ func (s *transactionService) ExecuteExchange(ctx context.Context, req dto.Execut
 			return err
 		}
 	}
-	if len(cashTransactions) != 0 {
-		cashTransactions[0].Status = status
-		cashTransactions[0].UpdatedBy = req.UserId
-		err = s.cashTransactionRepo.UpdateCashTransactions(ctx, []model.CashTransaction{cashTransactions[0]})
+	for _, transaction := range cashTransactions {
+		transaction.Status = status
+		transaction.UpdatedBy = req.UserId
+		err = s.cashTransactionRepo.UpdateCashTransactions(ctx, []model.CashTransaction{transaction})
+		if err != nil {
+			return err
+		}
+	}
+	if status == model.TRANSACTION_STATUS_FAILED {
+		err = s.releaseHolds(ctx, cardTransactions, cashTransactions)
 		if err != nil {
 			return err
 		}
@@ -866,89 +1112,77 @@ func (s *transactionService) ExecuteExchange(ctx context.Context, req dto.Execut
 			return helpers.NotFound("User not found")
 		}
 		trader := users[0]
-		log.Printf("User before exchange: %+v\n", user)
-		log.Printf("Trader before exchange: %+v\n", trader)
-		userCardMap := make(map[string]uint32)
-		for _, card := range user.Cards {
-			userCardMap[card.CardNumber] = card.Occupied
-		}
-		traderCardMap := make(map[string]uint32)
-		for _, card := range trader.Cards {
-			traderCardMap[card.CardNumber] = card.Occupied
+		changes := map[uint32]*exchangeBalanceChange{
+			user.UserId:   {},
+			trader.UserId: {},
 		}
 		for _, transaction := range cardTransactions {
-			log.Printf("Processing transaction: %+v\n", transaction)
-			if transaction.GivenBy == user.UserId {
-				// user is giving this card to trader
-				if occupied, exists := userCardMap[transaction.CardNumber]; exists {
-					if occupied < transaction.Amount {
-						return helpers.BadRequest("Insufficient card balance for card: " + transaction.CardNumber)
-					}
-					userCardMap[transaction.CardNumber] = occupied - transaction.Amount
-				} else {
-					return helpers.BadRequest("Card not found: " + transaction.CardNumber)
-				}
-				traderCardMap[transaction.CardNumber] += transaction.Amount
-			} else if transaction.GivenBy == trader.UserId {
-				// trader is giving this card to user
-				if occupied, exists := traderCardMap[transaction.CardNumber]; exists {
-					if occupied < transaction.Amount {
-						return helpers.BadRequest("Insufficient card balance for card: " + transaction.CardNumber)
-					}
-					traderCardMap[transaction.CardNumber] = occupied - transaction.Amount
-				} else {
-					return helpers.BadRequest("Card not found: " + transaction.CardNumber)
-				}
-				userCardMap[transaction.CardNumber] += transaction.Amount
+			giver, receiver := changes[transaction.GivenBy], changes[transaction.GivenTo]
+			if giver == nil || receiver == nil {
+				return helpers.BadRequest("Invalid card transaction data")
 			}
-		}
-		log.Printf("User card map after exchange: %+v\n", userCardMap)
-		log.Printf("Trader card map after exchange: %+v\n", traderCardMap)
-		newGiverCards := []model.CardOccupied{}
-		for cardNumber, occupied := range userCardMap {
-			if occupied > 0 {
-				newGiverCards = append(newGiverCards, model.CardOccupied{
-					CardNumber: cardNumber,
-					Occupied:   occupied,
-				})
+			card := model.CardOccupied{CardNumber: transaction.CardNumber, Occupied: transaction.Amount}
+			if transaction.Held {
+				giver.heldCardsOut = append(giver.heldCardsOut, card)
+			} else {
+				giver.cardsOut = append(giver.cardsOut, card)
 			}
+			receiver.cardsIn = append(receiver.cardsIn, card)
 		}
-		user.Cards = newGiverCards
-		newTraderCards := []model.CardOccupied{}
-		for cardNumber, occupied := range traderCardMap {
-			if occupied > 0 {
-				newTraderCards = append(newTraderCards, model.CardOccupied{
-					CardNumber: cardNumber,
-					Occupied:   occupied,
-				})
+		for _, transaction := range cashTransactions {
+			giver, receiver := changes[transaction.GivenBy], changes[transaction.GivenTo]
+			if giver == nil || receiver == nil {
+				return helpers.BadRequest("Invalid cash transaction data")
 			}
-		}
-		trader.Cards = newTraderCards
-		log.Printf("User after exchange: %+v\n", user)
-		log.Printf("Trader after exchange: %+v\n", trader)
-		if len(cashTransactions) != 0 {
-			cashTransaction := cashTransactions[0]
-			if cashTransaction.GivenBy == user.UserId {
-				if user.Cash < cashTransaction.Amount {
-					return helpers.BadRequest("Insufficient cash balance")
-				}
-				user.Cash -= cashTransaction.Amount
-				trader.Cash += cashTransaction.Amount
-			} else if cashTransaction.GivenBy == trader.UserId {
-				if trader.Cash < cashTransaction.Amount {
-					return helpers.BadRequest("Insufficient cash balance")
-				}
-				trader.Cash -= cashTransaction.Amount
-				user.Cash += cashTransaction.Amount
+			if transaction.Held {
+				giver.heldCashOut += transaction.Amount
 			} else {
-				return helpers.BadRequest("Invalid cash transaction data")
+				giver.cashOut += transaction.Amount
+			}
+			receiver.cashIn += transaction.Amount
+		}
+		// all debits are guarded, if either side can no longer pay the whole exchange is
+		// rolled back. What the proposer promised comes out of their holds.
+		for _, userId := range []uint32{user.UserId, trader.UserId} {
+			err = s.userRepo.DebitBalance(ctx, userId, changes[userId].cashOut, changes[userId].cardsOut)
+			if err != nil {
+				return err
+			}
+			err = s.userRepo.DebitHeld(ctx, userId, changes[userId].heldCashOut, changes[userId].heldCardsOut)
+			if err != nil {
+				return err
 			}
 		}
-		err = s.userRepo.UpdateUser(ctx, user)
+		err = s.userRepo.CreditBalance(ctx, user.UserId, changes[user.UserId].cashIn, changes[user.UserId].cardsIn)
+		if err != nil {
+			return err
+		}
+		err = s.userRepo.CreditBalance(ctx, trader.UserId, changes[trader.UserId].cashIn, changes[trader.UserId].cardsIn)
 		if err != nil {
 			return err
 		}
-		err = s.userRepo.UpdateUser(ctx, trader)
+		movements := []model.LedgerMovement{}
+		for _, transaction := range cardTransactions {
+			movements = append(movements, model.LedgerMovement{
+				Kind:            model.LEDGER_KIND_EXCHANGE,
+				TransactionGuid: transaction.TransactionGuid,
+				From:            transaction.GivenBy,
+				To:              transaction.GivenTo,
+				Asset:           transaction.CardNumber,
+				Amount:          int64(transaction.Amount),
+			})
+		}
+		for _, transaction := range cashTransactions {
+			movements = append(movements, model.LedgerMovement{
+				Kind:            model.LEDGER_KIND_EXCHANGE,
+				TransactionGuid: transaction.TransactionGuid,
+				From:            transaction.GivenBy,
+				To:              transaction.GivenTo,
+				Asset:           model.LEDGER_ASSET_CASH,
+				Amount:          int64(transaction.Amount),
+			})
+		}
+		err = s.ledgerService.RecordMovements(ctx, movements)
 		if err != nil {
 			return err
 		}
@@ -963,3 +1197,1091 @@ func (s *transactionService) ExecuteExchange(ctx context.Context, req dto.Execut
 	}
 	return nil
 }
+
+func (s *transactionService) RespondTransfer(ctx context.Context, req dto.RespondTransferRequest) *helpers.CustomError {
+	return s.unitOfWork.Run(ctx, func(sessCtx mongo.SessionContext) *helpers.CustomError {
+		return s.respondTransfer(sessCtx, req)
+	})
+}
+
+// accepts or rejects a pending cash or card transfer. Nothing is held while a transfer
+// is pending, so balances only move here on acceptance and the guarded debit fails
+// the whole response if the giver no longer has enough.
+func (s *transactionService) respondTransfer(ctx context.Context, req dto.RespondTransferRequest) *helpers.CustomError {
+	err := utils.ValidateRespondTransferRequest(req)
+	if err != nil {
+		return err
+	}
+	status := model.TRANSACTION_STATUS_FAILED
+	if req.IsAccepted {
+		status = model.TRANSACTION_STATUS_SUCCESS
+	}
+	cardTransactions, err := s.cardTransactionRepo.GetCardTransactionsByTransactionGuid(ctx, req.TransactionGuid)
+	if err != nil {
+		return err
+	}
+	cashTransactions, err := s.cashTransactionRepo.GetCashTransactionsByTransactionGuid(ctx, req.TransactionGuid)
+	if err != nil {
+		return err
+	}
+	if len(cardTransactions) == 0 && len(cashTransactions) == 0 {
+		return helpers.NotFound("No transaction found for the given transaction guid")
+	}
+
+	// a transfer always goes one way, so every transaction of the guid shares the same parties
+	var givenBy, givenTo, loanId uint32
+	confirmers := []dto.IsvalidTransactionConfirmerRequest{}
+	for i, transaction := range cardTransactions {
+		if transaction.Type == model.TRANSACTION_TYPE_EXCHANGE {
+			return helpers.BadRequest("Transaction is an exchange, use execute_exchange to confirm it")
+		}
+		if transaction.Type == model.TRANSACTION_TYPE_TRADE {
+			return helpers.BadRequest("Transaction is a trade, use respond_trade to confirm it")
+		}
+		if transaction.Status != model.TRANSACTION_STATUS_PENDING {
+			return helpers.BadRequest("Transaction is not in pending state")
+		}
+		if i == 0 {
+			givenBy, givenTo, loanId = transaction.GivenBy, transaction.GivenTo, transaction.LoanId
+		}
+		confirmers = append(confirmers, dto.IsvalidTransactionConfirmerRequest{
+			UserId:    req.UserId,
+			CreatedBy: transaction.CreatedBy,
+			GivenBy:   transaction.GivenBy,
+			GivenTo:   transaction.GivenTo,
+		})
+	}
+	for i, transaction := range cashTransactions {
+		if transaction.Type == model.TRANSACTION_TYPE_EXCHANGE {
+			return helpers.BadRequest("Transaction is an exchange, use execute_exchange to confirm it")
+		}
+		if transaction.Type == model.TRANSACTION_TYPE_TRADE {
+			return helpers.BadRequest("Transaction is a trade, use respond_trade to confirm it")
+		}
+		if transaction.Status != model.TRANSACTION_STATUS_PENDING {
+			return helpers.BadRequest("Cash transaction is not in pending state")
+		}
+		if i == 0 && len(cardTransactions) == 0 {
+			givenBy, givenTo, loanId = transaction.GivenBy, transaction.GivenTo, transaction.LoanId
+		}
+		confirmers = append(confirmers, dto.IsvalidTransactionConfirmerRequest{
+			UserId:    req.UserId,
+			CreatedBy: transaction.CreatedBy,
+			GivenBy:   transaction.GivenBy,
+			GivenTo:   transaction.GivenTo,
+		})
+	}
+	for _, confirmer := range confirmers {
+		if confirmer.GivenBy != givenBy || confirmer.GivenTo != givenTo {
+			return helpers.BadRequest("Transaction is not a transfer, use execute_exchange to confirm it")
+		}
+		err = utils.IsValidTransactionConfirmer(confirmer)
+		if err != nil {
+			return err
+		}
+	}
+	if req.IsAccepted && loanId == 0 {
+		err = s.checkCardTransactionsNotRetired(ctx, cardTransactions)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = s.setTransactionStatus(ctx, cardTransactions, cashTransactions, status, req.UserId)
+	if err != nil {
+		return err
+	}
+
+	if status == model.TRANSACTION_STATUS_SUCCESS {
+		cash := model.Money(0)
+		cards := []model.CardOccupied{}
+		movements := []model.LedgerMovement{}
+		for _, transaction := range cardTransactions {
+			cards = append(cards, model.CardOccupied{CardNumber: transaction.CardNumber, Occupied: transaction.Amount})
+			movements = append(movements, model.LedgerMovement{
+				Kind:            transferLedgerKind(loanId),
+				TransactionGuid: transaction.TransactionGuid,
+				From:            transaction.GivenBy,
+				To:              transaction.GivenTo,
+				Asset:           transaction.CardNumber,
+				Amount:          int64(transaction.Amount),
+			})
+		}
+		for _, transaction := range cashTransactions {
+			cash += transaction.Amount
+			movements = append(movements, model.LedgerMovement{
+				Kind:            transferLedgerKind(loanId),
+				TransactionGuid: transaction.TransactionGuid,
+				From:            transaction.GivenBy,
+				To:              transaction.GivenTo,
+				Asset:           model.LEDGER_ASSET_CASH,
+				Amount:          int64(transaction.Amount),
+			})
+		}
+		if givenBy != 0 {
+			err = s.userRepo.DebitBalance(ctx, givenBy, cash, cards)
+			if err != nil {
+				return err
+			}
+		} else {
+			for _, card := range cards {
+				err = s.cardRepo.ReserveCards(ctx, card.CardNumber, card.Occupied)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		err = s.userRepo.CreditBalance(ctx, givenTo, cash, cards)
+		if err != nil {
+			return err
+		}
+		err = s.ledgerService.RecordMovements(ctx, movements)
+		if err != nil {
+			return err
+		}
+	}
+
+	users, err := s.userRepo.GetUsers(ctx, model.User{UserId: req.UserId})
+	if err != nil {
+		return err
+	}
+	if len(users) == 0 {
+		return helpers.NotFound("User not found")
+	}
+	title, outcome := "Transfer Rejected", "rejected"
+	if status == model.TRANSACTION_STATUS_SUCCESS {
+		title, outcome = "Transfer Accepted", "accepted"
+	}
+	err = s.notificationService.SendNotification(ctx, dto.SendNotificationRequest{
+		UserIds: []uint32{req.UserId},
+		Title:   title,
+		Message: fmt.Sprintf("You %s transfer %d.", outcome, req.TransactionGuid),
+	})
+	if err != nil {
+		return err
+	}
+	counterparty := givenBy
+	if counterparty == req.UserId {
+		counterparty = givenTo
+	}
+	if counterparty == 0 {
+		return nil
+	}
+	return s.notificationService.SendNotification(ctx, dto.SendNotificationRequest{
+		UserIds: []uint32{counterparty},
+		Title:   title,
+		Message: fmt.Sprintf("Your transfer %d was %s by user %s.", req.TransactionGuid, outcome, users[0].Name),
+	})
+}
+
+func (s *transactionService) CancelExchange(ctx context.Context, req dto.CancelExchangeRequest) *helpers.CustomError {
+	return s.unitOfWork.Run(ctx, func(sessCtx mongo.SessionContext) *helpers.CustomError {
+		return s.cancelExchange(sessCtx, req)
+	})
+}
+
+// lets the creator withdraw an exchange proposal the other side has not answered yet
+func (s *transactionService) cancelExchange(ctx context.Context, req dto.CancelExchangeRequest) *helpers.CustomError {
+	err := utils.ValidateCancelExchangeRequest(req)
+	if err != nil {
+		return err
+	}
+	cardTransactions, err := s.cardTransactionRepo.GetCardTransactionsByTransactionGuid(ctx, req.TransactionGuid)
+	if err != nil {
+		return err
+	}
+	cashTransactions, err := s.cashTransactionRepo.GetCashTransactionsByTransactionGuid(ctx, req.TransactionGuid)
+	if err != nil {
+		return err
+	}
+	if len(cardTransactions) == 0 && len(cashTransactions) == 0 {
+		return helpers.NotFound("No transaction found for the given transaction guid")
+	}
+	for _, transaction := range cardTransactions {
+		err = validateExchangeCancellation(req.UserId, transaction.Type, transaction.Status, transaction.CreatedBy)
+		if err != nil {
+			return err
+		}
+	}
+	for _, transaction := range cashTransactions {
+		err = validateExchangeCancellation(req.UserId, transaction.Type, transaction.Status, transaction.CreatedBy)
+		if err != nil {
+			return err
+		}
+	}
+	err = s.releaseHolds(ctx, cardTransactions, cashTransactions)
+	if err != nil {
+		return err
+	}
+	err = s.setTransactionStatus(ctx, cardTransactions, cashTransactions, model.TRANSACTION_STATUS_CANCELLED, req.UserId)
+	if err != nil {
+		return err
+	}
+
+	users, err := s.userRepo.GetUsers(ctx, model.User{UserId: req.UserId})
+	if err != nil {
+		return err
+	}
+	if len(users) == 0 {
+		return helpers.NotFound("User not found")
+	}
+	traders := []uint32{}
+	for _, userId := range exchangeParties(cardTransactions, cashTransactions) {
+		if userId != req.UserId {
+			traders = append(traders, userId)
+		}
+	}
+	if len(traders) == 0 {
+		return nil
+	}
+	return s.notificationService.SendNotification(ctx, dto.SendNotificationRequest{
+		UserIds: traders,
+		Title:   "Exchange Cancelled",
+		Message: fmt.Sprintf("User %s cancelled exchange %d.", users[0].Name, req.TransactionGuid),
+	})
+}
+
+// marks every exchange proposal whose expiry has passed as expired and notifies both
+// traders. Each exchange is expired in its own transaction and re-read there, so one
+// that was accepted or cancelled in the meantime is left alone.
+func (s *transactionService) ExpireExchanges(ctx context.Context, now time.Time) *helpers.CustomError {
+	cardTransactions, err := s.cardTransactionRepo.GetExpiredCardTransactions(ctx, now)
+	if err != nil {
+		return err
+	}
+	cashTransactions, err := s.cashTransactionRepo.GetExpiredCashTransactions(ctx, now)
+	if err != nil {
+		return err
+	}
+	guidSet := make(map[uint32]bool)
+	for _, transaction := range cardTransactions {
+		guidSet[transaction.TransactionGuid] = true
+	}
+	for _, transaction := range cashTransactions {
+		guidSet[transaction.TransactionGuid] = true
+	}
+	guids := []uint32{}
+	for guid := range guidSet {
+		guids = append(guids, guid)
+	}
+	sort.Slice(guids, func(i, j int) bool { return guids[i] < guids[j] })
+
+	expired := 0
+	for _, guid := range guids {
+		err = s.unitOfWork.Run(ctx, func(sessCtx mongo.SessionContext) *helpers.CustomError {
+			return s.expireExchange(sessCtx, guid, now)
+		})
+		if err != nil {
+			log.Printf("Error expiring exchange %d: %v", guid, err)
+			continue
+		}
+		expired++
+	}
+	log.Printf("Expired %d of %d exchange proposals", expired, len(guids))
+	return nil
+}
+
+func (s *transactionService) expireExchange(ctx context.Context, guid uint32, now time.Time) *helpers.CustomError {
+	cardTransactions, err := s.cardTransactionRepo.GetCardTransactionsByTransactionGuid(ctx, guid)
+	if err != nil {
+		return err
+	}
+	cashTransactions, err := s.cashTransactionRepo.GetCashTransactionsByTransactionGuid(ctx, guid)
+	if err != nil {
+		return err
+	}
+	for _, transaction := range cardTransactions {
+		if transaction.Status != model.TRANSACTION_STATUS_PENDING || !isExpired(transaction.ExpiresAt, now) {
+			return nil
+		}
+	}
+	for _, transaction := range cashTransactions {
+		if transaction.Status != model.TRANSACTION_STATUS_PENDING || !isExpired(transaction.ExpiresAt, now) {
+			return nil
+		}
+	}
+	err = s.releaseHolds(ctx, cardTransactions, cashTransactions)
+	if err != nil {
+		return err
+	}
+	err = s.setTransactionStatus(ctx, cardTransactions, cashTransactions, model.TRANSACTION_STATUS_EXPIRED, 0)
+	if err != nil {
+		return err
+	}
+	return s.notificationService.SendNotification(ctx, dto.SendNotificationRequest{
+		UserIds: exchangeParties(cardTransactions, cashTransactions),
+		Title:   "Exchange Expired",
+		Message: fmt.Sprintf("Exchange %d expired before it was answered.", guid),
+	})
+}
+
+func (s *transactionService) FailPendingTransactionsOfUser(ctx context.Context, userId uint32) *helpers.CustomError {
+	return s.unitOfWork.Run(ctx, func(sessCtx mongo.SessionContext) *helpers.CustomError {
+		return s.failPendingTransactionsOfUser(sessCtx, userId)
+	})
+}
+
+// fails every pending transfer, exchange and trade the user takes part in and gives
+// every participant's holds back, used before the user is wiped so that their
+// counterparties aren't left escrowed until the proposals expire
+func (s *transactionService) failPendingTransactionsOfUser(ctx context.Context, userId uint32) *helpers.CustomError {
+	filter := model.TransactionFilter{
+		UserId:    userId,
+		Status:    model.TRANSACTION_STATUS_PENDING,
+		Ascending: true,
+		Limit:     model.TRANSACTION_HISTORY_MAX_LIMIT,
+	}
+	cardGuids, err := pendingTransactionGuids(ctx, filter, s.cardTransactionRepo.GetCardTransactionGuids)
+	if err != nil {
+		return err
+	}
+	cashGuids, err := pendingTransactionGuids(ctx, filter, s.cashTransactionRepo.GetCashTransactionGuids)
+	if err != nil {
+		return err
+	}
+	for _, guid := range mergeTransactionGuids(cardGuids, cashGuids, true) {
+		cardTransactions, err := s.cardTransactionRepo.GetCardTransactionsByTransactionGuid(ctx, guid)
+		if err != nil {
+			return err
+		}
+		cashTransactions, err := s.cashTransactionRepo.GetCashTransactionsByTransactionGuid(ctx, guid)
+		if err != nil {
+			return err
+		}
+		err = s.releaseHolds(ctx, cardTransactions, cashTransactions)
+		if err != nil {
+			return err
+		}
+		err = s.setTransactionStatus(ctx, cardTransactions, cashTransactions, model.TRANSACTION_STATUS_FAILED, 0)
+		if err != nil {
+			return err
+		}
+		err = s.notificationService.SendNotification(ctx, dto.SendNotificationRequest{
+			UserIds: exchangeParties(cardTransactions, cashTransactions),
+			Title:   "Transaction Failed",
+			Message: fmt.Sprintf("Transaction %d failed because user %d was deactivated. Anything held for it has been returned.", guid, userId),
+		})
+		if err != nil {
+			log.Printf("Error sending failure notification for transaction %d: %v", guid, err)
+		}
+	}
+	return nil
+}
+
+// every guid the filter matches, page by page
+func pendingTransactionGuids(ctx context.Context, filter model.TransactionFilter, fetch func(context.Context, model.TransactionFilter) ([]uint32, *helpers.CustomError)) ([]uint32, *helpers.CustomError) {
+	guids := []uint32{}
+	for {
+		page, err := fetch(ctx, filter)
+		if err != nil {
+			return nil, err
+		}
+		guids = append(guids, page...)
+		if len(page) < filter.Limit {
+			return guids, nil
+		}
+		filter.Cursor = page[len(page)-1]
+	}
+}
+
+func (s *transactionService) setTransactionStatus(ctx context.Context, cardTransactions []model.CardTransaction, cashTransactions []model.CashTransaction, status string, updatedBy uint32) *helpers.CustomError {
+	for i := range cardTransactions {
+		cardTransactions[i].Status = status
+		cardTransactions[i].UpdatedBy = updatedBy
+	}
+	if len(cardTransactions) > 0 {
+		err := s.cardTransactionRepo.UpdateCardTransactions(ctx, cardTransactions)
+		if err != nil {
+			return err
+		}
+	}
+	for i := range cashTransactions {
+		cashTransactions[i].Status = status
+		cashTransactions[i].UpdatedBy = updatedBy
+	}
+	if len(cashTransactions) > 0 {
+		err := s.cashTransactionRepo.UpdateCashTransactions(ctx, cashTransactions)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *transactionService) CounterExchange(ctx context.Context, req dto.CounterExchangeRequest) (resp dto.CounterExchangeResponse, err *helpers.CustomError) {
+	err = s.unitOfWork.Run(ctx, func(sessCtx mongo.SessionContext) *helpers.CustomError {
+		resp, err = s.counterExchange(sessCtx, req)
+		return err
+	})
+	return resp, err
+}
+
+// answers a pending exchange with a modified one. The original is marked superseded and
+// the counter-offer is a new proposal from the receiver back to the creator, linked to
+// the original so the whole negotiation can be followed through its chain guid.
+func (s *transactionService) counterExchange(ctx context.Context, req dto.CounterExchangeRequest) (resp dto.CounterExchangeResponse, err *helpers.CustomError) {
+	err = utils.ValidateCounterExchangeRequest(req)
+	if err != nil {
+		return resp, err
+	}
+	cardTransactions, err := s.cardTransactionRepo.GetCardTransactionsByTransactionGuid(ctx, req.TransactionGuid)
+	if err != nil {
+		return resp, err
+	}
+	cashTransactions, err := s.cashTransactionRepo.GetCashTransactionsByTransactionGuid(ctx, req.TransactionGuid)
+	if err != nil {
+		return resp, err
+	}
+	if len(cardTransactions) == 0 && len(cashTransactions) == 0 {
+		return resp, helpers.NotFound("No transaction found for the given transaction guid")
+	}
+
+	now := time.Now()
+	var creator, chainGuid uint32
+	proposals := []dto.IsvalidTransactionConfirmerRequest{}
+	check := func(transactionType string, status string, expiresAt primitive.DateTime, proposal dto.IsvalidTransactionConfirmerRequest, transactionChainGuid uint32) *helpers.CustomError {
+		if transactionType == model.TRANSACTION_TYPE_TRANSFER || transactionType == model.TRANSACTION_TYPE_TRADE {
+			return helpers.BadRequest("Only exchanges can be countered")
+		}
+		if status != model.TRANSACTION_STATUS_PENDING {
+			return helpers.BadRequest("Transaction is not in pending state")
+		}
+		if isExpired(expiresAt, now) {
+			return helpers.BadRequest("Exchange has expired")
+		}
+		creator, chainGuid = proposal.CreatedBy, transactionChainGuid
+		proposals = append(proposals, proposal)
+		return nil
+	}
+	for _, transaction := range cardTransactions {
+		err = check(transaction.Type, transaction.Status, transaction.ExpiresAt, dto.IsvalidTransactionConfirmerRequest{
+			UserId:    req.UserId,
+			CreatedBy: transaction.CreatedBy,
+			GivenBy:   transaction.GivenBy,
+			GivenTo:   transaction.GivenTo,
+		}, transaction.ChainGuid)
+		if err != nil {
+			return resp, err
+		}
+	}
+	for _, transaction := range cashTransactions {
+		err = check(transaction.Type, transaction.Status, transaction.ExpiresAt, dto.IsvalidTransactionConfirmerRequest{
+			UserId:    req.UserId,
+			CreatedBy: transaction.CreatedBy,
+			GivenBy:   transaction.GivenBy,
+			GivenTo:   transaction.GivenTo,
+		}, transaction.ChainGuid)
+		if err != nil {
+			return resp, err
+		}
+	}
+	// only the trader who received the proposal may counter it, same as confirming it
+	for _, proposal := range proposals {
+		err = utils.IsValidTransactionConfirmer(proposal)
+		if err != nil {
+			return resp, err
+		}
+	}
+	if chainGuid == 0 {
+		chainGuid = req.TransactionGuid
+	}
+
+	err = s.releaseHolds(ctx, cardTransactions, cashTransactions)
+	if err != nil {
+		return resp, err
+	}
+	err = s.setTransactionStatus(ctx, cardTransactions, cashTransactions, model.TRANSACTION_STATUS_SUPERSEDED, req.UserId)
+	if err != nil {
+		return resp, err
+	}
+	counterGuid, err := s.createExchange(ctx, dto.ExchangeRequest{
+		GivenBy:       req.UserId,
+		GivenTo:       creator,
+		CashSent:      req.CashSent,
+		CashRecieved:  req.CashRecieved,
+		CardsSent:     req.CardsSent,
+		CardsRecieved: req.CardsRecieved,
+		UserId:        req.UserId,
+	}, req.TransactionGuid, chainGuid)
+	if err != nil {
+		return resp, err
+	}
+
+	users, err := s.userRepo.GetUsers(ctx, model.User{UserId: req.UserId})
+	if err != nil {
+		return resp, err
+	}
+	if len(users) == 0 {
+		return resp, helpers.NotFound("User not found")
+	}
+	err = s.notificationService.SendNotification(ctx, dto.SendNotificationRequest{
+		UserIds: []uint32{creator},
+		Title:   "Exchange Countered",
+		Message: fmt.Sprintf("User %s answered exchange %d with counter-offer %d.", users[0].Name, req.TransactionGuid, counterGuid),
+	})
+	if err != nil {
+		return resp, err
+	}
+	return dto.CounterExchangeResponse{
+		TransactionGuid: counterGuid,
+		ChainGuid:       chainGuid,
+	}, nil
+}
+
+func (s *transactionService) Trade(ctx context.Context, req dto.TradeRequest) (resp dto.TradeResponse, err *helpers.CustomError) {
+	err = s.unitOfWork.Run(ctx, func(sessCtx mongo.SessionContext) *helpers.CustomError {
+		resp, err = s.trade(sessCtx, req)
+		if err != nil {
+			return err
+		}
+		return s.idempotencyService.Commit(sessCtx, req.UserId, req.IdempotencyKey)
+	})
+	return resp, err
+}
+
+// proposes a trade between three or more users. Every leg is stored as card and cash
+// transactions under one guid, the creator approves it by proposing and has what they
+// give put on hold, like the proposer of an exchange.
+func (s *transactionService) trade(ctx context.Context, req dto.TradeRequest) (resp dto.TradeResponse, err *helpers.CustomError) {
+	err = utils.ValidateTradeRequest(req)
+	if err != nil {
+		return resp, err
+	}
+	cardNumbers := []string{}
+	for _, leg := range req.Legs {
+		for _, card := range leg.Cards {
+			cardNumbers = append(cardNumbers, card.CardNumber)
+		}
+	}
+	err = s.checkCardNumbersNotRetired(ctx, cardNumbers)
+	if err != nil {
+		return resp, err
+	}
+	gives := make(map[uint32]*exchangeBalanceChange)
+	for _, leg := range req.Legs {
+		if gives[leg.GivenBy] == nil {
+			gives[leg.GivenBy] = &exchangeBalanceChange{}
+		}
+		gives[leg.GivenBy].cashOut += leg.Cash
+		for _, card := range leg.Cards {
+			gives[leg.GivenBy].cardsOut = append(gives[leg.GivenBy].cardsOut, model.CardOccupied{CardNumber: card.CardNumber, Occupied: card.Amount})
+		}
+	}
+	names := make(map[uint32]string)
+	for _, leg := range req.Legs {
+		for _, userId := range []uint32{leg.GivenBy, leg.GivenTo} {
+			if _, ok := names[userId]; ok {
+				continue
+			}
+			users, err := s.userRepo.GetUsers(ctx, model.User{UserId: userId})
+			if err != nil {
+				return resp, err
+			}
+			if len(users) == 0 {
+				return resp, helpers.NotFound("User not found")
+			}
+			names[userId] = users[0].Name
+			if give, ok := gives[userId]; ok {
+				err = hasFreeBalance(users[0], give.cashOut, give.cardsOut)
+				if err != nil {
+					return resp, err
+				}
+			}
+		}
+	}
+
+	expiresAt := primitive.NewDateTimeFromTime(time.Now().Add(s.exchangeTTL))
+	cardTransactions := []model.CardTransaction{}
+	cashTransactions := []model.CashTransaction{}
+	for _, leg := range req.Legs {
+		for _, card := range leg.Cards {
+			cardTransactions = append(cardTransactions, model.CardTransaction{
+				CardNumber: card.CardNumber,
+				Amount:     card.Amount,
+				GivenBy:    leg.GivenBy,
+				GivenTo:    leg.GivenTo,
+				Status:     model.TRANSACTION_STATUS_PENDING,
+				CreatedBy:  req.UserId,
+				Type:       model.TRANSACTION_TYPE_TRADE,
+				ExpiresAt:  expiresAt,
+				Held:       leg.GivenBy == req.UserId,
+				ApprovedBy: []uint32{req.UserId},
+			})
+		}
+		if leg.Cash > 0 {
+			cashTransactions = append(cashTransactions, model.CashTransaction{
+				Amount:     leg.Cash,
+				GivenBy:    leg.GivenBy,
+				GivenTo:    leg.GivenTo,
+				Status:     model.TRANSACTION_STATUS_PENDING,
+				CreatedBy:  req.UserId,
+				Type:       model.TRANSACTION_TYPE_TRADE,
+				ExpiresAt:  expiresAt,
+				Held:       leg.GivenBy == req.UserId,
+				ApprovedBy: []uint32{req.UserId},
+			})
+		}
+	}
+	tradeGuid := uint32(0)
+	if len(cardTransactions) > 0 {
+		tradeGuid, err = s.cardTransactionRepo.AddCardTransactions(ctx, cardTransactions)
+		if err != nil {
+			return resp, err
+		}
+	}
+	for _, transaction := range cashTransactions {
+		transaction.TransactionGuid = tradeGuid
+		tradeGuid, err = s.cashTransactionRepo.AddCashTransaction(ctx, transaction)
+		if err != nil {
+			return resp, err
+		}
+	}
+	if give, ok := gives[req.UserId]; ok {
+		err = s.userRepo.HoldBalance(ctx, req.UserId, give.cashOut, give.cardsOut)
+		if err != nil {
+			return resp, err
+		}
+	}
+
+	others := []uint32{}
+	for userId := range names {
+		if userId != req.UserId {
+			others = append(others, userId)
+		}
+	}
+	sort.Slice(others, func(i, j int) bool { return others[i] < others[j] })
+	err = s.notificationService.SendNotification(ctx, dto.SendNotificationRequest{
+		UserIds: others,
+		Title:   "Trade Proposed",
+		Message: fmt.Sprintf("User %s proposed trade %d with %d participants. It settles once everyone approved it.", names[req.UserId], tradeGuid, len(names)),
+	})
+	if err != nil {
+		return resp, err
+	}
+	log.Printf("Trade created with GUID: %d\n", tradeGuid)
+	return dto.TradeResponse{TransactionGuid: tradeGuid}, nil
+}
+
+func (s *transactionService) RespondTrade(ctx context.Context, req dto.RespondTradeRequest) *helpers.CustomError {
+	return s.unitOfWork.Run(ctx, func(sessCtx mongo.SessionContext) *helpers.CustomError {
+		return s.respondTrade(sessCtx, req)
+	})
+}
+
+// approves or rejects a pending trade. An approval puts what the participant gives on
+// hold; the approval that completes the set settles every leg at once from the holds.
+// A single rejection fails the whole trade and gives all holds back.
+func (s *transactionService) respondTrade(ctx context.Context, req dto.RespondTradeRequest) *helpers.CustomError {
+	err := utils.ValidateRespondTradeRequest(req)
+	if err != nil {
+		return err
+	}
+	cardTransactions, err := s.cardTransactionRepo.GetCardTransactionsByTransactionGuid(ctx, req.TransactionGuid)
+	if err != nil {
+		return err
+	}
+	cashTransactions, err := s.cashTransactionRepo.GetCashTransactionsByTransactionGuid(ctx, req.TransactionGuid)
+	if err != nil {
+		return err
+	}
+	if len(cardTransactions) == 0 && len(cashTransactions) == 0 {
+		return helpers.NotFound("No transaction found for the given transaction guid")
+	}
+	now := time.Now()
+	approvedBy := []uint32{}
+	for _, transaction := range cardTransactions {
+		err = validateTradeResponse(transaction.Type, transaction.Status, transaction.ExpiresAt, now)
+		if err != nil {
+			return err
+		}
+		approvedBy = transaction.ApprovedBy
+	}
+	for _, transaction := range cashTransactions {
+		err = validateTradeResponse(transaction.Type, transaction.Status, transaction.ExpiresAt, now)
+		if err != nil {
+			return err
+		}
+		approvedBy = transaction.ApprovedBy
+	}
+	participants := exchangeParties(cardTransactions, cashTransactions)
+	approved := make(map[uint32]bool)
+	for _, userId := range approvedBy {
+		approved[userId] = true
+	}
+	isParticipant := false
+	for _, userId := range participants {
+		isParticipant = isParticipant || userId == req.UserId
+	}
+	if !isParticipant {
+		return helpers.Unauthorized("Only participants of a trade can respond to it")
+	}
+	if approved[req.UserId] {
+		return helpers.BadRequest("You have already approved this trade")
+	}
+	users, err := s.userRepo.GetUsers(ctx, model.User{UserId: req.UserId})
+	if err != nil {
+		return err
+	}
+	if len(users) == 0 {
+		return helpers.NotFound("User not found")
+	}
+	user := users[0]
+
+	if !req.IsAccepted {
+		err = s.releaseHolds(ctx, cardTransactions, cashTransactions)
+		if err != nil {
+			return err
+		}
+		err = s.setTransactionStatus(ctx, cardTransactions, cashTransactions, model.TRANSACTION_STATUS_FAILED, req.UserId)
+		if err != nil {
+			return err
+		}
+		return s.notificationService.SendNotification(ctx, dto.SendNotificationRequest{
+			UserIds: participants,
+			Title:   "Trade Rejected",
+			Message: fmt.Sprintf("User %s rejected trade %d.", user.Name, req.TransactionGuid),
+		})
+	}
+
+	err = s.checkCardTransactionsNotRetired(ctx, cardTransactions)
+	if err != nil {
+		return err
+	}
+	give := exchangeBalanceChange{}
+	for i, transaction := range cardTransactions {
+		if transaction.GivenBy == req.UserId {
+			give.cardsOut = append(give.cardsOut, model.CardOccupied{CardNumber: transaction.CardNumber, Occupied: transaction.Amount})
+			cardTransactions[i].Held = true
+		}
+	}
+	for i, transaction := range cashTransactions {
+		if transaction.GivenBy == req.UserId {
+			give.cashOut += transaction.Amount
+			cashTransactions[i].Held = true
+		}
+	}
+	err = s.userRepo.HoldBalance(ctx, req.UserId, give.cashOut, give.cardsOut)
+	if err != nil {
+		return err
+	}
+	err = s.cardTransactionRepo.ApproveCardTransactions(ctx, req.TransactionGuid, req.UserId)
+	if err != nil {
+		return err
+	}
+	err = s.cashTransactionRepo.ApproveCashTransactions(ctx, req.TransactionGuid, req.UserId)
+	if err != nil {
+		return err
+	}
+	approved[req.UserId] = true
+	for _, userId := range participants {
+		if !approved[userId] {
+			return s.notificationService.SendNotification(ctx, dto.SendNotificationRequest{
+				UserIds: participants,
+				Title:   "Trade Approved",
+				Message: fmt.Sprintf("User %s approved trade %d. %d of %d participants have approved it.", user.Name, req.TransactionGuid, len(approved), len(participants)),
+			})
+		}
+	}
+	err = s.settleTrade(ctx, cardTransactions, cashTransactions, req.UserId)
+	if err != nil {
+		return err
+	}
+	return s.notificationService.SendNotification(ctx, dto.SendNotificationRequest{
+		UserIds: participants,
+		Title:   "Trade Completed",
+		Message: fmt.Sprintf("Every participant approved trade %d, it has been settled.", req.TransactionGuid),
+	})
+}
+
+// moves every leg of a fully approved trade. All legs are held by then, so each giver
+// pays from their holds and nothing else can have spent them in between.
+func (s *transactionService) settleTrade(ctx context.Context, cardTransactions []model.CardTransaction, cashTransactions []model.CashTransaction, updatedBy uint32) *helpers.CustomError {
+	err := s.setTransactionStatus(ctx, cardTransactions, cashTransactions, model.TRANSACTION_STATUS_SUCCESS, updatedBy)
+	if err != nil {
+		return err
+	}
+	changes := make(map[uint32]*exchangeBalanceChange)
+	change := func(userId uint32) *exchangeBalanceChange {
+		if changes[userId] == nil {
+			changes[userId] = &exchangeBalanceChange{}
+		}
+		return changes[userId]
+	}
+	movements := []model.LedgerMovement{}
+	for _, transaction := range cardTransactions {
+		card := model.CardOccupied{CardNumber: transaction.CardNumber, Occupied: transaction.Amount}
+		change(transaction.GivenBy).heldCardsOut = append(change(transaction.GivenBy).heldCardsOut, card)
+		change(transaction.GivenTo).cardsIn = append(change(transaction.GivenTo).cardsIn, card)
+		movements = append(movements, model.LedgerMovement{
+			Kind:            model.LEDGER_KIND_TRADE,
+			TransactionGuid: transaction.TransactionGuid,
+			From:            transaction.GivenBy,
+			To:              transaction.GivenTo,
+			Asset:           transaction.CardNumber,
+			Amount:          int64(transaction.Amount),
+		})
+	}
+	for _, transaction := range cashTransactions {
+		change(transaction.GivenBy).heldCashOut += transaction.Amount
+		change(transaction.GivenTo).cashIn += transaction.Amount
+		movements = append(movements, model.LedgerMovement{
+			Kind:            model.LEDGER_KIND_TRADE,
+			TransactionGuid: transaction.TransactionGuid,
+			From:            transaction.GivenBy,
+			To:              transaction.GivenTo,
+			Asset:           model.LEDGER_ASSET_CASH,
+			Amount:          int64(transaction.Amount),
+		})
+	}
+	userIds := []uint32{}
+	for userId := range changes {
+		userIds = append(userIds, userId)
+	}
+	sort.Slice(userIds, func(i, j int) bool { return userIds[i] < userIds[j] })
+	for _, userId := range userIds {
+		err = s.userRepo.DebitHeld(ctx, userId, changes[userId].heldCashOut, changes[userId].heldCardsOut)
+		if err != nil {
+			return err
+		}
+	}
+	for _, userId := range userIds {
+		err = s.userRepo.CreditBalance(ctx, userId, changes[userId].cashIn, changes[userId].cardsIn)
+		if err != nil {
+			return err
+		}
+	}
+	return s.ledgerService.RecordMovements(ctx, movements)
+}
+
+func validateTradeResponse(transactionType string, status string, expiresAt primitive.DateTime, now time.Time) *helpers.CustomError {
+	if transactionType != model.TRANSACTION_TYPE_TRADE {
+		return helpers.BadRequest("Transaction is not a trade")
+	}
+	if status != model.TRANSACTION_STATUS_PENDING {
+		return helpers.BadRequest("Transaction is not in pending state")
+	}
+	if isExpired(expiresAt, now) {
+		return helpers.BadRequest("Trade has expired")
+	}
+	return nil
+}
+
+// checks the free cash and card quantities of a user, holds and locked collateral excluded
+func hasFreeBalance(user model.User, cash model.Money, cards []model.CardOccupied) *helpers.CustomError {
+	if user.Cash < cash {
+		return helpers.BadRequest(fmt.Sprintf("Insufficient cash for user %d", user.UserId))
+	}
+	free := make(map[string]uint32)
+	for _, card := range user.Cards {
+		free[card.CardNumber] += card.Occupied
+	}
+	needed := make(map[string]uint32)
+	for _, card := range cards {
+		needed[card.CardNumber] += card.Occupied
+	}
+	for cardNumber, amount := range needed {
+		if free[cardNumber] < amount {
+			return helpers.BadRequest(fmt.Sprintf("Insufficient balance for card %s of user %d", cardNumber, user.UserId))
+		}
+	}
+	return nil
+}
+
+// transfers are answered through respond_transfer, exchanges written before
+// transactions had a type are treated as exchanges. Trades are cancelled the same way.
+func validateExchangeCancellation(userId uint32, transactionType string, status string, createdBy uint32) *helpers.CustomError {
+	if transactionType == model.TRANSACTION_TYPE_TRANSFER {
+		return helpers.BadRequest("Only exchanges and trades can be cancelled")
+	}
+	if status != model.TRANSACTION_STATUS_PENDING {
+		return helpers.BadRequest("Transaction is not in pending state")
+	}
+	if createdBy != userId {
+		return helpers.Unauthorized("Only the creator of an exchange can cancel it")
+	}
+	return nil
+}
+
+// everyone giving or receiving something in the exchange, the system account excluded
+func exchangeParties(cardTransactions []model.CardTransaction, cashTransactions []model.CashTransaction) []uint32 {
+	parties := []uint32{}
+	seen := map[uint32]bool{0: true}
+	add := func(userIds ...uint32) {
+		for _, userId := range userIds {
+			if !seen[userId] {
+				seen[userId] = true
+				parties = append(parties, userId)
+			}
+		}
+	}
+	for _, transaction := range cardTransactions {
+		add(transaction.GivenBy, transaction.GivenTo)
+	}
+	for _, transaction := range cashTransactions {
+		add(transaction.GivenBy, transaction.GivenTo)
+	}
+	return parties
+}
+
+// copies the type and proposal details of a guid onto its history entry. An exchange
+// that was never countered is the first proposal of its own chain.
+func setProposalDetails(transaction *dto.Transaction, transactionType string, expiresAt primitive.DateTime, parentGuid uint32, chainGuid uint32) {
+	if transaction.Type != "" || transactionType == "" {
+		return
+	}
+	transaction.Type = transactionType
+	if expiresAt != 0 {
+		transaction.ExpiresAt = expiresAt.Time()
+	}
+	if transactionType != model.TRANSACTION_TYPE_EXCHANGE {
+		return
+	}
+	transaction.ParentGuid = parentGuid
+	transaction.ChainGuid = chainGuid
+	if chainGuid == 0 {
+		transaction.ChainGuid = transaction.TransactionGuid
+	}
+}
+
+// a participant only sees the legs they give or receive, so the full list of
+// participants and approvals of a trade is read from all of its legs
+func (s *transactionService) setTradeDetails(ctx context.Context, transaction *dto.Transaction) *helpers.CustomError {
+	cardTransactions, err := s.cardTransactionRepo.GetCardTransactionsByTransactionGuid(ctx, transaction.TransactionGuid)
+	if err != nil {
+		return err
+	}
+	cashTransactions, err := s.cashTransactionRepo.GetCashTransactionsByTransactionGuid(ctx, transaction.TransactionGuid)
+	if err != nil {
+		return err
+	}
+	transaction.Participants = exchangeParties(cardTransactions, cashTransactions)
+	sort.Slice(transaction.Participants, func(i, j int) bool { return transaction.Participants[i] < transaction.Participants[j] })
+	if len(cardTransactions) > 0 {
+		transaction.ApprovedBy = cardTransactions[0].ApprovedBy
+	} else if len(cashTransactions) > 0 {
+		transaction.ApprovedBy = cashTransactions[0].ApprovedBy
+	}
+	return nil
+}
+
+func isExpired(expiresAt primitive.DateTime, now time.Time) bool {
+	return expiresAt != 0 && now.After(expiresAt.Time())
+}
+
+// what one side of an exchange gives and gets, split by whether what it gives was
+// put on hold at proposal time
+type exchangeBalanceChange struct {
+	cashOut      model.Money
+	heldCashOut  model.Money
+	cashIn       model.Money
+	cardsOut     []model.CardOccupied
+	heldCardsOut []model.CardOccupied
+	cardsIn      []model.CardOccupied
+}
+
+// gives the held legs of an exchange that did not settle back to the users who
+// promised them. A deactivated user was wiped together with their holds.
+func (s *transactionService) releaseHolds(ctx context.Context, cardTransactions []model.CardTransaction, cashTransactions []model.CashTransaction) *helpers.CustomError {
+	holds := make(map[uint32]*exchangeBalanceChange)
+	hold := func(userId uint32) *exchangeBalanceChange {
+		if holds[userId] == nil {
+			holds[userId] = &exchangeBalanceChange{}
+		}
+		return holds[userId]
+	}
+	for _, transaction := range cardTransactions {
+		if transaction.Held {
+			giver := hold(transaction.GivenBy)
+			giver.heldCardsOut = append(giver.heldCardsOut, model.CardOccupied{CardNumber: transaction.CardNumber, Occupied: transaction.Amount})
+		}
+	}
+	for _, transaction := range cashTransactions {
+		if transaction.Held {
+			hold(transaction.GivenBy).heldCashOut += transaction.Amount
+		}
+	}
+	for userId, change := range holds {
+		users, err := s.userRepo.GetUsers(ctx, model.User{UserId: userId})
+		if err != nil {
+			return err
+		}
+		if len(users) == 0 {
+			return helpers.NotFound("User not found")
+		}
+		if users[0].Deactivated {
+			continue
+		}
+		err = s.userRepo.ReleaseHold(ctx, userId, change.heldCashOut, change.heldCardsOut)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func transferLedgerKind(loanId uint32) string {
+	if loanId != 0 {
+		return model.LEDGER_KIND_LOAN
+	}
+	return model.LEDGER_KIND_TRANSFER
+}
+
+// union of the guids both transaction collections returned for a page, in page order
+func mergeTransactionGuids(cardGuids []uint32, cashGuids []uint32, ascending bool) []uint32 {
+	seen := make(map[uint32]bool)
+	guids := make([]uint32, 0, len(cardGuids)+len(cashGuids))
+	for _, guid := range append(append([]uint32{}, cardGuids...), cashGuids...) {
+		if !seen[guid] {
+			seen[guid] = true
+			guids = append(guids, guid)
+		}
+	}
+	sort.Slice(guids, func(i, j int) bool {
+		if ascending {
+			return guids[i] < guids[j]
+		}
+		return guids[i] > guids[j]
+	})
+	return guids
+}
+
+// each collection is asked for one more guid than the page holds, so a guid left over
+// after merging means there is a next page, which starts after the last guid returned
+func pageTransactionGuids(cardGuids []uint32, cashGuids []uint32, ascending bool, limit int) ([]uint32, uint32) {
+	guids := mergeTransactionGuids(cardGuids, cashGuids, ascending)
+	if len(guids) <= limit {
+		return guids, 0
+	}
+	guids = guids[:limit]
+	return guids, guids[limit-1]
+}
+
+func checkCardsNotRetired(cards []model.Card) *helpers.CustomError {
+	for _, card := range cards {
+		if card.Retired {
+			return helpers.BadRequest("Card " + card.Number + " is retired and can no longer be minted or traded")
+		}
+	}
+	return nil
+}
+
+func (s *transactionService) checkCardNumbersNotRetired(ctx context.Context, cardNumbers []string) *helpers.CustomError {
+	if len(cardNumbers) == 0 {
+		return nil
+	}
+	cards, err := s.cardRepo.GetCards(ctx, model.GetCardsRequest{Numbers: cardNumbers})
+	if err != nil {
+		return err
+	}
+	return checkCardsNotRetired(cards)
+}
+
+// cards of pending legs are checked again when they settle, they may have been
+// retired since the proposal
+func (s *transactionService) checkCardTransactionsNotRetired(ctx context.Context, cardTransactions []model.CardTransaction) *helpers.CustomError {
+	cardNumbers := []string{}
+	for _, transaction := range cardTransactions {
+		cardNumbers = append(cardNumbers, transaction.CardNumber)
+	}
+	return s.checkCardNumbersNotRetired(ctx, cardNumbers)
+}
@@ -0,0 +1,104 @@
+package service
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestMergeTransactionGuids(t *testing.T) {
+	cardGuids := []uint32{9, 5, 5, 2}
+	cashGuids := []uint32{7, 5, 3}
+	if got := mergeTransactionGuids(cardGuids, cashGuids, false); !reflect.DeepEqual(got, []uint32{9, 7, 5, 3, 2}) {
+		t.Errorf("descending merge = %v", got)
+	}
+	if got := mergeTransactionGuids(cardGuids, cashGuids, true); !reflect.DeepEqual(got, []uint32{2, 3, 5, 7, 9}) {
+		t.Errorf("ascending merge = %v", got)
+	}
+	if !reflect.DeepEqual(cardGuids, []uint32{9, 5, 5, 2}) || !reflect.DeepEqual(cashGuids, []uint32{7, 5, 3}) {
+		t.Errorf("merge changed its input: %v %v", cardGuids, cashGuids)
+	}
+	if got := mergeTransactionGuids(nil, nil, false); len(got) != 0 {
+		t.Errorf("empty merge = %v", got)
+	}
+}
+
+func TestPageTransactionGuids(t *testing.T) {
+	cases := []struct {
+		name       string
+		cardGuids  []uint32
+		cashGuids  []uint32
+		limit      int
+		want       []uint32
+		nextCursor uint32
+	}{
+		{name: "fewer than a page", cardGuids: []uint32{5, 3}, cashGuids: []uint32{4}, limit: 5, want: []uint32{5, 4, 3}},
+		{name: "exactly a page", cardGuids: []uint32{5, 3}, cashGuids: []uint32{4}, limit: 3, want: []uint32{5, 4, 3}},
+		{name: "one more than a page", cardGuids: []uint32{5, 3}, cashGuids: []uint32{4, 2}, limit: 3, want: []uint32{5, 4, 3}, nextCursor: 3},
+		{name: "duplicates do not count twice", cardGuids: []uint32{5, 4, 3}, cashGuids: []uint32{5, 4, 3}, limit: 3, want: []uint32{5, 4, 3}},
+		{name: "empty", limit: 3, want: []uint32{}},
+	}
+	for _, c := range cases {
+		got, nextCursor := pageTransactionGuids(c.cardGuids, c.cashGuids, false, c.limit)
+		if !reflect.DeepEqual(got, c.want) || nextCursor != c.nextCursor {
+			t.Errorf("%s: got %v with cursor %d, want %v with cursor %d", c.name, got, nextCursor, c.want, c.nextCursor)
+		}
+	}
+}
+
+// walks every page the way a client does and checks each transaction shows up
+// exactly once, in order, with no cursor on the last page
+func TestTransactionPagesCoverEveryGuidOnce(t *testing.T) {
+	// guids 10 and 20 have both a cash and a card leg, guid 10 has two card legs
+	cardLegs := []uint32{1, 4, 10, 10, 11, 12, 20, 25}
+	cashLegs := []uint32{2, 3, 10, 13, 20, 21, 22}
+	want := mergeTransactionGuids(cardLegs, cashLegs, true)
+
+	for _, ascending := range []bool{true, false} {
+		for limit := 1; limit <= len(want)+1; limit++ {
+			seen := []uint32{}
+			cursor := uint32(0)
+			for pages := 0; ; pages++ {
+				if pages > len(want) {
+					t.Fatalf("ascending %v, limit %d: pagination does not end", ascending, limit)
+				}
+				// what the repositories return: distinct guids past the cursor, at most limit+1
+				cardGuids := queryGuids(cardLegs, cursor, ascending, limit+1)
+				cashGuids := queryGuids(cashLegs, cursor, ascending, limit+1)
+				page, nextCursor := pageTransactionGuids(cardGuids, cashGuids, ascending, limit)
+				if len(page) > limit {
+					t.Fatalf("ascending %v, limit %d: page of %d guids", ascending, limit, len(page))
+				}
+				seen = append(seen, page...)
+				if nextCursor == 0 {
+					break
+				}
+				if nextCursor != page[len(page)-1] {
+					t.Fatalf("ascending %v, limit %d: cursor %d is not the last guid of %v", ascending, limit, nextCursor, page)
+				}
+				cursor = nextCursor
+			}
+			expected := append([]uint32{}, want...)
+			if !ascending {
+				sort.Slice(expected, func(i, j int) bool { return expected[i] > expected[j] })
+			}
+			if !reflect.DeepEqual(seen, expected) {
+				t.Errorf("ascending %v, limit %d: pages gave %v, want %v", ascending, limit, seen, expected)
+			}
+		}
+	}
+}
+
+func queryGuids(legs []uint32, cursor uint32, ascending bool, limit int) []uint32 {
+	guids := []uint32{}
+	for _, guid := range mergeTransactionGuids(legs, nil, ascending) {
+		if cursor != 0 && (ascending && guid <= cursor || !ascending && guid >= cursor) {
+			continue
+		}
+		if len(guids) == limit {
+			break
+		}
+		guids = append(guids, guid)
+	}
+	return guids
+}
@@ -0,0 +1,49 @@
+package service
+
+import (
+	"context"
+
+	"github.com/ChronoPlay/chronoplay-backend-service/helpers"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// UnitOfWork runs a group of writes as one mongo transaction. The work gets a session
+// context which has to be passed to every repository call that should be part of it.
+type UnitOfWork interface {
+	Run(ctx context.Context, work func(sessCtx mongo.SessionContext) *helpers.CustomError) *helpers.CustomError
+}
+
+type unitOfWork struct {
+	client *mongo.Client
+}
+
+func NewUnitOfWork(client *mongo.Client) UnitOfWork {
+	return &unitOfWork{client: client}
+}
+
+// The whole work is retried while mongo labels the failure as a transient transaction
+// error (e.g. a write conflict) and the commit is retried on unknown commit results.
+// When ctx already carries a session the work simply joins that transaction, so
+// services can call each other without opening nested transactions.
+func (u *unitOfWork) Run(ctx context.Context, work func(sessCtx mongo.SessionContext) *helpers.CustomError) *helpers.CustomError {
+	if session := mongo.SessionFromContext(ctx); session != nil {
+		return work(mongo.NewSessionContext(ctx, session))
+	}
+
+	session, err := u.client.StartSession()
+	if err != nil {
+		return helpers.SystemError("Failed to start session", err)
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+		if werr := work(sessCtx); werr != nil {
+			return nil, werr
+		}
+		return nil, nil
+	})
+	if err != nil {
+		return helpers.NoType(err)
+	}
+	return nil
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"net/http"
 
 	"go.mongodb.org/mongo-driver/mongo"
 
@@ -24,18 +25,22 @@ type UserService interface {
 	RemoveFriend(ctx context.Context, req *dto.AddFriendRequest) *helpers.CustomError
 	GetAllActiveUsers() ([]model.User, *helpers.CustomError)
 	UpdateUser(ctx context.Context, req model.User) *helpers.CustomError
+	DeductCash(ctx context.Context, userId uint32, amount model.Money) *helpers.CustomError
+	WipeUser(ctx context.Context, userId uint32) *helpers.CustomError
 	ActivateAllUsers(ctx context.Context) *helpers.CustomError
 }
 
 type userService struct {
-	userRepo model.UserRepository
-	cardRepo model.CardRepository
+	userRepo   model.UserRepository
+	cardRepo   model.CardRepository
+	unitOfWork UnitOfWork
 }
 
-func NewUserService(userRepo model.UserRepository, cardRepo model.CardRepository) UserService {
+func NewUserService(userRepo model.UserRepository, cardRepo model.CardRepository, unitOfWork UnitOfWork) UserService {
 	return &userService{
-		userRepo: userRepo,
-		cardRepo: cardRepo,
+		userRepo:   userRepo,
+		cardRepo:   cardRepo,
+		unitOfWork: unitOfWork,
 	}
 }
 
@@ -83,14 +88,7 @@ func (s *userService) RegisterUser(ctx context.Context, req model.User) (err *he
 	req.IsAuthorized = false
 
 	log.Println("Entered here - RegisterUser (userService)")
-	session, derr := s.userRepo.GetCollection().Database().Client().StartSession()
-	if derr != nil {
-		return helpers.System("Failed to start session: " + derr.Error())
-	}
-	defer session.EndSession(ctx)
-	log.Println("Successfully created session")
-
-	merr := mongo.WithSession(ctx, session, func(sessCtx mongo.SessionContext) error {
+	err = s.unitOfWork.Run(ctx, func(sessCtx mongo.SessionContext) *helpers.CustomError {
 		// You can use sessCtx instead of ctx for transactional operations
 
 		existingUsers, err := s.userRepo.GetUsers(sessCtx, model.User{
@@ -125,8 +123,8 @@ func (s *userService) RegisterUser(ctx context.Context, req model.User) (err *he
 		return nil // Will commit if nil
 	})
 
-	if merr != nil {
-		return helpers.NoType(merr)
+	if err != nil {
+		return err
 	}
 
 	emailVerificationLink := utils.GenrateEmailVerificationLink(req.Email)
@@ -142,7 +140,13 @@ func (s *userService) RegisterUser(ctx context.Context, req model.User) (err *he
 	return nil
 }
 
-func (s *userService) VerifyUser(ctx context.Context, req dto.VerifyUserRequest) (err *helpers.CustomError) {
+func (s *userService) VerifyUser(ctx context.Context, req dto.VerifyUserRequest) *helpers.CustomError {
+	return retryOnConflict(func() *helpers.CustomError {
+		return s.verifyUser(ctx, req)
+	})
+}
+
+func (s *userService) verifyUser(ctx context.Context, req dto.VerifyUserRequest) (err *helpers.CustomError) {
 	if req.Email == "" {
 		return helpers.BadRequest("Email is required")
 	}
@@ -162,7 +166,7 @@ func (s *userService) VerifyUser(ctx context.Context, req dto.VerifyUserRequest)
 
 	err = s.userRepo.UpdateUser(ctx, existingUser)
 	if err != nil {
-		return helpers.System(err.Error())
+		return err
 	}
 	return nil
 }
@@ -221,6 +225,12 @@ func (s *userService) LoginUser(ctx context.Context, req dto.LoginUserRequest) (
 
 // add friend
 func (s *userService) AddFriend(ctx context.Context, req *dto.AddFriendRequest) *helpers.CustomError {
+	return retryOnConflict(func() *helpers.CustomError {
+		return s.addFriend(ctx, req)
+	})
+}
+
+func (s *userService) addFriend(ctx context.Context, req *dto.AddFriendRequest) *helpers.CustomError {
 	if req.UserID == req.FriendID {
 		return helpers.BadRequest("user cannot add themselves as a friend")
 	}
@@ -297,6 +307,12 @@ func (s *userService) GetFriends(ctx context.Context, req *dto.GetFriendsRequest
 }
 
 func (s *userService) RemoveFriend(ctx context.Context, req *dto.AddFriendRequest) *helpers.CustomError {
+	return retryOnConflict(func() *helpers.CustomError {
+		return s.removeFriend(ctx, req)
+	})
+}
+
+func (s *userService) removeFriend(ctx context.Context, req *dto.AddFriendRequest) *helpers.CustomError {
 	if req.UserID == req.FriendID {
 		return helpers.BadRequest("user cannot remove themselves as a friend")
 	}
@@ -360,6 +376,15 @@ func (s *userService) UpdateUser(ctx context.Context, req model.User) *helpers.C
 	return nil
 }
 
+// fails with a bad request when the user can't afford it
+func (s *userService) DeductCash(ctx context.Context, userId uint32, amount model.Money) *helpers.CustomError {
+	return s.userRepo.DebitBalance(ctx, userId, amount, nil)
+}
+
+func (s *userService) WipeUser(ctx context.Context, userId uint32) *helpers.CustomError {
+	return s.userRepo.WipeUser(ctx, userId)
+}
+
 func (s *userService) ActivateAllUsers(ctx context.Context) *helpers.CustomError {
 	users, err := s.userRepo.GetUsers(ctx, model.User{
 		Deactivated: true,
@@ -370,6 +395,16 @@ func (s *userService) ActivateAllUsers(ctx context.Context) *helpers.CustomError
 	for _, user := range users {
 		user.Deactivated = false
 		err := s.userRepo.UpdateUser(ctx, user)
+		if err != nil && err.Code == http.StatusConflict {
+			err = retryOnConflict(func() *helpers.CustomError {
+				fresh, err := s.userRepo.GetUsers(ctx, model.User{UserId: user.UserId})
+				if err != nil || len(fresh) == 0 {
+					return err
+				}
+				fresh[0].Deactivated = false
+				return s.userRepo.UpdateUser(ctx, fresh[0])
+			})
+		}
 		if err != nil {
 			return err
 		}
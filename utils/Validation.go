@@ -2,7 +2,6 @@ package utils
 
 import (
 	"fmt"
-	"log"
 	"os"
 	"regexp"
 	"strings"
@@ -173,12 +172,87 @@ func ValidateAddCardRequest(req dto.AddCardRequest) (err *helpers.CustomError) {
 	if req.Image == nil {
 		return helpers.BadRequest("image is required")
 	}
+	maxSupply, ok := model.CardRarityMaxSupply[req.Rarity]
+	if !ok {
+		return helpers.BadRequest("rarity must be one of " + strings.Join(model.CardRarities, ", "))
+	}
+	if req.TotalCards > maxSupply {
+		return helpers.BadRequest(fmt.Sprintf("total cards of a %s card can be at most %d", req.Rarity, maxSupply))
+	}
 	if !IsAdmin(req.UserType) {
 		return helpers.Unauthorized("only admin can add cards")
 	}
 	return nil
 }
 
+func ValidateUpdateCardRequest(req dto.UpdateCardRequest) (err *helpers.CustomError) {
+	if len(strings.TrimSpace(req.CardNumber)) == 0 {
+		return helpers.BadRequest("card number is required")
+	}
+	if req.UserId == 0 {
+		return helpers.BadRequest("user ID is required")
+	}
+	if req.CardName == nil && req.CardDescription == nil && req.TotalCards == nil && req.Image == nil {
+		return helpers.BadRequest("nothing to update")
+	}
+	if req.CardName != nil && len(strings.TrimSpace(*req.CardName)) == 0 {
+		return helpers.BadRequest("card name cannot be empty")
+	}
+	if req.CardDescription != nil && len(strings.TrimSpace(*req.CardDescription)) == 0 {
+		return helpers.BadRequest("card description cannot be empty")
+	}
+	if req.TotalCards != nil && *req.TotalCards == 0 {
+		return helpers.BadRequest("total cards must be greater than zero")
+	}
+	return nil
+}
+
+func ValidateRetireCardRequest(req dto.RetireCardRequest) (err *helpers.CustomError) {
+	if len(strings.TrimSpace(req.CardNumber)) == 0 {
+		return helpers.BadRequest("card number is required")
+	}
+	if req.UserId == 0 {
+		return helpers.BadRequest("user ID is required")
+	}
+	return nil
+}
+
+func ValidateGetCardAuditsRequest(req dto.GetCardAuditsRequest) (err *helpers.CustomError) {
+	if len(strings.TrimSpace(req.CardNumber)) == 0 {
+		return helpers.BadRequest("card number is required")
+	}
+	if req.UserId == 0 {
+		return helpers.BadRequest("user ID is required")
+	}
+	return nil
+}
+
+func ValidateGetTopHoldersRequest(req dto.GetTopHoldersRequest) (err *helpers.CustomError) {
+	if len(strings.TrimSpace(req.CardNumber)) == 0 {
+		return helpers.BadRequest("card number is required")
+	}
+	if req.Limit < 1 || req.Limit > model.CARD_TOP_HOLDERS_MAX_LIMIT {
+		return helpers.BadRequest(fmt.Sprintf("limit must be between 1 and %d", model.CARD_TOP_HOLDERS_MAX_LIMIT))
+	}
+	return nil
+}
+
+func ValidateGetCatalogRequest(req dto.GetCatalogRequest) (err *helpers.CustomError) {
+	if req.Page < 1 {
+		return helpers.BadRequest("page must be at least 1")
+	}
+	if req.Limit < 1 || req.Limit > model.CARD_CATALOG_MAX_LIMIT {
+		return helpers.BadRequest(fmt.Sprintf("limit must be between 1 and %d", model.CARD_CATALOG_MAX_LIMIT))
+	}
+	if _, ok := model.CardRarityMaxSupply[req.Rarity]; req.Rarity != "" && !ok {
+		return helpers.BadRequest("rarity must be one of " + strings.Join(model.CardRarities, ", "))
+	}
+	if req.Sort != "" && req.Sort != model.CARD_SORT_NAME && req.Sort != model.CARD_SORT_SCARCITY && req.Sort != model.CARD_SORT_NEWEST {
+		return helpers.BadRequest("sort must be name, scarcity or newest")
+	}
+	return nil
+}
+
 func ValidateGetCardRequest(req dto.GetCardRequest) (err *helpers.CustomError) {
 	if len(strings.TrimSpace(req.CardNumber)) == 0 {
 		return helpers.BadRequest("card number is required")
@@ -205,6 +279,11 @@ func ValidateTransferCashRequest(req dto.TransferCashRequest) (err *helpers.Cust
 	if req.Status == model.TRANSACTION_STATUS_SUCCESS && req.UserId != req.GivenBy && req.GivenBy != 0 {
 		return helpers.BadRequest("only the user who is giving the amount can mark it as successful")
 	}
+	// the receiver of a pending transfer accepts it, so one started for somebody
+	// else's money could be accepted by its own creator
+	if req.GivenBy != 0 && req.UserId != req.GivenBy && req.LoanId == 0 {
+		return helpers.BadRequest("only the user who is giving the amount can start a transfer")
+	}
 	return nil
 }
 
@@ -233,22 +312,35 @@ func ValidateTransferCardsRequest(req dto.TransferCardRequest) (err *helpers.Cus
 	if req.Status == model.TRANSACTION_STATUS_SUCCESS && (req.GivenBy != 0 && req.UserId != req.GivenBy) {
 		return helpers.BadRequest("only the user who is giving the cards can mark it as successful")
 	}
+	if req.GivenBy != 0 && req.UserId != req.GivenBy && req.LoanId == 0 {
+		return helpers.BadRequest("only the user who is giving the cards can start a transfer")
+	}
 	return nil
 }
 
-func ValidateGiveCardsRequest(req dto.TransferCardRequest) (err *helpers.CustomError) {
-	if len(req.Cards) == 0 {
-		return helpers.BadRequest("at least one card is required to give")
-	}
-	if req.GivenTo == 0 {
-		return helpers.BadRequest("given to user ID is required")
-	}
-	if req.GivenBy == req.GivenTo {
-		return helpers.BadRequest("given by and given to user IDs cannot be the same")
-	}
+func ValidateGiveCardsRequest(req dto.GiveCardsRequest) (err *helpers.CustomError) {
 	if req.UserId == 0 {
 		return helpers.BadRequest("user ID is required")
 	}
+	if len(req.Grants) == 0 {
+		return helpers.BadRequest("at least one grant is required")
+	}
+	for _, grant := range req.Grants {
+		if grant.GivenTo == 0 {
+			return helpers.BadRequest("given to user ID is required")
+		}
+		if grant.GivenTo == req.UserId {
+			return helpers.BadRequest("admin cannot give cards to themselves")
+		}
+		if len(grant.Cards) == 0 {
+			return helpers.BadRequest("at least one card is required to give")
+		}
+		for _, card := range grant.Cards {
+			if card.CardNumber == "" || card.Amount == 0 {
+				return helpers.BadRequest("card number and a positive amount are required")
+			}
+		}
+	}
 	return nil
 }
 
@@ -271,15 +363,55 @@ func ValidateExchangeRequest(req dto.ExchangeRequest) (err *helpers.CustomError)
 	if req.CashRecieved < 0 {
 		return helpers.BadRequest("cash received cannot be negative")
 	}
-	if req.CashSent > 0 && req.CashRecieved > 0 {
-		return helpers.BadRequest("either cash sent or cash received must be zero")
-	}
 	if req.UserId != req.GivenBy && req.UserId != req.GivenTo {
 		return helpers.BadRequest("user ID must be either the giver or receiver")
 	}
 	return nil
 }
 
+func ValidateGetTransactionsRequest(req dto.GetTransactionsRequest) (err *helpers.CustomError) {
+	if req.UserId == 0 {
+		return helpers.BadRequest("user ID is required")
+	}
+	if req.Limit < 0 || req.Limit > model.TRANSACTION_HISTORY_MAX_LIMIT {
+		return helpers.BadRequest(fmt.Sprintf("limit must be between 1 and %d", model.TRANSACTION_HISTORY_MAX_LIMIT))
+	}
+	if req.Status != "" {
+		known := false
+		for _, status := range model.TransactionStatuses {
+			if req.Status == status {
+				known = true
+			}
+		}
+		if !known {
+			return helpers.BadRequest("invalid transaction status")
+		}
+	}
+	if req.Direction != "" && req.Direction != model.TRANSACTION_DIRECTION_SENT && req.Direction != model.TRANSACTION_DIRECTION_RECEIVED {
+		return helpers.BadRequest("direction must be sent or received")
+	}
+	if req.Counterparty == req.UserId {
+		return helpers.BadRequest("counterparty cannot be the user")
+	}
+	if !req.From.IsZero() && !req.To.IsZero() && !req.From.Before(req.To) {
+		return helpers.BadRequest("from must be before to")
+	}
+	return nil
+}
+
+func ValidateExportTransactionsRequest(req dto.ExportTransactionsRequest) (err *helpers.CustomError) {
+	if req.UserId == 0 {
+		return helpers.BadRequest("user ID is required")
+	}
+	if req.TargetUserId == 0 {
+		return helpers.BadRequest("target user ID is required")
+	}
+	if req.Format != model.TRANSACTION_EXPORT_FORMAT_CSV && req.Format != model.TRANSACTION_EXPORT_FORMAT_JSONL {
+		return helpers.BadRequest("format must be csv or jsonl")
+	}
+	return nil
+}
+
 func ValidateGetPossibleExchangeRequest(req dto.GetPossibleExchangeRequest) (err *helpers.CustomError) {
 	if req.UserId == 0 {
 		return helpers.BadRequest("user ID is required")
@@ -303,8 +435,91 @@ func ValidateExecuteExchangeRequest(req dto.ExecuteExchangeRequest) (err *helper
 	return nil
 }
 
+func ValidateRespondTransferRequest(req dto.RespondTransferRequest) (err *helpers.CustomError) {
+	if req.UserId == 0 {
+		return helpers.BadRequest("user ID is required")
+	}
+	if req.TransactionGuid == 0 {
+		return helpers.BadRequest("transaction ID is required")
+	}
+	return nil
+}
+
+func ValidateCancelExchangeRequest(req dto.CancelExchangeRequest) (err *helpers.CustomError) {
+	if req.UserId == 0 {
+		return helpers.BadRequest("user ID is required")
+	}
+	if req.TransactionGuid == 0 {
+		return helpers.BadRequest("transaction ID is required")
+	}
+	return nil
+}
+
+func ValidateCounterExchangeRequest(req dto.CounterExchangeRequest) (err *helpers.CustomError) {
+	if req.UserId == 0 {
+		return helpers.BadRequest("user ID is required")
+	}
+	if req.TransactionGuid == 0 {
+		return helpers.BadRequest("transaction ID is required")
+	}
+	if req.CashSent < 0 {
+		return helpers.BadRequest("cash sent cannot be negative")
+	}
+	if req.CashRecieved < 0 {
+		return helpers.BadRequest("cash received cannot be negative")
+	}
+	return nil
+}
+
+func ValidateTradeRequest(req dto.TradeRequest) (err *helpers.CustomError) {
+	if req.UserId == 0 {
+		return helpers.BadRequest("user ID is required")
+	}
+	if len(req.Legs) == 0 {
+		return helpers.BadRequest("at least one leg is required for a trade")
+	}
+	participants := make(map[uint32]bool)
+	for _, leg := range req.Legs {
+		if leg.GivenBy == 0 || leg.GivenTo == 0 {
+			return helpers.BadRequest("given by and given to user IDs are required on every leg")
+		}
+		if leg.GivenBy == leg.GivenTo {
+			return helpers.BadRequest("given by and given to user IDs cannot be the same")
+		}
+		if leg.Cash < 0 {
+			return helpers.BadRequest("cash cannot be negative")
+		}
+		if leg.Cash == 0 && len(leg.Cards) == 0 {
+			return helpers.BadRequest("every leg must give cash or cards")
+		}
+		for _, card := range leg.Cards {
+			if card.CardNumber == "" || card.Amount == 0 {
+				return helpers.BadRequest("card number and a positive amount are required")
+			}
+		}
+		participants[leg.GivenBy] = true
+		participants[leg.GivenTo] = true
+	}
+	if len(participants) < 3 {
+		return helpers.BadRequest("a trade needs at least three participants, use an exchange between two users")
+	}
+	if !participants[req.UserId] {
+		return helpers.BadRequest("user ID must be one of the participants")
+	}
+	return nil
+}
+
+func ValidateRespondTradeRequest(req dto.RespondTradeRequest) (err *helpers.CustomError) {
+	if req.UserId == 0 {
+		return helpers.BadRequest("user ID is required")
+	}
+	if req.TransactionGuid == 0 {
+		return helpers.BadRequest("transaction ID is required")
+	}
+	return nil
+}
+
 func IsValidTransactionConfirmer(req dto.IsvalidTransactionConfirmerRequest) (err *helpers.CustomError) {
-	log.Printf("Validating transaction confirmer: %+v\n", req)
 	if req.UserId != req.GivenBy && req.UserId != req.GivenTo {
 		return helpers.BadRequest("user ID must be either the giver or receiver")
 	}
@@ -313,3 +528,94 @@ func IsValidTransactionConfirmer(req dto.IsvalidTransactionConfirmerRequest) (er
 	}
 	return nil
 }
+
+func ValidateRequestLoanRequest(req dto.RequestLoanRequest) (err *helpers.CustomError) {
+	if req.UserId == 0 {
+		return helpers.BadRequest("user ID is required")
+	}
+	if req.LenderId == 0 {
+		return helpers.BadRequest("lender ID is required")
+	}
+	if req.LenderId == req.UserId {
+		return helpers.BadRequest("user cannot request a loan from themselves")
+	}
+	if req.Amount <= 0 {
+		return helpers.BadRequest("amount must be greater than zero")
+	}
+	if req.Rate < 0 || req.Rate > 1 {
+		return helpers.BadRequest("rate must be between 0 and 1")
+	}
+	if len(req.Collateral) > 0 && req.DurationDays == 0 {
+		return helpers.BadRequest("duration in days is required for a collateralized loan")
+	}
+	collateralCards := make(map[string]bool)
+	for _, card := range req.Collateral {
+		if len(strings.TrimSpace(card.CardNumber)) == 0 {
+			return helpers.BadRequest("collateral card number is required")
+		}
+		if card.Amount == 0 {
+			return helpers.BadRequest("collateral card amount must be greater than zero")
+		}
+		if collateralCards[card.CardNumber] {
+			return helpers.BadRequest("collateral card listed more than once: " + card.CardNumber)
+		}
+		collateralCards[card.CardNumber] = true
+	}
+	return nil
+}
+
+func ValidateRespondLoanRequest(req dto.RespondLoanRequest) (err *helpers.CustomError) {
+	if req.UserId == 0 {
+		return helpers.BadRequest("user ID is required")
+	}
+	if req.LoanId == 0 {
+		return helpers.BadRequest("loan ID is required")
+	}
+	return nil
+}
+
+func ValidateRepayLoanRequest(req dto.RepayLoanRequest) (err *helpers.CustomError) {
+	if req.UserId == 0 {
+		return helpers.BadRequest("user ID is required")
+	}
+	if req.LoanId == 0 {
+		return helpers.BadRequest("loan ID is required")
+	}
+	if req.Amount <= 0 {
+		return helpers.BadRequest("amount must be greater than zero")
+	}
+	return nil
+}
+
+func ValidateGetLoansRequest(req dto.GetLoansRequest) (err *helpers.CustomError) {
+	if req.UserId == 0 {
+		return helpers.BadRequest("user ID is required")
+	}
+	if req.Role != "" && req.Role != model.LOAN_ROLE_LENDER && req.Role != model.LOAN_ROLE_BORROWER {
+		return helpers.BadRequest("role must be either lender or borrower")
+	}
+	return nil
+}
+
+func ValidateReconcileBalanceRequest(req dto.ReconcileBalanceRequest) (err *helpers.CustomError) {
+	if req.UserId == 0 {
+		return helpers.BadRequest("user ID is required")
+	}
+	if req.TargetUserId == 0 {
+		return helpers.BadRequest("target user ID is required")
+	}
+	return nil
+}
+
+func ValidateStatementRequest(req dto.StatementRequest) (err *helpers.CustomError) {
+	if req.UserId == 0 {
+		return helpers.BadRequest("user ID is required")
+	}
+	if req.TargetUserId == 0 {
+		return helpers.BadRequest("target user ID is required")
+	}
+	if _, perr := time.Parse(model.STATEMENT_MONTH_FORMAT, req.Month); perr != nil {
+		return helpers.BadRequest("month must be in YYYY-MM format")
+	}
+	return nil
+}
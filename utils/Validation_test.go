@@ -0,0 +1,101 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/ChronoPlay/chronoplay-backend-service/dto"
+	model "github.com/ChronoPlay/chronoplay-backend-service/model"
+)
+
+const (
+	victim   uint32 = 1
+	attacker uint32 = 2
+)
+
+// a pending transfer of the victim's money to the attacker would be accepted by the
+// attacker as its receiver, so it must not be created in the first place
+func TestTransferOfSomebodyElsesBalanceIsRejected(t *testing.T) {
+	for _, status := range []string{"", model.TRANSACTION_STATUS_PENDING, model.TRANSACTION_STATUS_SUCCESS} {
+		err := ValidateTransferCashRequest(dto.TransferCashRequest{
+			Amount:  100,
+			GivenBy: victim,
+			GivenTo: attacker,
+			Status:  status,
+			UserId:  attacker,
+		})
+		if err == nil || err.Code != 400 {
+			t.Errorf("cash transfer with status %q: got %v, want a bad request", status, err)
+		}
+		err = ValidateTransferCardsRequest(dto.TransferCardRequest{
+			Cards:   []dto.TransferCard{{CardNumber: "c1", Amount: 1}},
+			GivenBy: victim,
+			GivenTo: attacker,
+			Status:  status,
+			UserId:  attacker,
+		})
+		if err == nil || err.Code != 400 {
+			t.Errorf("card transfer with status %q: got %v, want a bad request", status, err)
+		}
+	}
+}
+
+func TestTransferByTheGiverIsAllowed(t *testing.T) {
+	for _, status := range []string{model.TRANSACTION_STATUS_PENDING, model.TRANSACTION_STATUS_SUCCESS} {
+		if err := ValidateTransferCashRequest(dto.TransferCashRequest{
+			Amount:  100,
+			GivenBy: victim,
+			GivenTo: attacker,
+			Status:  status,
+			UserId:  victim,
+		}); err != nil {
+			t.Errorf("cash transfer with status %q: %v", status, err)
+		}
+		if err := ValidateTransferCardsRequest(dto.TransferCardRequest{
+			Cards:   []dto.TransferCard{{CardNumber: "c1", Amount: 1}},
+			GivenBy: victim,
+			GivenTo: attacker,
+			Status:  status,
+			UserId:  victim,
+		}); err != nil {
+			t.Errorf("card transfer with status %q: %v", status, err)
+		}
+	}
+}
+
+// loan moves are started by the loan service on behalf of the paying side
+func TestPendingLoanTransferIsAllowed(t *testing.T) {
+	err := ValidateTransferCashRequest(dto.TransferCashRequest{
+		Amount:  100,
+		GivenBy: victim,
+		GivenTo: attacker,
+		Status:  model.TRANSACTION_STATUS_PENDING,
+		UserId:  attacker,
+		LoanId:  7,
+	})
+	if err != nil {
+		t.Errorf("loan transfer: %v", err)
+	}
+}
+
+// the transaction is created by the giver, so only the receiver can accept it
+func TestTransferConfirmer(t *testing.T) {
+	cases := []struct {
+		userId uint32
+		valid  bool
+	}{
+		{userId: victim, valid: false},
+		{userId: attacker, valid: true},
+		{userId: 3, valid: false},
+	}
+	for _, c := range cases {
+		err := IsValidTransactionConfirmer(dto.IsvalidTransactionConfirmerRequest{
+			UserId:    c.userId,
+			CreatedBy: victim,
+			GivenBy:   victim,
+			GivenTo:   attacker,
+		})
+		if (err == nil) != c.valid {
+			t.Errorf("user %d: got %v, want valid %v", c.userId, err, c.valid)
+		}
+	}
+}
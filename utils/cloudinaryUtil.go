@@ -11,11 +11,19 @@ import (
 	"github.com/cloudinary/cloudinary-go/v2/api/uploader"
 )
 
+type cloudinaryImageStorage struct{}
+
+// uploads to the cloud named by CLOUDINARY_CLOUD_NAME, the credentials are read on
+// every upload
+func NewCloudinaryImageStorage() ImageStorage {
+	return &cloudinaryImageStorage{}
+}
+
 func getCloudinaryURL() string {
 	return fmt.Sprintf("cloudinary://%s:%s@%s", os.Getenv("CLOUDINARY_API_KEY"), os.Getenv("CLOUDINARY_API_SECRET"), os.Getenv("CLOUDINARY_CLOUD_NAME"))
 }
 
-func UploadImageToCloudinary(ctx context.Context, image *multipart.FileHeader) (string, *helpers.CustomError) {
+func (storage *cloudinaryImageStorage) Upload(ctx context.Context, image *multipart.FileHeader) (string, *helpers.CustomError) {
 	src, err := image.Open()
 	if err != nil {
 		return "", helpers.System("Failed to open image file: " + err.Error())
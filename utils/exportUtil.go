@@ -0,0 +1,154 @@
+package utils
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ChronoPlay/chronoplay-backend-service/dto"
+	model "github.com/ChronoPlay/chronoplay-backend-service/model"
+)
+
+// TransactionExportWriter streams a history export to a response. Rows are buffered
+// until Flush, which also pushes them to the client, so an export is written page by
+// page. The headers are only sent with the first row or flush, until then an error
+// can still be answered as json.
+type TransactionExportWriter interface {
+	Write(row dto.TransactionExportRow) error
+	Flush() error
+}
+
+var transactionExportColumns = []string{
+	"transaction_guid", "type", "status", "transaction_with", "counterparty_username",
+	"participants", "cards_sent", "cards_recieved", "cash_sent", "cash_recieved",
+	"loan_id", "time", "expires_at",
+}
+
+func NewTransactionExportWriter(w http.ResponseWriter, format string, fileName string) TransactionExportWriter {
+	export := transactionExport{
+		response: w,
+		buffer:   bufio.NewWriter(w),
+		format:   format,
+		fileName: fileName + "." + format,
+	}
+	if format == model.TRANSACTION_EXPORT_FORMAT_CSV {
+		export.csv = csv.NewWriter(export.buffer)
+		return &csvTransactionExport{transactionExport: export}
+	}
+	export.encoder = json.NewEncoder(export.buffer)
+	return &jsonlTransactionExport{transactionExport: export}
+}
+
+type transactionExport struct {
+	response http.ResponseWriter
+	buffer   *bufio.Writer
+	csv      *csv.Writer
+	encoder  *json.Encoder
+	format   string
+	fileName string
+	started  bool
+}
+
+func (export *transactionExport) start() error {
+	if export.started {
+		return nil
+	}
+	export.started = true
+	contentType := "application/x-ndjson"
+	if export.format == model.TRANSACTION_EXPORT_FORMAT_CSV {
+		contentType = "text/csv"
+	}
+	export.response.Header().Set("Content-Type", contentType)
+	export.response.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", export.fileName))
+	if export.csv != nil {
+		return export.csv.Write(transactionExportColumns)
+	}
+	return nil
+}
+
+func (export *transactionExport) Flush() error {
+	if err := export.start(); err != nil {
+		return err
+	}
+	if export.csv != nil {
+		export.csv.Flush()
+		if err := export.csv.Error(); err != nil {
+			return err
+		}
+	}
+	if err := export.buffer.Flush(); err != nil {
+		return err
+	}
+	if flusher, ok := export.response.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return nil
+}
+
+type csvTransactionExport struct {
+	transactionExport
+}
+
+func (export *csvTransactionExport) Write(row dto.TransactionExportRow) error {
+	if err := export.start(); err != nil {
+		return err
+	}
+	participants := make([]string, len(row.Participants))
+	for i, participant := range row.Participants {
+		participants[i] = strconv.FormatUint(uint64(participant), 10)
+	}
+	return export.csv.Write([]string{
+		strconv.FormatUint(uint64(row.TransactionGuid), 10),
+		row.Type,
+		row.Status,
+		strconv.FormatUint(uint64(row.TransactionWith), 10),
+		row.CounterpartyUsername,
+		strings.Join(participants, ";"),
+		formatExportCards(row.CardsSent),
+		formatExportCards(row.CardsRecieved),
+		row.CashSent.String(),
+		row.CashRecieved.String(),
+		formatExportId(row.LoanId),
+		formatExportTime(row.Time),
+		formatExportTime(row.ExpiresAt),
+	})
+}
+
+type jsonlTransactionExport struct {
+	transactionExport
+}
+
+func (export *jsonlTransactionExport) Write(row dto.TransactionExportRow) error {
+	if err := export.start(); err != nil {
+		return err
+	}
+	return export.encoder.Encode(row)
+}
+
+// cards as card_number:amount separated by semicolons
+func formatExportCards(cards []dto.Card) string {
+	values := make([]string, len(cards))
+	for i, card := range cards {
+		values[i] = fmt.Sprintf("%s:%d", card.CardNumber, card.Amount)
+	}
+	return strings.Join(values, ";")
+}
+
+func formatExportId(id uint32) string {
+	if id == 0 {
+		return ""
+	}
+	return strconv.FormatUint(uint64(id), 10)
+}
+
+func formatExportTime(value time.Time) string {
+	if value.IsZero() {
+		return ""
+	}
+	return value.UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,123 @@
+package utils
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ChronoPlay/chronoplay-backend-service/dto"
+	model "github.com/ChronoPlay/chronoplay-backend-service/model"
+)
+
+func exportRows() []dto.TransactionExportRow {
+	return []dto.TransactionExportRow{
+		{
+			Transaction: dto.Transaction{
+				TransactionGuid: 42,
+				CardsSent:       []dto.Card{{CardNumber: "c1", Amount: 2}, {CardNumber: "c2", Amount: 1}},
+				CashRecieved:    1250,
+				TransactionWith: 7,
+				Time:            time.Date(2026, 3, 10, 12, 30, 0, 0, time.FixedZone("IST", 5*3600+1800)),
+				Status:          model.TRANSACTION_STATUS_SUCCESS,
+				Type:            "exchange",
+				Participants:    []uint32{3, 7},
+			},
+			CounterpartyUsername: "bob, the trader",
+		},
+		{
+			Transaction: dto.Transaction{
+				TransactionGuid: 41,
+				CashSent:        -5,
+				TransactionWith: 9,
+				Time:            time.Date(2026, 3, 9, 0, 0, 0, 0, time.UTC),
+				Status:          model.TRANSACTION_STATUS_PENDING,
+				LoanId:          3,
+				ExpiresAt:       time.Date(2026, 3, 12, 0, 0, 0, 0, time.UTC),
+			},
+		},
+	}
+}
+
+func TestCsvTransactionExport(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	writer := NewTransactionExportWriter(recorder, model.TRANSACTION_EXPORT_FORMAT_CSV, "transactions_3")
+	for _, row := range exportRows() {
+		if err := writer.Write(row); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+
+	if got := recorder.Header().Get("Content-Type"); got != "text/csv" {
+		t.Errorf("Content-Type = %q", got)
+	}
+	if got := recorder.Header().Get("Content-Disposition"); got != `attachment; filename="transactions_3.csv"` {
+		t.Errorf("Content-Disposition = %q", got)
+	}
+	records, err := csv.NewReader(recorder.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("read csv: %v", err)
+	}
+	want := [][]string{
+		{"transaction_guid", "type", "status", "transaction_with", "counterparty_username", "participants", "cards_sent", "cards_recieved", "cash_sent", "cash_recieved", "loan_id", "time", "expires_at"},
+		{"42", "exchange", "success", "7", "bob, the trader", "3;7", "c1:2;c2:1", "", "0.00", "12.50", "", "2026-03-10T07:00:00Z", ""},
+		{"41", "", "pending", "9", "", "", "", "", "-0.05", "0.00", "3", "2026-03-09T00:00:00Z", "2026-03-12T00:00:00Z"},
+	}
+	if !reflect.DeepEqual(records, want) {
+		t.Errorf("csv rows:\n got %q\nwant %q", records, want)
+	}
+}
+
+func TestJsonlTransactionExport(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	writer := NewTransactionExportWriter(recorder, model.TRANSACTION_EXPORT_FORMAT_JSONL, "transactions_3")
+	rows := exportRows()
+	for _, row := range rows {
+		if err := writer.Write(row); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+
+	if got := recorder.Header().Get("Content-Type"); got != "application/x-ndjson" {
+		t.Errorf("Content-Type = %q", got)
+	}
+	if got := recorder.Header().Get("Content-Disposition"); got != `attachment; filename="transactions_3.jsonl"` {
+		t.Errorf("Content-Disposition = %q", got)
+	}
+	lines := strings.Split(strings.TrimSuffix(recorder.Body.String(), "\n"), "\n")
+	if len(lines) != len(rows) {
+		t.Fatalf("got %d lines, want %d:\n%s", len(lines), len(rows), recorder.Body.String())
+	}
+	if !strings.Contains(lines[0], `"cash_recieved":12.50`) || !strings.Contains(lines[0], `"counterparty_username":"bob, the trader"`) {
+		t.Errorf("first line = %s", lines[0])
+	}
+	for i, line := range lines {
+		var decoded dto.TransactionExportRow
+		if err := json.Unmarshal([]byte(line), &decoded); err != nil {
+			t.Fatalf("line %d is not json: %v", i, err)
+		}
+		if decoded.TransactionGuid != rows[i].TransactionGuid || decoded.CashSent != rows[i].CashSent ||
+			decoded.CashRecieved != rows[i].CashRecieved || decoded.CounterpartyUsername != rows[i].CounterpartyUsername ||
+			!decoded.Time.Equal(rows[i].Time) || !reflect.DeepEqual(decoded.CardsSent, rows[i].CardsSent) {
+			t.Errorf("line %d = %s, want row %+v", i, line, rows[i])
+		}
+	}
+}
+
+// nothing is sent before the first row, so an error can still be answered as json
+func TestTransactionExportSendsNothingUntilWritten(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	NewTransactionExportWriter(recorder, model.TRANSACTION_EXPORT_FORMAT_CSV, "transactions_3")
+	if recorder.Header().Get("Content-Type") != "" || recorder.Body.Len() != 0 {
+		t.Errorf("headers %v and body %q sent before any row", recorder.Header(), recorder.Body.String())
+	}
+}
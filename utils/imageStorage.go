@@ -0,0 +1,81 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ChronoPlay/chronoplay-backend-service/helpers"
+)
+
+// ImageStorage keeps uploaded card images and returns the url they are served from
+type ImageStorage interface {
+	Upload(ctx context.Context, image *multipart.FileHeader) (string, *helpers.CustomError)
+}
+
+type localImageStorage struct {
+	dir     string
+	baseUrl string
+}
+
+// NewLocalImageStorage writes images below dir/cards. The router has to serve dir
+// under route, baseUrl (e.g. http://localhost:8080) is put in front of it in the
+// returned urls and may be empty for urls relative to the API.
+func NewLocalImageStorage(dir string, route string, baseUrl string) ImageStorage {
+	return &localImageStorage{
+		dir:     dir,
+		baseUrl: strings.TrimSuffix(baseUrl, "/") + route,
+	}
+}
+
+// extension stored for each sniffed content type that may be uploaded. Anything else,
+// svg included, could run script when served from the API origin.
+var imageExtensions = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// the file name is prefixed with the upload time, so uploads with the same name don't
+// replace the image of another card. The extension comes from the sniffed content, not
+// from the uploaded name, so the file is always served as an image.
+func (storage *localImageStorage) Upload(ctx context.Context, image *multipart.FileHeader) (string, *helpers.CustomError) {
+	src, err := image.Open()
+	if err != nil {
+		return "", helpers.System("Failed to open image file: " + err.Error())
+	}
+	defer src.Close()
+	head := make([]byte, 512)
+	n, err := io.ReadFull(src, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", helpers.SystemError("Failed to read image file", err)
+	}
+	extension, ok := imageExtensions[http.DetectContentType(head[:n])]
+	if !ok {
+		return "", helpers.BadRequest("image must be a png, jpeg, gif or webp file")
+	}
+	dir := filepath.Join(storage.dir, "cards")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", helpers.SystemError("Failed to create image directory", err)
+	}
+	baseName := filepath.Base(image.Filename)
+	baseName = strings.TrimSuffix(baseName, filepath.Ext(baseName))
+	name := fmt.Sprintf("%d-%s%s", time.Now().UnixNano(), strings.ReplaceAll(baseName, " ", "_"), extension)
+	dst, err := os.Create(filepath.Join(dir, name))
+	if err != nil {
+		return "", helpers.SystemError("Failed to create image file", err)
+	}
+	defer dst.Close()
+	if _, err := io.Copy(dst, io.MultiReader(bytes.NewReader(head[:n]), src)); err != nil {
+		return "", helpers.SystemError("Failed to store image", err)
+	}
+	return storage.baseUrl + "/cards/" + name, nil
+}
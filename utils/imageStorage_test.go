@@ -0,0 +1,99 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// builds the file header gin hands to the card service for an uploaded file
+func uploadedFile(t *testing.T, fileName string, content []byte) *multipart.FileHeader {
+	t.Helper()
+	body := &bytes.Buffer{}
+	form := multipart.NewWriter(body)
+	part, err := form.CreateFormFile("image", fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := part.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	if err := form.Close(); err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := multipart.NewReader(body, form.Boundary()).ReadForm(1 << 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { parsed.RemoveAll() })
+	return parsed.File["image"][0]
+}
+
+func pngImage(t *testing.T) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	img.Set(0, 0, color.RGBA{R: 255, A: 255})
+	buffer := &bytes.Buffer{}
+	if err := png.Encode(buffer, img); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+func TestLocalImageStorageStoresImages(t *testing.T) {
+	dir := t.TempDir()
+	storage := NewLocalImageStorage(dir, "/images", "http://localhost:8080/")
+	content := pngImage(t)
+
+	// the extension follows the content, not the uploaded name
+	url, err := storage.Upload(context.Background(), uploadedFile(t, "my card.jpeg", content))
+	if err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+	if !strings.HasPrefix(url, "http://localhost:8080/images/cards/") || !strings.HasSuffix(url, "-my_card.png") {
+		t.Errorf("url = %q", url)
+	}
+	stored, rerr := os.ReadFile(filepath.Join(dir, "cards", strings.TrimPrefix(url, "http://localhost:8080/images/cards/")))
+	if rerr != nil {
+		t.Fatalf("stored file: %v", rerr)
+	}
+	if !bytes.Equal(stored, content) {
+		t.Errorf("stored %d bytes, want the %d uploaded", len(stored), len(content))
+	}
+
+	other, err := storage.Upload(context.Background(), uploadedFile(t, "my card.jpeg", content))
+	if err != nil {
+		t.Fatalf("second upload: %v", err)
+	}
+	if other == url {
+		t.Errorf("second upload with the same name replaced %q", url)
+	}
+}
+
+func TestLocalImageStorageRejectsOtherFiles(t *testing.T) {
+	dir := t.TempDir()
+	storage := NewLocalImageStorage(dir, "/images", "")
+	files := map[string][]byte{
+		"card.html": []byte("<html><script>alert(1)</script></html>"),
+		"card.svg":  []byte(`<svg xmlns="http://www.w3.org/2000/svg" onload="alert(1)"></svg>`),
+		"card.png":  []byte("<script>alert(1)</script>"),
+		"empty.png": {},
+	}
+	for name, content := range files {
+		url, err := storage.Upload(context.Background(), uploadedFile(t, name, content))
+		if err == nil || err.Code != 400 {
+			t.Errorf("%s: got %q and %v, want a bad request", name, url, err)
+		}
+	}
+	entries, _ := os.ReadDir(filepath.Join(dir, "cards"))
+	if len(entries) != 0 {
+		t.Errorf("rejected uploads left %d files behind", len(entries))
+	}
+}
@@ -0,0 +1,62 @@
+package utils
+
+import (
+	"bytes"
+	"html/template"
+	"time"
+
+	"github.com/ChronoPlay/chronoplay-backend-service/dto"
+)
+
+var statementTemplate = template.Must(template.New("statement").Funcs(template.FuncMap{
+	"date": func(value time.Time) string {
+		return value.UTC().Format("2006-01-02 15:04 MST")
+	},
+}).Parse(`
+<html>
+<body>
+<p>Hello {{.UserName}},</p>
+
+<p>Here is your ChronoPlay statement for {{.Month}} ({{date .From}} to {{date .To}}).</p>
+
+<h3>Cash</h3>
+<table cellpadding="4" border="1" style="border-collapse: collapse;">
+<tr><td>Opening balance</td><td>{{.OpeningCash}}</td></tr>
+<tr><td>Money in</td><td>{{.CashIn}}</td></tr>
+<tr><td>Money out</td><td>{{.CashOut}}</td></tr>
+<tr><td>of which survival tax</td><td>{{.SurvivalTax}}</td></tr>
+<tr><td>Closing balance</td><td>{{.ClosingCash}}</td></tr>
+</table>
+
+{{if .CashMovements}}
+<table cellpadding="4" border="1" style="border-collapse: collapse;">
+<tr><th>Time</th><th>Transaction</th><th>Kind</th><th>With</th><th>Amount</th><th>Balance</th></tr>
+{{range .CashMovements}}<tr><td>{{date .Time}}</td><td>{{if .TransactionGuid}}{{.TransactionGuid}}{{end}}</td><td>{{.Kind}}</td><td>{{.Counterparty}}</td><td>{{.Amount}}</td><td>{{.Balance}}</td></tr>
+{{end}}</table>
+{{end}}
+
+<h3>Cards</h3>
+{{if .CardMovements}}
+<table cellpadding="4" border="1" style="border-collapse: collapse;">
+<tr><th>Time</th><th>Transaction</th><th>Kind</th><th>With</th><th>Card</th><th>Quantity</th></tr>
+{{range .CardMovements}}<tr><td>{{date .Time}}</td><td>{{if .TransactionGuid}}{{.TransactionGuid}}{{end}}</td><td>{{.Kind}}</td><td>{{.Counterparty}}</td><td>{{.CardNumber}}</td><td>{{.Quantity}}</td></tr>
+{{end}}</table>
+{{else}}
+<p>No cards moved this month.</p>
+{{end}}
+
+<p>Cards held at the end of the month:{{range .ClosingCards}} {{.CardNumber}} x{{.Amount}};{{else}} none{{end}}</p>
+
+<p>Best regards,<br>
+The ChronoPlay Team</p>
+</body>
+</html>
+`))
+
+func RenderStatementHtml(statement dto.Statement) (string, error) {
+	var body bytes.Buffer
+	if err := statementTemplate.Execute(&body, statement); err != nil {
+		return "", err
+	}
+	return body.String(), nil
+}